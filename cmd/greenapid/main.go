@@ -1,18 +1,117 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
 
 	"github.com/jbowens/codenamesgreen/gameapi"
 )
 
+// urlFlags collects repeated -wordlist-url name=url flags.
+type urlFlags map[string]string
+
+func (f urlFlags) String() string { return fmt.Sprint(map[string]string(f)) }
+
+func (f urlFlags) Set(v string) error {
+	name, url, ok := strings.Cut(v, "=")
+	if !ok || name == "" || url == "" {
+		return fmt.Errorf("expected name=url, got %q", v)
+	}
+	f[name] = url
+	return nil
+}
+
 func main() {
-	wordLists, err := gameapi.DefaultWordlists()
+	wordlistURLs := urlFlags{}
+	flag.Var(wordlistURLs, "wordlist-url", "load a word list from a URL, as name=url (may be repeated)")
+	maxPlayers := flag.Int("max-players-per-team", 0, "maximum number of players on each team, or 0 for no limit")
+	cryptoSeeds := flag.Bool("crypto-seeds", false, "pick game seeds from a cryptographic source")
+	lazyWordlists := flag.Int("lazy-wordlists", 0, "load word lists from the wordlists directory on first use, keeping at most this many in memory, or 0 to load them all up front")
+	gameOverWebhook := flag.String("game-over-webhook", "", "URL to POST the result of each game to when it ends")
+	verbose := flag.Bool("verbose", false, "log diagnostic output, such as each sweep of inactive games")
+	adminToken := flag.String("admin-token", os.Getenv("GREENAPID_ADMIN_TOKEN"), "bearer token that enables the /admin/ endpoints, or empty to disable them (defaults to $GREENAPID_ADMIN_TOKEN)")
+	sqlitePath := flag.String("sqlite", "", "persist games to the SQLite database at this path, or empty to keep them in memory (requires building with -tags sqlite)")
+	rotateAfter := flag.Duration("rotate-games-after", 0, "let finished games, and games idle for this long, be replaced without their seed, or 0 to never do so")
+	flag.Parse()
+	if *lazyWordlists > 0 && len(wordlistURLs) > 0 {
+		log.Fatal("-lazy-wordlists loads lists from the wordlists directory, and can't be combined with -wordlist-url")
+	}
+
+	var wordLists map[string][]string
+	var err error
+	switch {
+	case *lazyWordlists > 0:
+		// The handler loads the lists as they're used.
+	case len(wordlistURLs) > 0:
+		wordLists, err = gameapi.WordlistsFromURLs(wordlistURLs)
+	default:
+		wordLists, err = gameapi.DefaultWordlists()
+	}
+	if err != nil {
+		panic(err)
+	}
+	meta, err := gameapi.DefaultWordlistMetadata()
 	if err != nil {
 		panic(err)
 	}
 
-	h := gameapi.Handler(wordLists)
+	opts := []gameapi.Option{
+		gameapi.WithWordlistMetadata(meta),
+		gameapi.WithMaxPlayersPerTeam(*maxPlayers),
+	}
+	if *cryptoSeeds {
+		opts = append(opts, gameapi.WithCryptoSeeds())
+	}
+	if *lazyWordlists > 0 {
+		loader := gameapi.NewFSWordlistLoader(os.DirFS("wordlists"))
+		if names, err := loader.Names(); err == nil && len(names) > *lazyWordlists {
+			log.Printf("-lazy-wordlists=%d is less than the %d word lists, so games drawn from all of them will reload each list",
+				*lazyWordlists, len(names))
+		}
+		opts = append(opts, gameapi.WithLazyWordlists(loader, *lazyWordlists))
+	}
+	if *verbose {
+		opts = append(opts, gameapi.WithVerboseLog(log.Default()))
+	}
+	if *gameOverWebhook != "" {
+		opts = append(opts, gameapi.WithGameOverWebhook(*gameOverWebhook))
+	}
+	if *sqlitePath != "" {
+		store, err := openSQLiteStore(*sqlitePath)
+		if err != nil {
+			log.Fatalf("opening %s: %s", *sqlitePath, err)
+		}
+		opts = append(opts, gameapi.WithStore(store))
+	}
+	if *adminToken != "" {
+		opts = append(opts, gameapi.WithAdminToken(*adminToken))
+	}
+	if *rotateAfter > 0 {
+		opts = append(opts, gameapi.WithGameRotation(*rotateAfter))
+	}
+	h := gameapi.Handler(wordLists, opts...)
 	err = http.ListenAndServe(":8080", h)
 	panic(err)
 }
+
+// openSQLiteStore returns a store that persists games to the SQLite
+// database at path. The SQLite driver is only linked into builds with
+// the sqlite tag.
+func openSQLiteStore(path string) (gameapi.Store, error) {
+	if !slices.Contains(sql.Drivers(), "sqlite3") {
+		return nil, fmt.Errorf("greenapid wasn't built with -tags sqlite")
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows only one writer at a time.
+	db.SetMaxOpenConns(1)
+	return gameapi.NewSQLiteStore(db)
+}
@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// Link the SQLite driver for the -sqlite flag.
+import _ "github.com/mattn/go-sqlite3"
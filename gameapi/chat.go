@@ -0,0 +1,62 @@
+package gameapi
+
+import "time"
+
+const (
+	// maxChatLength is the maximum length, in runes, of a chat
+	// message.
+	maxChatLength = 500
+	// maxChatMessages bounds the number of recent chat messages
+	// kept for a single game.
+	maxChatMessages = 100
+	// chatCooldown is the minimum interval between a player's
+	// chat messages.
+	chatCooldown = 500 * time.Millisecond
+)
+
+// ChatMessage is a message sent through /chat.
+type ChatMessage struct {
+	Number   int       `json:"number"`
+	PlayerID string    `json:"player_id"`
+	Name     string    `json:"name"`
+	Team     int       `json:"team"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+}
+
+// chatBuffer is a ring buffer of a game's most recent chat
+// messages. It's ephemeral, and isn't part of the GameState.
+type chatBuffer struct {
+	msgs []ChatMessage
+	// n is the number of messages ever added.
+	n int
+}
+
+// add numbers m and adds it to the buffer, evicting the oldest
+// message if the buffer is full.
+func (b *chatBuffer) add(m ChatMessage) ChatMessage {
+	if b.msgs == nil {
+		b.msgs = make([]ChatMessage, maxChatMessages)
+	}
+	b.n++
+	m.Number = b.n
+	b.msgs[(b.n-1)%maxChatMessages] = m
+	return m
+}
+
+// since returns the buffered messages numbered after lastSeen,
+// oldest first.
+func (b *chatBuffer) since(lastSeen int) []ChatMessage {
+	first := b.n - maxChatMessages
+	if first < lastSeen {
+		first = lastSeen
+	}
+	if first < 0 {
+		first = 0
+	}
+	msgs := []ChatMessage{}
+	for i := first; i < b.n; i++ {
+		msgs = append(msgs, b.msgs[i%maxChatMessages])
+	}
+	return msgs
+}
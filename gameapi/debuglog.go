@@ -0,0 +1,78 @@
+package gameapi
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxDebugLogEntries bounds the number of entries kept in a single
+// game's debug log.
+const maxDebugLogEntries = 200
+
+// DebugLogEntry is an entry in a game's debug log, served by
+// /admin/game-log. Unlike the game's events, the log records
+// requests that were rejected, and why.
+type DebugLogEntry struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	PlayerID string    `json:"player_id,omitempty"`
+	Detail   string    `json:"detail"`
+
+	// RequestID is the ID of the request that the entry was logged
+	// for, as in its response's X-Request-ID header. It's empty
+	// for entries logged outside of a request, such as by pruning.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Kinds of debug log entries.
+const (
+	debugGuess         = "guess"
+	debugGuessRejected = "guess_rejected"
+	debugReset         = "reset"
+	debugPruneKept     = "prune_kept"
+	debugRename        = "rename"
+)
+
+// debugLog is a ring buffer of a game's most recent debug log
+// entries. Like chatBuffer, it's ephemeral, and isn't part of the
+// GameState.
+type debugLog struct {
+	entries []DebugLogEntry
+	// n is the number of entries ever added.
+	n int
+}
+
+// addf adds an entry to the log for the request identified by
+// requestID, if any, evicting the oldest entry if the log is full.
+func (l *debugLog) addf(now time.Time, kind, playerID, requestID, format string, args ...interface{}) {
+	if l.entries == nil {
+		l.entries = make([]DebugLogEntry, maxDebugLogEntries)
+	}
+	l.entries[l.n%maxDebugLogEntries] = DebugLogEntry{
+		Time:      now,
+		Kind:      kind,
+		PlayerID:  playerID,
+		Detail:    fmt.Sprintf(format, args...),
+		RequestID: requestID,
+	}
+	l.n++
+}
+
+// clone returns a copy of the log, for carrying it over to the
+// game that replaces its own.
+func (l *debugLog) clone() debugLog {
+	return debugLog{entries: append([]DebugLogEntry(nil), l.entries...), n: l.n}
+}
+
+// all returns the entries in the log, oldest first.
+func (l *debugLog) all() []DebugLogEntry {
+	first := l.n - maxDebugLogEntries
+	if first < 0 {
+		first = 0
+	}
+	entries := []DebugLogEntry{}
+	for i := first; i < l.n; i++ {
+		entries = append(entries, l.entries[i%maxDebugLogEntries])
+	}
+	return entries
+}
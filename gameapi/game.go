@@ -1,11 +1,17 @@
 package gameapi
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 type Color int
@@ -31,6 +37,38 @@ func (c Color) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.String())
 }
 
+func (c *Color) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	switch s {
+	case "g":
+		*c = Green
+	case "b":
+		*c = Black
+	case "t":
+		*c = Tan
+	default:
+		return fmt.Errorf("invalid color %q", s)
+	}
+	return nil
+}
+
+// Teams that a player may belong to. A player on NoTeam
+// hasn't picked a side yet.
+const (
+	NoTeam  = 0
+	TeamOne = 1
+	TeamTwo = 2
+)
+
+// validTeam returns true iff team is one of the two sides
+// that may give clues and guess.
+func validTeam(team int) bool {
+	return team == TeamOne || team == TeamTwo
+}
+
 // Seed wraps an int64 with a custom JSON marshaller to marshal
 // it as a string. We use the full 64-bit range, but Javascript
 // Numbers aren't capable of representing the full range of 64-bit
@@ -54,13 +92,279 @@ func (s Seed) MarshalJSON() ([]byte, error) {
 // GameState encapsulates enough data to reconstruct
 // a Game's state. It's used to recreate games after
 // a process restart.
+//
+// The full word set can be very large, so it's omitted
+// from the GameState sent to clients. See persistedState
+// for the serialized form that includes it.
 type GameState struct {
-	mu      sync.Mutex        `json:"-"`
 	changed chan struct{}     `json:"-"`
-	players map[string]Player `json:"-"`
 	Seed    Seed              `json:"seed"`
 	Events  []Event           `json:"events"`
-	WordSet []string          `json:"word_set"`
+	WordSet []string          `json:"-"`
+	Players map[string]Player `json:"players"`
+
+	// SourceLists names the word lists that WordSet was
+	// built from. It's empty if the words were supplied
+	// directly by the client.
+	SourceLists []string `json:"source_lists"`
+
+	// StartingTeam is the team that guesses first. States
+	// that predate it leave it zero, which is treated as
+	// TeamOne.
+	StartingTeam int `json:"starting_team,omitempty"`
+
+	// OutcomeReason explains why the game ended, and is
+	// empty while the game is in progress. FinishedAt is
+	// the time that the game ended.
+	OutcomeReason string    `json:"outcome_reason,omitempty"`
+	FinishedAt    time.Time `json:"finished_at"`
+
+	// WordSeed, if non-zero, is the seed that the words are
+	// drawn from, while the layouts are still assigned from
+	// Seed. It's set when the layouts are reshuffled without
+	// changing the words.
+	WordSeed Seed `json:"word_seed,omitempty"`
+
+	// FinishedByPlayer and FinishedByTeam identify the player
+	// whose move ended the game.
+	FinishedByPlayer string `json:"finished_by_player,omitempty"`
+	FinishedByTeam   int    `json:"finished_by_team,omitempty"`
+
+	// WordSetHash identifies WordSet, since the same seed draws
+	// a different board from a different word set. States that
+	// predate it leave it empty, and aren't checked.
+	WordSetHash string `json:"word_set_hash,omitempty"`
+
+	// HintMode, if set, tells the players at the start of each
+	// turn how many greens are hidden in the layout that the
+	// guessing team guesses from. See Game.Hint.
+	HintMode bool `json:"hint_mode,omitempty"`
+
+	// ConfirmGuesses, if set, requires each guess to be
+	// proposed by one player and confirmed by a teammate
+	// before it takes effect. See Game.Proposals.
+	ConfirmGuesses bool `json:"confirm_guesses,omitempty"`
+
+	// Board, if set, is the game's board, instead of one drawn
+	// from the seed. It's set for boards with words pinned to
+	// fixed cells, which the seed can't reproduce.
+	Board []BoardCell `json:"board,omitempty"`
+
+	// Distribution, if set, is the distribution of colors that
+	// the layouts are assigned from, instead of the one in the
+	// rule book. See blackDistribution.
+	Distribution [][2]Color `json:"distribution,omitempty"`
+
+	// Frozen is set while an admin has frozen the game. A frozen
+	// game may be viewed but not changed.
+	Frozen bool `json:"frozen,omitempty"`
+
+	// ManualEndTurn, if set, leaves the turn with the guessing
+	// team after it reveals a tan, until it ends the turn itself.
+	// By default, revealing a tan ends the turn. See endsTurn.
+	ManualEndTurn bool `json:"manual_end_turn,omitempty"`
+
+	// MirrorDoubleGreens, if set, reveals a cell that's green in
+	// both layouts in both of them at once, since revealing it
+	// from either side satisfies both. See Game.doubleGreen.
+	MirrorDoubleGreens bool `json:"mirror_double_greens,omitempty"`
+
+	// TeamTurns, if non-zero, is the number of turns that each
+	// team may take, in place of the timer tokens that the teams
+	// share. See Game.TurnsLeft.
+	TeamTurns int `json:"team_turns,omitempty"`
+
+	// TimerTokens, if non-zero, is the number of timer tokens that
+	// the teams share, in place of the usual nine. It's ignored in
+	// games with per-team turns. See Game.TokensLeft.
+	TimerTokens int `json:"timer_tokens,omitempty"`
+
+	// SuddenDeath, if set, lets the teams play on once the timer
+	// tokens run out, instead of losing right away. See
+	// PhaseSuddenDeath.
+	SuddenDeath bool `json:"sudden_death,omitempty"`
+
+	// Prereveal, if set, lists cells that are revealed before the
+	// game starts, such as greens given to a team as a handicap.
+	Prereveal *Prereveal `json:"prereveal,omitempty"`
+
+	// ListBounds, if set, divides WordSet into pools, one for
+	// each of the word lists it was merged from, that the board
+	// is drawn from evenly. Pool i ends at WordSet[ListBounds[i]].
+	// See selectBalanced.
+	ListBounds []int `json:"list_bounds,omitempty"`
+
+	// FilteredWords, if set, means that WordSet holds only some of
+	// the words in SourceLists, such as those left after avoiding
+	// recently used words, so a share code can't recreate the board.
+	FilteredWords bool `json:"filtered_words,omitempty"`
+
+	// LastEmptyAt is the time that the game's last player left,
+	// or zero if no player has left an otherwise empty game.
+	LastEmptyAt time.Time `json:"last_empty_at"`
+
+	// PasswordHash, if set, is a salted hash of the password that
+	// requests must carry to play the game. Like WordSet, it's
+	// omitted from the GameState sent to clients. See
+	// hashPassword.
+	PasswordHash string `json:"-"`
+
+	// Spectators, if set, lets requests without the password view
+	// a game that has one, though not change it.
+	Spectators bool `json:"spectators,omitempty"`
+
+	// WordDifficulty holds the difficulty ratings of the words
+	// in WordSet that have one. Like WordSet, it's omitted from
+	// the GameState sent to clients, which instead see the
+	// ratings of the words on the board. See Game.Difficulties.
+	WordDifficulty map[string]int `json:"-"`
+}
+
+// BoardCell is a cell of a board stored in a GameState: its
+// word and its colors in each team's layout.
+type BoardCell struct {
+	Word string `json:"word"`
+	One  Color  `json:"one"`
+	Two  Color  `json:"two"`
+}
+
+// FixedCell pins a word to a cell of a new board, along with its
+// colors in each team's layout. See pinBoard.
+type FixedCell struct {
+	Word  string `json:"word"`
+	Index int    `json:"index"`
+	One   Color  `json:"color_one"`
+	Two   Color  `json:"color_two"`
+}
+
+// Prereveal lists the cells of each layout that are revealed when a
+// game starts: One lists cells of OneLayout, which team two guesses,
+// and Two lists cells of TwoLayout, which team one guesses.
+type Prereveal struct {
+	One []int `json:"one,omitempty"`
+	Two []int `json:"two,omitempty"`
+}
+
+// check returns the first of p's cells that's out of range, as the
+// name of its field, or the empty string if they're all in range.
+func (p *Prereveal) check() string {
+	for _, side := range []struct {
+		name    string
+		indices []int
+	}{{"one", p.One}, {"two", p.Two}} {
+		for i, index := range side.indices {
+			if index < 0 || index >= len(colorDistribution) {
+				return fmt.Sprintf("prereveal.%s[%d]", side.name, i)
+			}
+		}
+	}
+	return ""
+}
+
+// checkPrereveal returns the first of the game's prerevealed cells
+// that isn't green in its layout, as the name of its field, or the
+// empty string if they're all green.
+func (g *Game) checkPrereveal() string {
+	if g.Prereveal == nil {
+		return ""
+	}
+	for i, index := range g.Prereveal.One {
+		if g.OneLayout[index] != Green {
+			return fmt.Sprintf("prereveal.one[%d]", i)
+		}
+	}
+	for i, index := range g.Prereveal.Two {
+		if g.TwoLayout[index] != Green {
+			return fmt.Sprintf("prereveal.two[%d]", i)
+		}
+	}
+	return ""
+}
+
+// Reasons that a game may end.
+const (
+	// OutcomeAllGreen means every green was revealed and the game was won.
+	OutcomeAllGreen = "all_green"
+	// OutcomeAssassin means a black was revealed and the game was lost.
+	OutcomeAssassin = "assassin"
+	// OutcomeNoTokens means the timer tokens ran out and the game was lost.
+	OutcomeNoTokens = "no_tokens"
+	// OutcomeNoTurns means no team with greens left to find had turns
+	// left, in games with per-team turns, and the game was lost.
+	OutcomeNoTurns = "no_turns"
+)
+
+// Statuses of a game. See Game.Status.
+const (
+	StatusInProgress   = "in_progress"
+	StatusWon          = "won"
+	StatusLostAssassin = "lost_assassin"
+	StatusLostTime     = "lost_time"
+)
+
+// outcomeStatus returns the status of a game that ended for reason,
+// or that's still in progress if reason is empty.
+func outcomeStatus(reason string) string {
+	switch reason {
+	case "":
+		return StatusInProgress
+	case OutcomeAllGreen:
+		return StatusWon
+	case OutcomeAssassin:
+		return StatusLostAssassin
+	default:
+		return StatusLostTime
+	}
+}
+
+// timerTokens is the number of turns that the players have
+// to reveal every green, unless the game sets its own.
+const timerTokens = 9
+
+// startingTokens returns the number of timer tokens that the game
+// starts with, or zero if it has per-team turns instead.
+func (gs *GameState) startingTokens() int {
+	switch {
+	case gs.TeamTurns > 0:
+		return 0
+	case gs.TimerTokens > 0:
+		return gs.TimerTokens
+	default:
+		return timerTokens
+	}
+}
+
+// persistedState is the stable format used to export a game
+// from one server and import it into another:
+//
+//	seed      the seed used to draw words and layouts, as a string
+//	events    every event that's occurred in the game, in order
+//	word_set  the full list of words the board was drawn from
+//	players   the players currently in the game, keyed by player ID
+//
+// along with word_difficulty, the difficulty ratings of the rated
+// words in word_set, if any, and password_hash, the hash of the
+// game's password, if it has one.
+type persistedState struct {
+	*GameState
+	WordSet        []string       `json:"word_set"`
+	WordDifficulty map[string]int `json:"word_difficulty,omitempty"`
+	PasswordHash   string         `json:"password_hash,omitempty"`
+}
+
+func (gs *GameState) persisted() persistedState {
+	return persistedState{GameState: gs, WordSet: gs.WordSet, WordDifficulty: gs.WordDifficulty, PasswordHash: gs.PasswordHash}
+}
+
+// state returns the GameState decoded into ps.
+func (ps persistedState) state() *GameState {
+	if ps.GameState == nil {
+		ps.GameState = &GameState{}
+	}
+	ps.GameState.WordSet = ps.WordSet
+	ps.GameState.WordDifficulty = ps.WordDifficulty
+	ps.GameState.PasswordHash = ps.PasswordHash
+	return ps.GameState
 }
 
 type Event struct {
@@ -71,6 +375,65 @@ type Event struct {
 	Team     int    `json:"team"`
 	Index    int    `json:"index"`
 	Message  string `json:"message"`
+
+	// Presence is set on presence events, to either
+	// "joined" or "left".
+	Presence string `json:"presence,omitempty"`
+
+	// Word and Count are set on clue events, to the clue's
+	// word and the number of cells it's meant to cover.
+	Word  string `json:"word,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+// Phases of a turn. See Game.Phase.
+const (
+	PhaseClue  = "clue"
+	PhaseGuess = "guess"
+	// PhaseSuddenDeath follows the last turn in games with
+	// SuddenDeath set, if greens are still hidden. No more clues
+	// are given and turns don't end: either team may guess, and
+	// the game is lost as soon as anyone reveals a cell that
+	// isn't green.
+	PhaseSuddenDeath = "sudden_death"
+)
+
+// maxClueCount is the largest number of cells a clue may cover: the
+// number of greens in a layout.
+const maxClueCount = 9
+
+// Clue is a clue given by a team for the other team to guess from,
+// in the given turn.
+type Clue struct {
+	Word     string `json:"word"`
+	Count    int    `json:"count"`
+	Team     int    `json:"team"`
+	Turn     int    `json:"turn"`
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+}
+
+// TokenedCell is a tan revealed by Team's guess in the given turn.
+// In the board game, the guessing team marks it with the turn's
+// timer token.
+type TokenedCell struct {
+	Index int `json:"index"`
+	Team  int `json:"team"`
+	Turn  int `json:"turn"`
+}
+
+// Contribution counts a player's guesses by whether they
+// revealed a green.
+type Contribution struct {
+	Correct   int `json:"correct"`
+	Incorrect int `json:"incorrect"`
+}
+
+// Proposal is a guess that's waiting to be confirmed.
+type Proposal struct {
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+	Index    int    `json:"index"`
 }
 
 type Player struct {
@@ -79,22 +442,269 @@ type Player struct {
 	LastSeen time.Time `json:"last_seen"`
 }
 
+// MarshalJSON marshals p with unassigned set if the player hasn't
+// picked a team yet, such as after a heartbeat from the lobby, so
+// that clients don't mistake NoTeam for a side.
+func (p Player) MarshalJSON() ([]byte, error) {
+	type player Player
+	return json.Marshal(struct {
+		player
+		Unassigned bool `json:"unassigned,omitempty"`
+	}{player(p), p.Team == NoTeam})
+}
+
 func NewState(seed int64, words []string) GameState {
 	return GameState{
-		changed: make(chan struct{}),
-		players: make(map[string]Player),
-		Seed:    Seed(seed),
-		Events:  []Event{},
-		WordSet: words,
+		changed:     make(chan struct{}),
+		Players:     make(map[string]Player),
+		Seed:        Seed(seed),
+		Events:      []Event{},
+		WordSet:     words,
+		WordSetHash: wordSetHash(words),
 	}
 }
 
+// wordSetHash returns a short hash of the words in words,
+// regardless of their order.
+func wordSetHash(words []string) string {
+	sorted := append([]string{}, words...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// startingTeam returns the team that guesses first.
+func (gs *GameState) startingTeam() int {
+	if gs.StartingTeam == NoTeam {
+		return TeamOne
+	}
+	return gs.StartingTeam
+}
+
+// distribution returns the distribution of colors that the
+// layouts are assigned from.
+func (gs *GameState) distribution() [25][2]Color {
+	if len(gs.Distribution) == 0 {
+		return colorDistribution
+	}
+	var dist [25][2]Color
+	copy(dist[:], gs.Distribution)
+	return dist
+}
+
+// validate checks that a GameState decoded from an external
+// source is internally consistent, and initializes any fields
+// that aren't serialized. ReconstructGame calls it before
+// deriving anything from the state.
+func (gs *GameState) validate() error {
+	if len(gs.Distribution) > 0 {
+		if err := checkDistribution(gs.Distribution); err != nil {
+			return err
+		}
+	}
+	if len(gs.Board) > 0 {
+		if err := checkBoard(gs.Board, gs.distribution()); err != nil {
+			return err
+		}
+	} else {
+		unique := map[string]bool{}
+		for _, w := range gs.WordSet {
+			unique[w] = true
+		}
+		if len(unique) < len(colorDistribution) {
+			return fmt.Errorf("word_set has %d unique words, need at least %d", len(unique), len(colorDistribution))
+		}
+		if len(gs.ListBounds) > 0 {
+			if err := checkListBounds(gs.ListBounds, len(gs.WordSet)); err != nil {
+				return err
+			}
+			if len(unique) != len(gs.WordSet) {
+				return fmt.Errorf("word_set with list_bounds has duplicate words")
+			}
+		}
+	}
+	for i, e := range gs.Events {
+		if e.Number != i+1 {
+			return fmt.Errorf("event %d has number %d", i+1, e.Number)
+		}
+		if e.Team != NoTeam && !validTeam(e.Team) {
+			return fmt.Errorf("event %d has invalid team %d", e.Number, e.Team)
+		}
+		if e.Type == "guess" && (e.Index < 0 || e.Index >= len(colorDistribution)) {
+			return fmt.Errorf("event %d has out of range index %d", e.Number, e.Index)
+		}
+	}
+	if gs.WordSetHash != "" && gs.WordSetHash != wordSetHash(gs.WordSet) {
+		return fmt.Errorf("word_set doesn't match word_set_hash %q", gs.WordSetHash)
+	}
+	if gs.StartingTeam != NoTeam && !validTeam(gs.StartingTeam) {
+		return fmt.Errorf("invalid starting_team %d", gs.StartingTeam)
+	}
+	if gs.TeamTurns < 0 {
+		return fmt.Errorf("invalid team_turns %d", gs.TeamTurns)
+	}
+	if gs.TimerTokens < 0 {
+		return fmt.Errorf("invalid timer_tokens %d", gs.TimerTokens)
+	}
+	if gs.Prereveal != nil {
+		if field := gs.Prereveal.check(); field != "" {
+			return fmt.Errorf("%s is out of range", field)
+		}
+	}
+	if gs.FinishedByTeam != NoTeam && !validTeam(gs.FinishedByTeam) {
+		return fmt.Errorf("invalid finished_by_team %d", gs.FinishedByTeam)
+	}
+	for id, p := range gs.Players {
+		if id == "" {
+			return fmt.Errorf("player with empty ID")
+		}
+		if p.Team != NoTeam && !validTeam(p.Team) {
+			return fmt.Errorf("player %q has invalid team %d", id, p.Team)
+		}
+	}
+
+	gs.changed = make(chan struct{})
+	if gs.Events == nil {
+		gs.Events = []Event{}
+	}
+	if gs.Players == nil {
+		gs.Players = make(map[string]Player)
+	}
+	return nil
+}
+
+// Game is a GameState along with everything that can be
+// derived from it. The words and layouts are derived from
+// the seed, and the remaining fields are derived by replaying
+// the game's events in order.
+//
+// Team one guesses the words that team two clues for, revealing
+// cells of TwoLayout, and vice versa. ExposedOne and ExposedTwo
+// record which cells of OneLayout and TwoLayout have been revealed.
+//
+// ActiveTeam is the team that's currently guessing, and Turn
+// counts the turns taken so far, starting at 1. Every time a
+// turn ends, whether by an explicit end_turn event or by an
+// incorrect guess, Turn increases by one and ActiveTeam switches
+// to the other team, unless the other team has no more words
+// to guess.
+//
+// ExposedOneIndices and ExposedTwoIndices list the indices of the
+// revealed cells of OneLayout and TwoLayout, in increasing order.
+// They carry the same information as ExposedOne and ExposedTwo in
+// a form that's more compact early in the game.
+//
+// GreensNeeded is the number of green cells left to reveal before
+// the game is won. A cell that's green in both layouts only needs
+// to be revealed once, so it's counted once.
+//
+// Contributions counts each player's correct and incorrect
+// guesses, keyed by player ID.
+//
+// Proposals holds each team's pending guess, in games that
+// require guesses to be confirmed. A team's proposal is cleared
+// once it makes a guess or its turn ends.
+//
+// Hint is only set in hint mode, from the start of each turn until
+// the guessing team's first guess. It's the number of greens
+// hidden in the layout of the team that isn't guessing.
+//
+// Difficulties is aligned with Words, and holds each word's
+// difficulty rating, or zero if it isn't rated. It's omitted if
+// none of the words are rated.
+//
+// ResetAt is set while a reset of the game is pending, to the time
+// that it will take effect unless it's cancelled.
+//
+// TurnsLeft is only set in games with per-team turns. It holds the
+// number of turns that each team has left, keyed by team. A team's
+// turns are used up as its turns end.
+//
+// TokensLeft is the number of timer tokens that the teams have left
+// to share, and is zero in games with per-team turns. Each turn uses
+// a token, as soon as the guessing team reveals a tan or else when
+// the turn ends. The game is lost once a turn ends with none left,
+// unless it has SuddenDeath set.
+//
+// HasPassword is set if the game has a password, so that clients
+// know to ask for it.
+//
+// Clues holds every clue given in the game, in order, and Clue is
+// the clue for the current turn, once it's been given. Each turn
+// has at most one clue, given by the team that isn't guessing.
+//
+// Phase is PhaseClue at the start of each turn, while the team that
+// isn't guessing may give its clue, and PhaseGuess once it has. It's
+// empty once the game is over. Only ActiveTeam may guess, in either
+// phase, since clues may also be given aloud. Once the timer tokens
+// run out, games with SuddenDeath set move to PhaseSuddenDeath.
+//
+// Tokened lists the tans revealed by guesses, in order, so that
+// clients can show a timer token on each. A team's guess reveals a
+// cell of the other team's layout, so a cell is only tokened from
+// one side unless both teams have guessed it. Tans revealed in sudden
+// death aren't tokened, since they lose the game.
+//
+// Status summarizes OutcomeReason for clients: StatusInProgress
+// until the game ends, then StatusWon, StatusLostAssassin, or
+// StatusLostTime if the teams ran out of tokens or turns.
 type Game struct {
-	GameState `json:"state"`
-	CreatedAt time.Time `json:"created_at"`
-	Words     []string  `json:"words"`
-	OneLayout []Color   `json:"one_layout"`
-	TwoLayout []Color   `json:"two_layout"`
+	mu sync.Mutex
+
+	GameState         `json:"state"`
+	CreatedAt         time.Time               `json:"created_at"`
+	Words             []string                `json:"words"`
+	OneLayout         []Color                 `json:"one_layout"`
+	TwoLayout         []Color                 `json:"two_layout"`
+	ExposedOne        []bool                  `json:"exposed_one"`
+	ExposedTwo        []bool                  `json:"exposed_two"`
+	ExposedOneIndices []int                   `json:"exposed_one_indices"`
+	ExposedTwoIndices []int                   `json:"exposed_two_indices"`
+	ActiveTeam        int                     `json:"active_team"`
+	Turn              int                     `json:"turn"`
+	GreensNeeded      int                     `json:"greens_needed"`
+	ShareCode         string                  `json:"share_code,omitempty"`
+	Hint              *int                    `json:"hint,omitempty"`
+	Proposals         map[int]Proposal        `json:"proposals,omitempty"`
+	Contributions     map[string]Contribution `json:"contributions"`
+	Difficulties      []int                   `json:"difficulties,omitempty"`
+	ResetAt           *time.Time              `json:"reset_at,omitempty"`
+	TurnsLeft         map[int]int             `json:"turns_left,omitempty"`
+	TokensLeft        int                     `json:"tokens_left"`
+	HasPassword       bool                    `json:"has_password,omitempty"`
+	Clue              *Clue                   `json:"clue,omitempty"`
+	Clues             []Clue                  `json:"clues"`
+	Tokened           []TokenedCell           `json:"tokened"`
+	Phase             string                  `json:"phase"`
+	Status            string                  `json:"status"`
+
+	idempotency idempotencyCache `json:"-"`
+	// tokenUsed records whether the current turn has used its
+	// timer token already. See TokensLeft.
+	tokenUsed bool
+	// suddenDeathLost records whether a cell other than a green
+	// was revealed in sudden death.
+	suddenDeathLost bool
+	// lastGuess records when each player last guessed, for
+	// enforcing the handler's guess cooldown.
+	lastGuess map[string]time.Time
+	// chat holds the game's recent chat messages, and lastChat
+	// records when each player last sent one.
+	chat     chatBuffer
+	lastChat map[string]time.Time
+	// debug records recent requests for /admin/game-log.
+	debug debugLog
+	// pendingReset is the reset waiting to replace the game,
+	// if any.
+	pendingReset *pendingReset
+}
+
+// otherTeam returns the team opposite to team.
+func otherTeam(team int) int {
+	if team == TeamOne {
+		return TeamTwo
+	}
+	return TeamOne
 }
 
 func (gs *GameState) notifyAll() {
@@ -122,11 +732,349 @@ func (gs *GameState) eventsSince(lastSeen int) (evts []Event, next chan struct{}
 	return evts, gs.changed
 }
 
+// addEvent adds evt to the game's events and applies
+// it to the game's derived state.
+func (g *Game) addEvent(evt Event) {
+	g.GameState.addEvent(evt)
+	g.apply(evt)
+}
+
+// apply updates the game's derived state to reflect evt.
+func (g *Game) apply(evt Event) {
+	defer g.updatePhase()
+	defer g.updateHint(g.Turn)
+
+	switch evt.Type {
+	case "guess":
+		suddenDeath := g.inSuddenDeath()
+		if evt.Team != g.ActiveTeam && !suddenDeath {
+			return // it's not this team's turn to guess
+		}
+
+		// A team guesses the words that the other team clued,
+		// revealing cells of the other team's layout.
+		layout, exposed := g.TwoLayout, g.ExposedTwo
+		if evt.Team == TeamTwo {
+			layout, exposed = g.OneLayout, g.ExposedOne
+		}
+		if evt.Index < 0 || evt.Index >= len(layout) || evt.Index >= len(exposed) {
+			return
+		}
+		exposed[evt.Index] = true
+		if g.MirrorDoubleGreens && g.doubleGreen(evt.Index) {
+			g.ExposedOne[evt.Index], g.ExposedTwo[evt.Index] = true, true
+		}
+		g.ExposedOneIndices = exposedIndices(g.ExposedOne)
+		g.ExposedTwoIndices = exposedIndices(g.ExposedTwo)
+		g.GreensNeeded = g.remainingGreens()
+		g.Hint = nil
+		delete(g.Proposals, evt.Team)
+
+		c := g.Contributions[evt.PlayerID]
+		if layout[evt.Index] == Green {
+			c.Correct++
+		} else {
+			c.Incorrect++
+		}
+		g.Contributions[evt.PlayerID] = c
+
+		switch {
+		case suddenDeath:
+			// Turns don't end in sudden death, and any
+			// mistake loses the game.
+			if layout[evt.Index] != Green {
+				g.suddenDeathLost = true
+			}
+		case endsTurn(layout[evt.Index]):
+			g.Tokened = append(g.Tokened, TokenedCell{Index: evt.Index, Team: evt.Team, Turn: g.Turn})
+			g.useToken()
+			if !g.ManualEndTurn {
+				g.endTurn(evt.Team)
+			}
+		case layout[evt.Index] == Green:
+			// If that was the last green the team had to guess,
+			// then the turn passes to the other team.
+			if !g.hasHiddenGreens(otherTeam(evt.Team)) {
+				g.nextTurn()
+				g.ActiveTeam = otherTeam(evt.Team)
+			}
+		}
+	case "clue":
+		if evt.Team != otherTeam(g.ActiveTeam) || g.Clue != nil || g.inSuddenDeath() {
+			return // it's not this team's turn to give a clue
+		}
+		c := Clue{
+			Word:     evt.Word,
+			Count:    evt.Count,
+			Team:     evt.Team,
+			Turn:     g.Turn,
+			PlayerID: evt.PlayerID,
+			Name:     evt.Name,
+		}
+		g.Clues = append(g.Clues, c)
+		g.Clue = &c
+	case "end_turn":
+		if evt.Team == g.ActiveTeam && !g.inSuddenDeath() {
+			delete(g.Proposals, evt.Team)
+			g.endTurn(evt.Team)
+		}
+	case "propose_guess":
+		if evt.Team != g.ActiveTeam || evt.Index < 0 || evt.Index >= len(g.Words) {
+			return
+		}
+		if g.Proposals == nil {
+			g.Proposals = map[int]Proposal{}
+		}
+		g.Proposals[evt.Team] = Proposal{PlayerID: evt.PlayerID, Name: evt.Name, Index: evt.Index}
+	case "cancel_guess":
+		delete(g.Proposals, evt.Team)
+	}
+}
+
+// endsTurn reports whether revealing a cell of color c ends the
+// guessing team's turn, in games that end turns automatically. A
+// black ends the game instead.
+func endsTurn(c Color) bool {
+	return c == Tan
+}
+
+// endTurn ends team's turn. The other team guesses next,
+// unless team has no greens left for them to guess, or the
+// other team has no turns left.
+func (g *Game) endTurn(team int) {
+	g.nextTurn()
+	if g.TurnsLeft != nil && g.TurnsLeft[team] > 0 {
+		g.TurnsLeft[team]--
+	}
+	if g.hasHiddenGreens(team) && !g.teamOutOfTurns(otherTeam(team)) {
+		g.ActiveTeam = otherTeam(team)
+	}
+}
+
+// nextTurn starts a new turn, using up the ending turn's timer
+// token if it hasn't been already.
+func (g *Game) nextTurn() {
+	g.useToken()
+	g.tokenUsed = false
+	g.Turn++
+	g.Clue = nil
+}
+
+// useToken uses the current turn's timer token, unless the turn
+// has used it already or there are none left.
+func (g *Game) useToken() {
+	if !g.tokenUsed && g.TokensLeft > 0 {
+		g.TokensLeft--
+		g.tokenUsed = true
+	}
+}
+
+// outOfTokens returns true iff the game has timer tokens and
+// every turn they allowed has ended.
+func (g *Game) outOfTokens() bool {
+	return g.TeamTurns == 0 && g.TokensLeft == 0 && !g.tokenUsed
+}
+
+// inSuddenDeath returns true iff the game is in sudden death.
+// See PhaseSuddenDeath.
+func (g *Game) inSuddenDeath() bool {
+	return g.SuddenDeath && g.outOfTokens() && g.status() == ""
+}
+
+// teamOutOfTurns returns true iff the game has per-team turns and
+// team has used all of its turns.
+func (g *Game) teamOutOfTurns(team int) bool {
+	return g.TurnsLeft != nil && g.TurnsLeft[team] <= 0
+}
+
+// outOfTurns returns true iff the game has per-team turns and no
+// team that still has greens to find has turns left.
+func (g *Game) outOfTurns() bool {
+	if g.TurnsLeft == nil {
+		return false
+	}
+	for _, team := range []int{TeamOne, TeamTwo} {
+		if !g.teamOutOfTurns(team) && g.hasHiddenGreens(otherTeam(team)) {
+			return false
+		}
+	}
+	return true
+}
+
+// updatePhase sets Phase to reflect the current turn.
+func (g *Game) updatePhase() {
+	switch {
+	case g.status() != "":
+		g.Phase = ""
+	case g.inSuddenDeath():
+		g.Phase = PhaseSuddenDeath
+	case g.Clue == nil:
+		g.Phase = PhaseClue
+	default:
+		g.Phase = PhaseGuess
+	}
+}
+
+// updateHint sets Hint if a new turn has started since prevTurn,
+// or clears it if the game is over or hint mode is off.
+func (g *Game) updateHint(prevTurn int) {
+	switch {
+	case !g.HintMode || g.status() != "":
+		g.Hint = nil
+	case g.Turn != prevTurn:
+		n := g.hiddenGreens(otherTeam(g.ActiveTeam))
+		g.Hint = &n
+	}
+}
+
+// hasPlayers returns true iff at least one player is on team.
+func (g *Game) hasPlayers(team int) bool {
+	return g.teamSize(team) > 0
+}
+
+// teamSize returns the number of players on team. Players who
+// haven't picked a team are on NoTeam, so they're never counted
+// towards either side.
+func (g *Game) teamSize(team int) (n int) {
+	for _, p := range g.Players {
+		if p.Team == team {
+			n++
+		}
+	}
+	return n
+}
+
+// exposedIndices returns the indices of the true elements of
+// exposed.
+func exposedIndices(exposed []bool) []int {
+	indices := []int{}
+	for i, e := range exposed {
+		if e {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// doubleGreen returns true iff the cell at index i is green in
+// both layouts. Revealing it from either side satisfies both
+// teams, so it only needs to be revealed once to win.
+func (g *Game) doubleGreen(i int) bool {
+	return g.OneLayout[i] == Green && g.TwoLayout[i] == Green
+}
+
+// exposedGreen returns true iff the cell at index i has
+// been revealed as green in either layout.
+func (g *Game) exposedGreen(i int) bool {
+	return (g.ExposedOne[i] && g.OneLayout[i] == Green) ||
+		(g.ExposedTwo[i] && g.TwoLayout[i] == Green)
+}
+
+// hasHiddenGreens returns true iff there are green cells in
+// team's layout that haven't yet been revealed.
+func (g *Game) hasHiddenGreens(team int) bool {
+	return g.hiddenGreens(team) > 0
+}
+
+// hiddenGreens returns the number of green cells in team's
+// layout that haven't yet been revealed.
+func (g *Game) hiddenGreens(team int) (n int) {
+	layout := g.OneLayout
+	if team == TeamTwo {
+		layout = g.TwoLayout
+	}
+	for i, c := range layout {
+		if c == Green && !g.exposedGreen(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// remainingGreens returns the number of cells that are green in
+// either layout and haven't yet been revealed as green.
+func (g *Game) remainingGreens() (n int) {
+	for i := range g.Words {
+		if (g.OneLayout[i] == Green || g.TwoLayout[i] == Green) && !g.exposedGreen(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// exposedBlack returns true iff a black cell has been revealed.
+func (g *Game) exposedBlack() bool {
+	for i := range g.Words {
+		if (g.ExposedOne[i] && g.OneLayout[i] == Black) ||
+			(g.ExposedTwo[i] && g.TwoLayout[i] == Black) {
+			return true
+		}
+	}
+	return false
+}
+
+// status returns the reason the game has ended, or the empty
+// string if the game is still in progress.
+func (g *Game) status() string {
+	switch {
+	case g.exposedBlack():
+		return OutcomeAssassin
+	case g.remainingGreens() == 0:
+		return OutcomeAllGreen
+	case g.outOfTurns():
+		return OutcomeNoTurns
+	case g.outOfTokens() && (!g.SuddenDeath || g.suddenDeathLost):
+		return OutcomeNoTokens
+	default:
+		return ""
+	}
+}
+
+// checkFinished records the game's outcome if the game has
+// just ended, crediting the move that ended it to playerID
+// on team.
+func (g *Game) checkFinished(playerID string, team int, when time.Time) {
+	if g.OutcomeReason != "" {
+		return
+	}
+	if reason := g.status(); reason != "" {
+		g.OutcomeReason = reason
+		g.Status = outcomeStatus(reason)
+		g.FinishedAt = when
+		g.FinishedByPlayer = playerID
+		g.FinishedByTeam = team
+	}
+}
+
+// maxNameLength is the maximum length, in runes, of a player's
+// display name.
+const maxNameLength = 32
+
+// sanitizeName strips control characters and surrounding space
+// from a player's display name, and truncates it to maxNameLength.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if r := []rune(name); len(r) > maxNameLength {
+		name = strings.TrimSpace(string(r[:maxNameLength]))
+	}
+	return name
+}
+
+// markSeen records that playerID was seen at when, adding them
+// to the game if they're new. A player that hasn't picked a side
+// joins team, but a player that already has one keeps it: only
+// switchTeam moves players between teams.
 func (g *Game) markSeen(playerID, name string, team int, when time.Time) {
-	p, ok := g.players[playerID]
+	p, ok := g.Players[playerID]
 	if ok {
 		p.LastSeen = when
-		if team != 0 && p.Team != team {
+		if team != NoTeam && p.Team == NoTeam {
 			p.Team = team
 			g.addEvent(Event{
 				Type:     "join_side",
@@ -135,7 +1083,7 @@ func (g *Game) markSeen(playerID, name string, team int, when time.Time) {
 				Team:     team,
 			})
 		}
-		if name != p.Name && p.Name != "" {
+		if name != "" && name != p.Name {
 			p.Name = name
 			g.addEvent(Event{
 				Type:     "change_name",
@@ -144,12 +1092,19 @@ func (g *Game) markSeen(playerID, name string, team int, when time.Time) {
 				Team:     team,
 			})
 		}
-		g.players[playerID] = p
+		g.Players[playerID] = p
 		return
 	}
 
-	g.players[playerID] = Player{Team: team, Name: name, LastSeen: when}
-	if team != 0 {
+	g.Players[playerID] = Player{Team: team, Name: name, LastSeen: when}
+	g.addEvent(Event{
+		Type:     "presence",
+		PlayerID: playerID,
+		Name:     name,
+		Team:     team,
+		Presence: "joined",
+	})
+	if team != NoTeam {
 		g.addEvent(Event{
 			Type:     "join_side",
 			PlayerID: playerID,
@@ -159,9 +1114,76 @@ func (g *Game) markSeen(playerID, name string, team int, when time.Time) {
 	}
 }
 
+// switchTeam moves playerID to team, adding them to the game if
+// they're new.
+func (g *Game) switchTeam(playerID, name string, team int, when time.Time) {
+	g.markSeen(playerID, name, team, when)
+	p := g.Players[playerID]
+	if p.Team == team {
+		return
+	}
+	p.Team = team
+	g.Players[playerID] = p
+	g.addEvent(Event{
+		Type:     "join_side",
+		PlayerID: playerID,
+		Name:     p.Name,
+		Team:     team,
+	})
+}
+
+// teamFull returns true iff playerID may not join team because
+// it already has max players. A player that's already on a team
+// isn't moved by markSeen, so is never refused. A max of zero
+// means there's no limit.
+func (g *Game) teamFull(playerID string, team, max int) bool {
+	if p, ok := g.Players[playerID]; ok && p.Team != NoTeam {
+		return false
+	}
+	return g.switchFull(playerID, team, max)
+}
+
+// switchFull returns true iff playerID may not switch to team
+// because it already has max players.
+func (g *Game) switchFull(playerID string, team, max int) bool {
+	if max <= 0 || team == NoTeam {
+		return false
+	}
+	if p, ok := g.Players[playerID]; ok && p.Team == team {
+		return false
+	}
+	return g.teamSize(team) >= max
+}
+
+// layoutHidden returns a non-empty error code if playerID may not
+// see team's layout: wrong_team if the player has joined the other
+// team, or not_on_team if it hasn't joined a team, or isn't in the
+// game at all. Every layout may be seen once the game is over. This
+// is a convenience check, not a secrecy guarantee: the game state
+// sent to every player includes both layouts, and any player may
+// join either team. g.mu must be held.
+func (g *Game) layoutHidden(playerID string, team int) string {
+	if g.status() != "" {
+		return ""
+	}
+	p, ok := g.Players[playerID]
+	switch {
+	case !ok || p.Team == NoTeam:
+		return "not_on_team"
+	case p.Team != team:
+		return "wrong_team"
+	}
+	return ""
+}
+
 func (g *Game) guess(playerID, name string, team, index int, when time.Time) {
 	g.markSeen(playerID, name, team, when)
+	g.addGuess(playerID, name, team, index, when)
+}
 
+// addGuess adds a guess by playerID, without marking them as
+// seen.
+func (g *Game) addGuess(playerID, name string, team, index int, when time.Time) {
 	// If there's an existing, identical guess event then ignore
 	// this guess. Duplicate events may happen if multiple players
 	// tap at approximately the same moment.
@@ -178,16 +1200,29 @@ func (g *Game) guess(playerID, name string, team, index int, when time.Time) {
 		PlayerID: playerID,
 		Name:     name,
 	})
+	g.checkFinished(playerID, team, when)
 }
 
-func (g *Game) pruneOldPlayers(now time.Time) (remaining int) {
+// pruneOldPlayers removes the players that haven't been seen
+// recently, returning the number of players that remain and the
+// number that were removed.
+func (g *Game) pruneOldPlayers(now time.Time) (remaining, pruned int) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	for id, player := range g.players {
+	for id, player := range g.Players {
 		if player.LastSeen.Add(50 * time.Second).Before(now) {
-			delete(g.players, id)
-			if player.Team != 0 {
+			delete(g.Players, id)
+			delete(g.lastGuess, id)
+			delete(g.lastChat, id)
+			g.addEvent(Event{
+				Type:     "presence",
+				PlayerID: id,
+				Name:     player.Name,
+				Team:     player.Team,
+				Presence: "left",
+			})
+			if player.Team != NoTeam {
 				g.addEvent(Event{
 					Type:     "player_left",
 					PlayerID: id,
@@ -195,39 +1230,548 @@ func (g *Game) pruneOldPlayers(now time.Time) (remaining int) {
 					Team:     player.Team,
 				})
 			}
+			if len(g.Players) == 0 {
+				g.LastEmptyAt = now
+			}
+			pruned++
 			continue
 		}
 	}
-	return len(g.players)
+	return len(g.Players), pruned
 }
 
-func ReconstructGame(state GameState) (g Game) {
-	g = Game{
-		GameState: state,
-		OneLayout: make([]Color, len(colorDistribution)),
-		TwoLayout: make([]Color, len(colorDistribution)),
+// ReconstructGame derives a Game from state. It returns an error
+// if state is inconsistent, such as a state imported from another
+// server or loaded from a damaged database, rather than returning
+// a Game that would panic when played.
+func ReconstructGame(state GameState) (*Game, error) {
+	if err := state.validate(); err != nil {
+		return nil, err
+	}
+	g := &Game{
+		GameState:  state,
+		ExposedOne: make([]bool, len(colorDistribution)),
+		ExposedTwo: make([]bool, len(colorDistribution)),
+		ActiveTeam: state.startingTeam(),
+		Turn:       1,
+	}
+
+	// The words are drawn from the seed, and the layouts are
+	// assigned from the same source, unless the words come from
+	// a separate WordSeed. Share codes only identify boards
+	// drawn entirely from the seed, with the usual distribution
+	// of colors. A stored board isn't drawn at all.
+	//
+	// The words must be selected before the layouts are
+	// assigned: changing the order would change every board
+	// drawn from an existing seed.
+	if len(state.Board) > 0 {
+		g.Words = make([]string, len(state.Board))
+		g.OneLayout = make([]Color, len(state.Board))
+		g.TwoLayout = make([]Color, len(state.Board))
+		for i, c := range state.Board {
+			g.Words[i], g.OneLayout[i], g.TwoLayout[i] = c.Word, c.One, c.Two
+		}
+	} else {
+		layoutRnd := rand.New(rand.NewSource(int64(state.Seed)))
+		wordRnd := layoutRnd
+		if state.WordSeed != 0 {
+			wordRnd = rand.New(rand.NewSource(int64(state.WordSeed)))
+		} else if len(state.Distribution) == 0 && len(state.ListBounds) == 0 && !state.FilteredWords {
+			g.ShareCode = encodeShareCode(state.Seed, state.SourceLists)
+		}
+		if len(state.ListBounds) > 0 {
+			g.Words = selectBalanced(wordRnd, state.WordSet, state.ListBounds, len(colorDistribution))
+		} else {
+			g.Words = selectWords(wordRnd, state.WordSet, len(colorDistribution))
+		}
+		g.OneLayout, g.TwoLayout = assignLayouts(layoutRnd, state.distribution())
+	}
+	if len(state.WordDifficulty) > 0 {
+		g.Difficulties = make([]int, len(g.Words))
+		for i, w := range g.Words {
+			g.Difficulties[i] = state.WordDifficulty[w]
+		}
+	}
+	g.ExposedOneIndices = []int{}
+	g.ExposedTwoIndices = []int{}
+	if p := state.Prereveal; p != nil {
+		for _, i := range p.One {
+			g.ExposedOne[i] = true
+		}
+		for _, i := range p.Two {
+			g.ExposedTwo[i] = true
+		}
+		g.ExposedOneIndices = exposedIndices(g.ExposedOne)
+		g.ExposedTwoIndices = exposedIndices(g.ExposedTwo)
+	}
+	g.Contributions = map[string]Contribution{}
+	g.Clues = []Clue{}
+	g.Tokened = []TokenedCell{}
+	g.HasPassword = state.PasswordHash != ""
+	g.GreensNeeded = g.remainingGreens()
+	g.TokensLeft = state.startingTokens()
+	g.Status = outcomeStatus(state.OutcomeReason)
+	if state.TeamTurns > 0 {
+		g.TurnsLeft = map[int]int{TeamOne: state.TeamTurns, TeamTwo: state.TeamTurns}
+	}
+	g.updateHint(0)
+	g.updatePhase()
+
+	// Replay the game's events to recover whose turn it is
+	// and which cells have been revealed.
+	for _, e := range g.Events {
+		g.apply(e)
 	}
+	return g, nil
+}
+
+// Summary describes a game for the summary screen shown once the
+// game is over.
+type Summary struct {
+	SourceLists   []string      `json:"source_lists"`
+	PoolSize      int           `json:"pool_size"`
+	Words         []SummaryWord `json:"words"`
+	OutcomeReason string        `json:"outcome_reason,omitempty"`
+}
+
+// SummaryWord is a word on the board along with its color in each
+// team's layout. A color is omitted if it hasn't been revealed and
+// the game isn't over.
+type SummaryWord struct {
+	Word string `json:"word"`
+	One  *Color `json:"one,omitempty"`
+	Two  *Color `json:"two,omitempty"`
+}
 
-	rnd := rand.New(rand.NewSource(int64(state.Seed)))
+// summary returns the game's Summary.
+func (g *Game) summary() Summary {
+	pool := map[string]bool{}
+	for _, w := range g.WordSet {
+		pool[w] = true
+	}
+	over := g.status() != ""
+	s := Summary{
+		SourceLists:   g.SourceLists,
+		PoolSize:      len(pool),
+		Words:         make([]SummaryWord, len(g.Words)),
+		OutcomeReason: g.OutcomeReason,
+	}
+	for i, w := range g.Words {
+		s.Words[i].Word = w
+		if over || g.ExposedOne[i] {
+			s.Words[i].One = &g.OneLayout[i]
+		}
+		if over || g.ExposedTwo[i] {
+			s.Words[i].Two = &g.TwoLayout[i]
+		}
+	}
+	return s
+}
 
-	// Pick 25 random words.
-	used := make(map[string]bool, len(colorDistribution))
-	for len(used) < len(colorDistribution) {
-		w := state.WordSet[rnd.Intn(len(state.WordSet))]
+// boardColumns is the number of columns that a board's cells are
+// laid out in, row by row.
+const boardColumns = 5
+
+// FinalBoard is a compact description of a finished game's board,
+// for clients and services that render an image of the result to
+// share. Cells are in board order, filling Columns cells per row.
+type FinalBoard struct {
+	Columns       int         `json:"columns"`
+	Cells         []FinalCell `json:"cells"`
+	OutcomeReason string      `json:"outcome_reason"`
+	Won           bool        `json:"won"`
+	Turns         int         `json:"turns"`
+	GreensNeeded  int         `json:"greens_needed"`
+	FinishedAt    time.Time   `json:"finished_at"`
+}
+
+// FinalCell is a cell of a FinalBoard: its word, its color in each
+// team's layout, and whether either team revealed it.
+type FinalCell struct {
+	Word    string `json:"word"`
+	One     Color  `json:"color_one"`
+	Two     Color  `json:"color_two"`
+	Exposed bool   `json:"exposed"`
+}
+
+// finalBoard returns the game's FinalBoard. Like summary, it only
+// reveals the layouts once the game is over, and until then it
+// returns false instead.
+func (g *Game) finalBoard() (FinalBoard, bool) {
+	if g.status() == "" {
+		return FinalBoard{}, false
+	}
+	b := FinalBoard{
+		Columns:       boardColumns,
+		Cells:         make([]FinalCell, len(g.Words)),
+		OutcomeReason: g.OutcomeReason,
+		Won:           g.OutcomeReason == OutcomeAllGreen,
+		Turns:         g.Turn,
+		GreensNeeded:  g.GreensNeeded,
+		FinishedAt:    g.FinishedAt,
+	}
+	for i, w := range g.Words {
+		b.Cells[i] = FinalCell{
+			Word:    w,
+			One:     g.OneLayout[i],
+			Two:     g.TwoLayout[i],
+			Exposed: g.ExposedOne[i] || g.ExposedTwo[i],
+		}
+	}
+	return b, true
+}
+
+// rewind reconstructs the game described by state as it was
+// after its first n events. The board is re-derived from the seed,
+// so it's exactly the board the players saw at the time. state
+// itself isn't modified.
+func rewind(state *GameState, n int) (*Game, error) {
+	if n < 0 || n > len(state.Events) {
+		return nil, fmt.Errorf("event %d is out of range", n)
+	}
+
+	// The rewound game mustn't share its events or players with
+	// the game that state belongs to.
+	snapshot := *state
+	snapshot.Events = append([]Event{}, state.Events[:n]...)
+	snapshot.Players = make(map[string]Player, len(state.Players))
+	for id, p := range state.Players {
+		snapshot.Players[id] = p
+	}
+
+	g, err := ReconstructGame(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if g.status() == "" {
+		// The game hadn't ended yet.
+		g.OutcomeReason = ""
+		g.FinishedAt = time.Time{}
+		g.FinishedByPlayer = ""
+		g.FinishedByTeam = NoTeam
+		g.Status = StatusInProgress
+	}
+	return g, nil
+}
+
+// BoardMismatch is the first difference between a board computed
+// by a client and the board drawn by the server. Field is seed,
+// word_set_hash, words, one_layout or two_layout. Index is the
+// index of the differing cell, or -1 for the seed and word set
+// hash. Server and Client hold the differing values, with a value
+// missing from a shorter list left empty. While the game is in
+// progress, /verify-board only reports which layout differs, with
+// Index -1, since the layouts are hidden.
+type BoardMismatch struct {
+	Field  string `json:"field"`
+	Index  int    `json:"index"`
+	Server string `json:"server"`
+	Client string `json:"client"`
+}
+
+// compareBoard returns the first difference between g's board and
+// the words and layouts computed by a client, or nil if they're the
+// same.
+func (g *Game) compareBoard(words []string, one, two []Color) *BoardMismatch {
+	colors := func(layout []Color) []string {
+		s := make([]string, len(layout))
+		for i, c := range layout {
+			s[i] = c.String()
+		}
+		return s
+	}
+	for _, f := range []struct {
+		name           string
+		server, client []string
+	}{
+		{"words", g.Words, words},
+		{"one_layout", colors(g.OneLayout), colors(one)},
+		{"two_layout", colors(g.TwoLayout), colors(two)},
+	} {
+		for i := 0; i < len(f.server) || i < len(f.client); i++ {
+			var server, client string
+			if i < len(f.server) {
+				server = f.server[i]
+			}
+			if i < len(f.client) {
+				client = f.client[i]
+			}
+			if i >= len(f.server) || i >= len(f.client) || server != client {
+				return &BoardMismatch{Field: f.name, Index: i, Server: server, Client: client}
+			}
+		}
+	}
+	return nil
+}
+
+// assignLayouts randomly assigns the colors of each team's
+// layout, according to dist, which pairs the color of each cell
+// in team one's layout with its color in team two's.
+// ReconstructGame uses the distribution in the rule book,
+// colorDistribution, unless the game has its own.
+func assignLayouts(rnd *rand.Rand, dist [25][2]Color) (one, two []Color) {
+	one = make([]Color, len(dist))
+	two = make([]Color, len(dist))
+	perm := rnd.Perm(len(dist))
+	for i, colors := range dist {
+		one[perm[i]] = colors[0]
+		two[perm[i]] = colors[1]
+	}
+	return one, two
+}
+
+// pinBoard returns a board with the words in fixed pinned to
+// their cells, and the rest of the board drawn from wordSet and
+// dist using rnd. fixed must have passed checkFixed, and wordSet
+// must contain enough distinct words that aren't in fixed to
+// fill the rest of the board.
+func pinBoard(rnd *rand.Rand, wordSet []string, fixed []FixedCell, dist [25][2]Color) []BoardCell {
+	board := make([]BoardCell, len(colorDistribution))
+	pinned := make([]bool, len(colorDistribution))
+	var fixedWords []string
+	used := map[[2]Color]int{}
+	for _, f := range fixed {
+		board[f.Index] = BoardCell{Word: f.Word, One: f.One, Two: f.Two}
+		pinned[f.Index] = true
+		fixedWords = append(fixedWords, f.Word)
+		used[[2]Color{f.One, f.Two}]++
+	}
+
+	// The colors left over once the pinned cells' colors are
+	// taken from the distribution are shuffled into the rest
+	// of the board.
+	var colors [][2]Color
+	for _, c := range dist {
+		if used[c] > 0 {
+			used[c]--
+			continue
+		}
+		colors = append(colors, c)
+	}
+	words := selectWords(rnd, avoidWords(wordSet, fixedWords), len(colors))
+	perm := rnd.Perm(len(colors))
+
+	n := 0
+	for i := range board {
+		if pinned[i] {
+			continue
+		}
+		c := colors[perm[n]]
+		board[i] = BoardCell{Word: words[n], One: c[0], Two: c[1]}
+		n++
+	}
+	return board
+}
+
+// checkFixed checks that fixed may be pinned to a new board with
+// the colors of dist. If not, it returns the index into fixed of
+// the first offending cell and the problem with it.
+func checkFixed(fixed []FixedCell, dist [25][2]Color) (int, string) {
+	available := map[[2]Color]int{}
+	for _, c := range dist {
+		available[c]++
+	}
+	indices := map[int]bool{}
+	words := map[string]bool{}
+	for i, f := range fixed {
+		pair := [2]Color{f.One, f.Two}
+		switch {
+		case f.Index < 0 || f.Index >= len(colorDistribution):
+			return i, "index is out of range"
+		case indices[f.Index]:
+			return i, "index is pinned more than once"
+		case f.Word == "":
+			return i, "word is empty"
+		case words[f.Word]:
+			return i, "word is pinned more than once"
+		case available[pair] == 0:
+			return i, fmt.Sprintf("too many %s/%s cells", f.One, f.Two)
+		}
+		indices[f.Index] = true
+		words[f.Word] = true
+		available[pair]--
+	}
+	return -1, ""
+}
+
+// checkBoard checks that board is a complete board, with distinct
+// words and the colors of dist.
+func checkBoard(board []BoardCell, dist [25][2]Color) error {
+	if len(board) != len(dist) {
+		return fmt.Errorf("board has %d cells, must be %d", len(board), len(dist))
+	}
+	counts := map[[2]Color]int{}
+	for _, c := range dist {
+		counts[c]++
+	}
+	words := map[string]bool{}
+	for i, c := range board {
+		if words[c.Word] {
+			return fmt.Errorf("board cell %d repeats the word %q", i, c.Word)
+		}
+		words[c.Word] = true
+		counts[[2]Color{c.One, c.Two}]--
+	}
+	for pair, n := range counts {
+		if n != 0 {
+			return fmt.Errorf("board has the wrong number of %s/%s cells", pair[0], pair[1])
+		}
+	}
+	return nil
+}
+
+// The bounds on the number of blacks in each layout of a
+// distribution made by blackDistribution.
+const (
+	minBlacks = 1
+	maxBlacks = 7
+)
+
+// blackDistribution returns colorDistribution with n blacks in
+// each layout instead of three. Extra blacks replace tans, and
+// blacks that are removed become tans, so each layout still has
+// nine greens.
+func blackDistribution(n int) ([25][2]Color, error) {
+	dist := colorDistribution
+	if n < minBlacks || n > maxBlacks {
+		return dist, fmt.Errorf("blacks must be between %d and %d", minBlacks, maxBlacks)
+	}
+
+	var (
+		tanTan     = [2]Color{Tan, Tan}
+		blackTan   = [2]Color{Black, Tan}
+		tanBlack   = [2]Color{Tan, Black}
+		blackBlack = [2]Color{Black, Black}
+	)
+	count := func(pair [2]Color) (k int) {
+		for _, c := range dist {
+			if c == pair {
+				k++
+			}
+		}
+		return k
+	}
+	replace := func(from, to [2]Color) {
+		for i := len(dist) - 1; i >= 0; i-- {
+			if dist[i] == from {
+				dist[i] = to
+				return
+			}
+		}
+	}
+
+	// Each step adds or removes a black in both layouts, in
+	// separate cells if possible.
+	blacks := countColor(dist[:], 0, Black)
+	for ; blacks < n; blacks++ {
+		if count(tanTan) >= 2 {
+			replace(tanTan, blackTan)
+			replace(tanTan, tanBlack)
+		} else {
+			replace(tanTan, blackBlack)
+		}
+	}
+	for ; blacks > n; blacks-- {
+		if count(blackTan) > 0 && count(tanBlack) > 0 {
+			replace(blackTan, tanTan)
+			replace(tanBlack, tanTan)
+		} else {
+			replace(blackBlack, tanTan)
+		}
+	}
+	return dist, nil
+}
+
+// checkDistribution checks that dist is a distribution of colors
+// for a whole board, with nine greens in each layout.
+func checkDistribution(dist [][2]Color) error {
+	if len(dist) != len(colorDistribution) {
+		return fmt.Errorf("distribution has %d cells, must be %d", len(dist), len(colorDistribution))
+	}
+	for layout := 0; layout < 2; layout++ {
+		if n := countColor(dist, layout, Green); n != 9 {
+			return fmt.Errorf("distribution has %d greens in layout %d, must be 9", n, layout+1)
+		}
+	}
+	return nil
+}
+
+// countColor returns the number of cells of dist that are c in
+// the given layout, 0 for team one's and 1 for team two's.
+func countColor(dist [][2]Color, layout int, c Color) (n int) {
+	for _, pair := range dist {
+		if pair[layout] == c {
+			n++
+		}
+	}
+	return n
+}
+
+// selectWords draws n distinct random words from wordSet, which
+// must contain at least n distinct words.
+func selectWords(rnd *rand.Rand, wordSet []string, n int) []string {
+	words := make([]string, 0, n)
+	used := make(map[string]bool, n)
+	for len(used) < n {
+		w := wordSet[rnd.Intn(len(wordSet))]
 		if !used[w] {
-			g.Words = append(g.Words, w)
+			words = append(words, w)
 			used[w] = true
 		}
 	}
+	return words
+}
+
+// selectBalanced draws n distinct random words from the pools of
+// wordSet divided by bounds, as evenly as the pools' sizes allow,
+// in a random order. The pools must be disjoint and hold at least
+// n words between them.
+func selectBalanced(rnd *rand.Rand, wordSet []string, bounds []int, n int) []string {
+	// Deal out the number of words to draw from each pool one
+	// at a time, in a random order of pools, skipping the pools
+	// that have run out.
+	quotas := make([]int, len(bounds))
+	order := rnd.Perm(len(bounds))
+	for dealt := 0; dealt < n; {
+		for _, i := range order {
+			start := 0
+			if i > 0 {
+				start = bounds[i-1]
+			}
+			if dealt < n && quotas[i] < bounds[i]-start {
+				quotas[i]++
+				dealt++
+			}
+		}
+	}
 
-	// Assign the colors for each team, according to the
-	// relative distribution in the rule book.
-	perm := rnd.Perm(len(colorDistribution))
-	for i, colors := range colorDistribution {
-		g.OneLayout[perm[i]] = colors[0]
-		g.TwoLayout[perm[i]] = colors[1]
+	words := make([]string, 0, n)
+	start := 0
+	for i, end := range bounds {
+		words = append(words, selectWords(rnd, wordSet[start:end], quotas[i])...)
+		start = end
+	}
+	rnd.Shuffle(len(words), func(i, j int) {
+		words[i], words[j] = words[j], words[i]
+	})
+	return words
+}
+
+// checkListBounds checks that bounds divides a word set of size n
+// into non-empty pools.
+func checkListBounds(bounds []int, n int) error {
+	prev := 0
+	for i, b := range bounds {
+		if b <= prev {
+			return fmt.Errorf("list_bounds[%d] is %d, must be greater than %d", i, b, prev)
+		}
+		prev = b
+	}
+	if prev != n {
+		return fmt.Errorf("list_bounds ends at %d, must end at %d", prev, n)
 	}
-	return g
+	return nil
 }
 
 var colorDistribution = [25][2]Color{
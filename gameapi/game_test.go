@@ -1,6 +1,11 @@
 package gameapi
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -9,9 +14,867 @@ var exampleWords = []string{"AFRICA", "AGENT", "AIR", "ALIEN", "ALPS", "AMAZON",
 
 func TestConstructGame(t *testing.T) {
 	state := NewState(0, exampleWords)
-	game := ReconstructGame(state)
+	game := mustReconstruct(t, state)
 	game.markSeen("alice", "alice", 1, time.Now())
-	if len(game.players) != 1 {
-		t.Errorf("len(game.players) = %d, want %d", len(game.players), 1)
+	if len(game.Players) != 1 {
+		t.Errorf("len(game.Players) = %d, want %d", len(game.Players), 1)
+	}
+}
+
+// mustReconstruct reconstructs a game from state, failing the
+// test if state is invalid.
+func mustReconstruct(t *testing.T, state GameState) *Game {
+	t.Helper()
+	g, err := ReconstructGame(state)
+	if err != nil {
+		t.Fatalf("ReconstructGame: %s", err)
+	}
+	return g
+}
+
+// indexOf returns the index of the first cell of layout with color c
+// for which skip returns false.
+func indexOf(layout []Color, c Color, skip func(int) bool) int {
+	for i, lc := range layout {
+		if lc == c && !skip(i) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestTurns(t *testing.T) {
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	if game.ActiveTeam != TeamOne || game.Turn != 1 {
+		t.Fatalf("new game active team, turn = %d, %d, want %d, 1", game.ActiveTeam, game.Turn, TeamOne)
+	}
+
+	never := func(int) bool { return false }
+	now := time.Now()
+
+	// A green guess doesn't end the turn.
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, never), now)
+	if game.ActiveTeam != TeamOne || game.Turn != 1 {
+		t.Errorf("after green guess active team, turn = %d, %d, want %d, 1", game.ActiveTeam, game.Turn, TeamOne)
+	}
+
+	// A tan guess does.
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, never), now)
+	if game.ActiveTeam != TeamTwo || game.Turn != 2 {
+		t.Errorf("after tan guess active team, turn = %d, %d, want %d, 2", game.ActiveTeam, game.Turn, TeamTwo)
+	}
+
+	// Guesses out of turn are ignored.
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, func(i int) bool { return game.ExposedTwo[i] }), now)
+	if game.ActiveTeam != TeamTwo || game.Turn != 2 {
+		t.Errorf("after out of turn guess active team, turn = %d, %d, want %d, 2", game.ActiveTeam, game.Turn, TeamTwo)
+	}
+
+	game.addEvent(Event{Type: "end_turn", Team: TeamTwo})
+	if game.ActiveTeam != TeamOne || game.Turn != 3 {
+		t.Errorf("after end turn active team, turn = %d, %d, want %d, 3", game.ActiveTeam, game.Turn, TeamOne)
+	}
+
+	// The turn survives reconstruction.
+	reconstructed := mustReconstruct(t, game.GameState)
+	if reconstructed.ActiveTeam != game.ActiveTeam || reconstructed.Turn != game.Turn {
+		t.Errorf("reconstructed active team, turn = %d, %d, want %d, %d",
+			reconstructed.ActiveTeam, reconstructed.Turn, game.ActiveTeam, game.Turn)
+	}
+}
+
+func TestDrawWords(t *testing.T) {
+	pool := []string{"A", "B", "B", "C", "D", "E"}
+	for seed := int64(0); seed < 10; seed++ {
+		words := selectWords(rand.New(rand.NewSource(seed)), pool, 5)
+		if len(words) != 5 {
+			t.Fatalf("len(selectWords(..., 5)) = %d, want 5", len(words))
+		}
+		seen := map[string]bool{}
+		for _, w := range words {
+			if seen[w] {
+				t.Errorf("selectWords(...) = %v, contains duplicate %q", words, w)
+			}
+			seen[w] = true
+		}
+	}
+}
+
+// TestReconstructGolden pins the board drawn from a known seed,
+// since changing it would break every shared seed.
+func TestReconstructGolden(t *testing.T) {
+	game := mustReconstruct(t, NewState(42, exampleWords))
+	wantWords := []string{"SCHOOL", "KNIFE", "CHEST", "SUIT", "MOLE", "GHOST", "TAIL", "TRUNK", "FIGHTER", "OLYMPUS", "SOUND", "TIME", "MERCURY", "BELL", "PARK", "PART", "CANADA", "LION", "SPOT", "SCALE", "PRINCESS", "FLUTE", "OPERA", "CLOAK", "GERMANY"}
+	wantOne := []Color{Tan, Tan, Tan, Green, Tan, Tan, Black, Tan, Black, Green, Green, Green, Green, Green, Tan, Green, Tan, Green, Green, Tan, Black, Tan, Tan, Tan, Tan}
+	wantTwo := []Color{Black, Green, Green, Green, Green, Tan, Tan, Green, Green, Tan, Tan, Green, Green, Tan, Tan, Tan, Tan, Black, Tan, Tan, Black, Green, Tan, Tan, Tan}
+	if !reflect.DeepEqual(game.Words, wantWords) {
+		t.Errorf("Words = %v, want %v", game.Words, wantWords)
+	}
+	if !reflect.DeepEqual(game.OneLayout, wantOne) {
+		t.Errorf("OneLayout = %v, want %v", game.OneLayout, wantOne)
+	}
+	if !reflect.DeepEqual(game.TwoLayout, wantTwo) {
+		t.Errorf("TwoLayout = %v, want %v", game.TwoLayout, wantTwo)
+	}
+}
+
+func TestWordSetHash(t *testing.T) {
+	reversed := make([]string, len(exampleWords))
+	for i, w := range exampleWords {
+		reversed[len(reversed)-1-i] = w
+	}
+	if wordSetHash(reversed) != wordSetHash(exampleWords) {
+		t.Errorf("wordSetHash depends on the order of the words")
+	}
+
+	state := NewState(0, exampleWords)
+	state.WordSet = exampleWords[1:]
+	if _, err := ReconstructGame(state); err == nil {
+		t.Errorf("ReconstructGame with a different word set succeeded, want error")
+	}
+	state.WordSetHash = ""
+	mustReconstruct(t, state)
+}
+
+func TestReconstructInvalidState(t *testing.T) {
+	repeated := make([]string, 30)
+	for i := range repeated {
+		repeated[i] = exampleWords[i%10]
+	}
+	testCases := map[string]GameState{
+		"few words":    {WordSet: repeated},
+		"guess index":  {WordSet: exampleWords, Events: []Event{{Number: 1, Type: "guess", Team: TeamOne, Index: 25}}},
+		"event number": {WordSet: exampleWords, Events: []Event{{Number: 2, Type: "end_turn", Team: TeamOne}}},
+		"list bounds":  {WordSet: exampleWords, ListBounds: []int{100, 50}},
+	}
+	for name, state := range testCases {
+		if _, err := ReconstructGame(state); err == nil {
+			t.Errorf("%s: ReconstructGame succeeded, want error", name)
+		}
+	}
+}
+
+func TestAssignLayouts(t *testing.T) {
+	want := map[[2]Color]int{}
+	for _, colors := range colorDistribution {
+		want[colors]++
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		one, two := assignLayouts(rand.New(rand.NewSource(seed)), colorDistribution)
+		got := map[[2]Color]int{}
+		for i := range one {
+			got[[2]Color{one[i], two[i]}]++
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("assignLayouts with seed %d distribution = %v, want %v", seed, got, want)
+		}
+	}
+}
+
+func TestBlackDistribution(t *testing.T) {
+	for n := minBlacks; n <= maxBlacks; n++ {
+		dist, err := blackDistribution(n)
+		if err != nil {
+			t.Fatalf("blackDistribution(%d): %s", n, err)
+		}
+		for layout := 0; layout < 2; layout++ {
+			if got := countColor(dist[:], layout, Black); got != n {
+				t.Errorf("blackDistribution(%d) has %d blacks in layout %d", n, got, layout+1)
+			}
+		}
+		if err := checkDistribution(dist[:]); err != nil {
+			t.Errorf("blackDistribution(%d): %s", n, err)
+		}
+	}
+	if dist, _ := blackDistribution(3); dist != colorDistribution {
+		t.Errorf("blackDistribution(3) = %v, want colorDistribution", dist)
+	}
+	for _, n := range []int{minBlacks - 1, maxBlacks + 1} {
+		if _, err := blackDistribution(n); err == nil {
+			t.Errorf("blackDistribution(%d) succeeded, want error", n)
+		}
+	}
+}
+
+func TestWordSeed(t *testing.T) {
+	original := mustReconstruct(t, NewState(1, exampleWords))
+
+	state := NewState(2, exampleWords)
+	state.WordSeed = 1
+	reshuffled := mustReconstruct(t, state)
+	if !reflect.DeepEqual(reshuffled.Words, original.Words) {
+		t.Errorf("words with word seed 1 = %v, want %v", reshuffled.Words, original.Words)
+	}
+	if reflect.DeepEqual(reshuffled.OneLayout, original.OneLayout) {
+		t.Errorf("layout with seed 2 and word seed 1 matches the layout with seed 1")
+	}
+	if reshuffled.ShareCode != "" {
+		t.Errorf("share code with a word seed = %q, want none", reshuffled.ShareCode)
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	c := idempotencyCache{}
+	now := time.Now()
+	c.put("a", 1, now)
+	if resp, ok := c.get("a", now.Add(time.Minute)); !ok || resp != 1 {
+		t.Errorf("get(a) = %v, %t, want 1, true", resp, ok)
+	}
+	if _, ok := c.get("a", now.Add(idempotencyWindow+time.Second)); ok {
+		t.Errorf("get(a) after window = true, want false")
+	}
+
+	for i := 0; i < 2*maxIdempotencyKeys; i++ {
+		c.put(fmt.Sprint(i), i, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(c) > maxIdempotencyKeys {
+		t.Errorf("len(cache) = %d, want at most %d", len(c), maxIdempotencyKeys)
+	}
+	if _, ok := c.get(fmt.Sprint(2*maxIdempotencyKeys-1), now); !ok {
+		t.Errorf("most recent key was evicted")
+	}
+}
+
+func TestChatBuffer(t *testing.T) {
+	var b chatBuffer
+	if msgs := b.since(0); len(msgs) != 0 {
+		t.Errorf("empty buffer has %d messages, want 0", len(msgs))
+	}
+	for i := 1; i <= maxChatMessages+10; i++ {
+		if m := b.add(ChatMessage{Message: fmt.Sprint(i)}); m.Number != i {
+			t.Fatalf("message %d numbered %d", i, m.Number)
+		}
+	}
+
+	// The oldest messages were evicted.
+	msgs := b.since(0)
+	if len(msgs) != maxChatMessages || msgs[0].Number != 11 || msgs[len(msgs)-1].Number != maxChatMessages+10 {
+		t.Errorf("since(0) returned %d messages numbered %d to %d, want %d numbered 11 to %d",
+			len(msgs), msgs[0].Number, msgs[len(msgs)-1].Number, maxChatMessages, maxChatMessages+10)
+	}
+	msgs = b.since(maxChatMessages + 5)
+	if len(msgs) != 5 || msgs[0].Message != fmt.Sprint(maxChatMessages+6) {
+		t.Errorf("since(%d) = %v, want the last 5 messages", maxChatMessages+5, msgs)
+	}
+}
+
+func TestPresenceEvents(t *testing.T) {
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	now := time.Now()
+	game.markSeen("alice", "alice", NoTeam, now)
+	game.markSeen("alice", "alice", NoTeam, now.Add(10*time.Second))
+	game.markSeen("alice", "alice", NoTeam, now.Add(20*time.Second))
+	game.pruneOldPlayers(now.Add(10 * time.Minute))
+
+	var presence []string
+	for _, e := range game.Events {
+		if e.Type == "presence" {
+			presence = append(presence, e.Presence)
+		}
+	}
+	if len(presence) != 2 || presence[0] != "joined" || presence[1] != "left" {
+		t.Errorf("presence events = %v, want [joined left]", presence)
+	}
+}
+
+func TestOutcomeReason(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	never := func(int) bool { return false }
+
+	// Revealing a black loses the game.
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	if game.Status != StatusInProgress {
+		t.Errorf("new game status = %q, want %q", game.Status, StatusInProgress)
+	}
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Black, never), now)
+	if game.OutcomeReason != OutcomeAssassin || !game.FinishedAt.Equal(now) {
+		t.Errorf("after black outcome = %q at %s, want %q at %s", game.OutcomeReason, game.FinishedAt, OutcomeAssassin, now)
+	}
+	if game.Status != StatusLostAssassin {
+		t.Errorf("after black status = %q, want %q", game.Status, StatusLostAssassin)
+	}
+	if game.FinishedByPlayer != "alice" || game.FinishedByTeam != TeamOne {
+		t.Errorf("after black finished by %q on team %d, want %q on team %d", game.FinishedByPlayer, game.FinishedByTeam, "alice", TeamOne)
+	}
+	reconstructed := mustReconstruct(t, game.GameState)
+	if reconstructed.OutcomeReason != OutcomeAssassin || reconstructed.status() != OutcomeAssassin || reconstructed.Status != StatusLostAssassin {
+		t.Errorf("reconstructed outcome = %q with status %q, want %q with status %q",
+			reconstructed.OutcomeReason, reconstructed.Status, OutcomeAssassin, StatusLostAssassin)
+	}
+	if reconstructed.FinishedByPlayer != "alice" || reconstructed.FinishedByTeam != TeamOne {
+		t.Errorf("reconstructed finished by %q on team %d, want %q on team %d",
+			reconstructed.FinishedByPlayer, reconstructed.FinishedByTeam, "alice", TeamOne)
+	}
+
+	// Running out of turns loses the game.
+	game = mustReconstruct(t, NewState(0, exampleWords))
+	for i := 0; i < timerTokens; i++ {
+		if game.OutcomeReason != "" {
+			t.Fatalf("game ended after %d turns with %q", i, game.OutcomeReason)
+		}
+		team := game.ActiveTeam
+		game.addEvent(Event{Type: "end_turn", Team: team})
+		game.checkFinished("alice", team, now)
+	}
+	if game.OutcomeReason != OutcomeNoTokens || game.Status != StatusLostTime {
+		t.Errorf("after %d turns outcome = %q with status %q, want %q with status %q",
+			timerTokens, game.OutcomeReason, game.Status, OutcomeNoTokens, StatusLostTime)
+	}
+
+	// Revealing every green wins the game.
+	game = mustReconstruct(t, NewState(0, exampleWords))
+	players := map[int]string{TeamOne: "alice", TeamTwo: "bob"}
+	team := NoTeam
+	for game.OutcomeReason == "" {
+		team = game.ActiveTeam
+		layout, exposed := game.TwoLayout, game.ExposedTwo
+		if team == TeamTwo {
+			layout, exposed = game.OneLayout, game.ExposedOne
+		}
+		i := indexOf(layout, Green, func(i int) bool { return exposed[i] })
+		if i == -1 {
+			t.Fatalf("team %d has no greens left to guess, but the game isn't over", team)
+		}
+		game.guess(players[team], players[team], team, i, now)
+	}
+	if game.OutcomeReason != OutcomeAllGreen || game.Status != StatusWon {
+		t.Errorf("after revealing every green outcome = %q with status %q, want %q with status %q",
+			game.OutcomeReason, game.Status, OutcomeAllGreen, StatusWon)
+	}
+	if game.FinishedByPlayer != players[team] || game.FinishedByTeam != team {
+		t.Errorf("after revealing every green finished by %q on team %d, want %q on team %d",
+			game.FinishedByPlayer, game.FinishedByTeam, players[team], team)
+	}
+}
+
+func TestGreensNeeded(t *testing.T) {
+	now := time.Now()
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	if game.GreensNeeded != 15 {
+		t.Fatalf("new game greens needed = %d, want 15", game.GreensNeeded)
+	}
+
+	// Revealing a cell that's green in both layouts counts once,
+	// even once the other team has revealed it too.
+	double := indexOf(game.TwoLayout, Green, func(i int) bool { return game.OneLayout[i] != Green })
+	game.guess("alice", "alice", TeamOne, double, now)
+	if game.GreensNeeded != 14 {
+		t.Errorf("after double green guess greens needed = %d, want 14", game.GreensNeeded)
+	}
+	game.addEvent(Event{Type: "end_turn", Team: TeamOne})
+	game.guess("bob", "bob", TeamTwo, double, now)
+	if game.GreensNeeded != 14 {
+		t.Errorf("after repeated double green guess greens needed = %d, want 14", game.GreensNeeded)
+	}
+
+	// A cell that's green only in team two's layout.
+	single := indexOf(game.TwoLayout, Green, func(i int) bool { return game.OneLayout[i] == Green })
+	game = mustReconstruct(t, NewState(0, exampleWords))
+	game.guess("alice", "alice", TeamOne, single, now)
+	if game.GreensNeeded != 14 {
+		t.Errorf("after single green guess greens needed = %d, want 14", game.GreensNeeded)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); reconstructed.GreensNeeded != game.GreensNeeded {
+		t.Errorf("reconstructed greens needed = %d, want %d", reconstructed.GreensNeeded, game.GreensNeeded)
+	}
+}
+
+func TestDoubleGreens(t *testing.T) {
+	want := 0
+	for _, c := range colorDistribution {
+		if c == [2]Color{Green, Green} {
+			want++
+		}
+	}
+
+	now := time.Now()
+	for _, mirror := range []bool{false, true} {
+		state := NewState(0, exampleWords)
+		state.MirrorDoubleGreens = mirror
+		game := mustReconstruct(t, state)
+		var doubles []int
+		for i := range game.Words {
+			if game.doubleGreen(i) {
+				doubles = append(doubles, i)
+			}
+		}
+		if len(doubles) != want {
+			t.Fatalf("mirror %t: %d double greens, want %d", mirror, len(doubles), want)
+		}
+
+		// Team one reveals the double greens in team two's
+		// layout, which satisfies both teams.
+		for _, i := range doubles {
+			game.guess("alice", "alice", TeamOne, i, now)
+		}
+		if game.GreensNeeded != 15-want {
+			t.Errorf("mirror %t: greens needed = %d, want %d", mirror, game.GreensNeeded, 15-want)
+		}
+		for _, team := range []int{TeamOne, TeamTwo} {
+			if n := game.hiddenGreens(team); n != 9-want {
+				t.Errorf("mirror %t: team %d has %d hidden greens, want %d", mirror, team, n, 9-want)
+			}
+		}
+		for _, i := range doubles {
+			if !game.ExposedTwo[i] || game.ExposedOne[i] != mirror {
+				t.Errorf("mirror %t: cell %d exposed one, two = %t, %t, want %t, true",
+					mirror, i, game.ExposedOne[i], game.ExposedTwo[i], mirror)
+			}
+		}
+		if reconstructed := mustReconstruct(t, game.GameState); !reflect.DeepEqual(reconstructed.ExposedOne, game.ExposedOne) {
+			t.Errorf("mirror %t: reconstructed exposed one = %v, want %v", mirror, reconstructed.ExposedOne, game.ExposedOne)
+		}
+	}
+}
+
+func TestPrereveal(t *testing.T) {
+	state := NewState(0, exampleWords)
+	game := mustReconstruct(t, state)
+
+	// Prereveal every green except one of team two's that's tan or
+	// black for team one.
+	p := &Prereveal{}
+	last := -1
+	for i := range game.Words {
+		if game.OneLayout[i] == Green {
+			p.One = append(p.One, i)
+		}
+		if game.TwoLayout[i] == Green {
+			if last < 0 && game.OneLayout[i] != Green {
+				last = i
+				continue
+			}
+			p.Two = append(p.Two, i)
+		}
+	}
+	state.Prereveal = p
+	game = mustReconstruct(t, state)
+	if game.GreensNeeded != 1 || game.status() != "" || game.checkPrereveal() != "" {
+		t.Fatalf("greens needed = %d, status = %q, want 1 green needed in a game in progress", game.GreensNeeded, game.status())
+	}
+	if len(game.ExposedOneIndices) != len(p.One) || len(game.ExposedTwoIndices) != len(p.Two) {
+		t.Errorf("exposed %v and %v, want %v and %v", game.ExposedOneIndices, game.ExposedTwoIndices, p.One, p.Two)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); !reflect.DeepEqual(reconstructed.ExposedTwo, game.ExposedTwo) {
+		t.Errorf("reconstructed exposed two = %v, want %v", reconstructed.ExposedTwo, game.ExposedTwo)
+	}
+
+	// Revealing the last green wins.
+	game.guess("alice", "alice", TeamOne, last, time.Now())
+	if game.OutcomeReason != OutcomeAllGreen {
+		t.Errorf("outcome = %q, want %q", game.OutcomeReason, OutcomeAllGreen)
+	}
+
+	// Cells must be in range.
+	state.Prereveal = &Prereveal{Two: []int{len(colorDistribution)}}
+	if _, err := ReconstructGame(state); err == nil {
+		t.Error("ReconstructGame with an out of range prereveal succeeded")
+	}
+}
+
+func TestHintMode(t *testing.T) {
+	now := time.Now()
+	never := func(int) bool { return false }
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	if game.Hint != nil {
+		t.Errorf("hint without hint mode = %d, want none", *game.Hint)
+	}
+	b, err := json.Marshal(&game)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(`"hint"`)) {
+		t.Errorf("game without hint mode marshals to %s, want no hint", b)
+	}
+
+	state := NewState(0, exampleWords)
+	state.HintMode = true
+	game = mustReconstruct(t, state)
+	if game.Hint == nil || *game.Hint != 9 {
+		t.Fatalf("hint at the start of the game = %v, want 9", game.Hint)
+	}
+
+	// The hint is withdrawn once the team starts guessing, and
+	// updated at the start of the next turn.
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, never), now)
+	if game.Hint != nil {
+		t.Errorf("hint after a guess = %d, want none", *game.Hint)
+	}
+	game.addEvent(Event{Type: "end_turn", Team: TeamOne})
+	if want := game.hiddenGreens(TeamOne); game.Hint == nil || *game.Hint != want {
+		t.Errorf("hint on turn 2 = %v, want %d", game.Hint, want)
+	}
+	game.addEvent(Event{Type: "end_turn", Team: TeamTwo})
+	if game.Hint == nil || *game.Hint != 8 {
+		t.Errorf("hint on turn 3 = %v, want 8", game.Hint)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); !reflect.DeepEqual(reconstructed.Hint, game.Hint) {
+		t.Errorf("reconstructed hint = %v, want %v", reconstructed.Hint, game.Hint)
+	}
+}
+
+func TestContributions(t *testing.T) {
+	now := time.Now()
+	never := func(int) bool { return false }
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, never), now)
+	game.guess("bob", "bob", TeamOne, indexOf(game.TwoLayout, Green, func(i int) bool { return game.ExposedTwo[i] }), now)
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, never), now)
+	game.guess("carol", "carol", TeamTwo, indexOf(game.OneLayout, Green, never), now)
+
+	want := map[string]Contribution{
+		"alice": {Correct: 1, Incorrect: 1},
+		"bob":   {Correct: 1},
+		"carol": {Correct: 1},
+	}
+	if !reflect.DeepEqual(game.Contributions, want) {
+		t.Errorf("contributions = %v, want %v", game.Contributions, want)
+	}
+
+	// The counts match a replay of the history.
+	replayed := map[string]Contribution{}
+	for _, e := range game.Events {
+		if e.Type != "guess" {
+			continue
+		}
+		layout := game.TwoLayout
+		if e.Team == TeamTwo {
+			layout = game.OneLayout
+		}
+		c := replayed[e.PlayerID]
+		if layout[e.Index] == Green {
+			c.Correct++
+		} else {
+			c.Incorrect++
+		}
+		replayed[e.PlayerID] = c
+	}
+	if !reflect.DeepEqual(game.Contributions, replayed) {
+		t.Errorf("contributions = %v, want %v from replaying the history", game.Contributions, replayed)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); !reflect.DeepEqual(reconstructed.Contributions, game.Contributions) {
+		t.Errorf("reconstructed contributions = %v, want %v", reconstructed.Contributions, game.Contributions)
+	}
+}
+
+func TestManualEndTurn(t *testing.T) {
+	now := time.Now()
+	for _, manual := range []bool{false, true} {
+		state := NewState(0, exampleWords)
+		state.ManualEndTurn = manual
+		game := mustReconstruct(t, state)
+		unexposed := func(i int) bool { return game.ExposedTwo[i] }
+		game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, unexposed), now)
+		game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, unexposed), now)
+
+		want := TeamTwo
+		if manual {
+			want = TeamOne
+		}
+		if game.ActiveTeam != want {
+			t.Errorf("manual %t: active team after a green and a tan = %d, want %d", manual, game.ActiveTeam, want)
+		}
+		if !manual {
+			continue
+		}
+
+		// The team can keep guessing until it ends its turn.
+		game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, unexposed), now)
+		if game.ActiveTeam != TeamOne || game.Turn != 1 {
+			t.Errorf("active team after another green = %d on turn %d, want %d on turn 1", game.ActiveTeam, game.Turn, TeamOne)
+		}
+		game.addEvent(Event{Type: "end_turn", Team: TeamOne, PlayerID: "alice"})
+		if game.ActiveTeam != TeamTwo || game.Turn != 2 {
+			t.Errorf("active team after ending the turn = %d on turn %d, want %d on turn 2", game.ActiveTeam, game.Turn, TeamTwo)
+		}
+	}
+}
+
+func TestRewind(t *testing.T) {
+	now := time.Now()
+	never := func(int) bool { return false }
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	green := indexOf(game.TwoLayout, Green, never)
+	game.guess("alice", "alice", TeamOne, green, now)
+	n := len(game.Events)
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Black, never), now)
+
+	rewound, err := rewind(&game.GameState, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewound.Events) != n || !rewound.ExposedTwo[green] {
+		t.Errorf("rewound to %d events, got %d events with green exposed %t", n, len(rewound.Events), rewound.ExposedTwo[green])
+	}
+	if rewound.OutcomeReason != "" || rewound.Status != StatusInProgress || rewound.exposedBlack() {
+		t.Errorf("rewound game outcome = %q with status %q, want it in progress", rewound.OutcomeReason, rewound.Status)
+	}
+	if !reflect.DeepEqual(rewound.Words, game.Words) || !reflect.DeepEqual(rewound.TwoLayout, game.TwoLayout) {
+		t.Errorf("rewound game has a different board")
+	}
+
+	// The original game is untouched.
+	if game.OutcomeReason != OutcomeAssassin || len(game.Events) == n {
+		t.Errorf("original game changed by rewind: outcome %q, %d events", game.OutcomeReason, len(game.Events))
+	}
+	rewound.addEvent(Event{Type: "chat", Message: "hi"})
+	if game.Events[n].Type == "chat" {
+		t.Errorf("rewound game shares events with the original")
+	}
+
+	if _, err := rewind(&game.GameState, len(game.Events)+1); err == nil {
+		t.Errorf("rewind past the last event succeeded, want error")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	never := func(int) bool { return false }
+	state := NewState(0, append(exampleWords, exampleWords[0]))
+	state.SourceLists = []string{"example"}
+	game := mustReconstruct(t, state)
+	green := indexOf(game.TwoLayout, Green, never)
+	game.guess("alice", "alice", TeamOne, green, time.Now())
+
+	s := game.summary()
+	if s.PoolSize != len(exampleWords) || !reflect.DeepEqual(s.SourceLists, []string{"example"}) {
+		t.Errorf("summary pool size, lists = %d, %v, want %d, [example]", s.PoolSize, s.SourceLists, len(exampleWords))
+	}
+	if len(s.Words) != len(game.Words) {
+		t.Fatalf("summary has %d words, want %d", len(s.Words), len(game.Words))
+	}
+	for i, w := range s.Words {
+		if w.Word != game.Words[i] {
+			t.Errorf("summary word %d = %q, want %q", i, w.Word, game.Words[i])
+		}
+		if w.One != nil || (w.Two != nil) != (i == green) {
+			t.Errorf("summary word %d reveals colors %v, %v before the game is over", i, w.One, w.Two)
+		}
+	}
+
+	// Once the game is over, every color is revealed.
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Black, never), time.Now())
+	for i, w := range game.summary().Words {
+		if w.One == nil || *w.One != game.OneLayout[i] || w.Two == nil || *w.Two != game.TwoLayout[i] {
+			t.Errorf("summary word %d colors = %v, %v, want %v, %v", i, w.One, w.Two, game.OneLayout[i], game.TwoLayout[i])
+		}
+	}
+}
+
+func TestExposedIndices(t *testing.T) {
+	// agree returns true iff indices lists exactly the exposed
+	// cells, in increasing order.
+	agree := func(indices []int, exposed []bool) bool {
+		j := 0
+		for i, e := range exposed {
+			if e {
+				if j >= len(indices) || indices[j] != i {
+					return false
+				}
+				j++
+			}
+		}
+		return j == len(indices)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	for i := 0; i < 20 && game.OutcomeReason == ""; i++ {
+		game.guess("alice", "alice", game.ActiveTeam, rnd.Intn(len(game.Words)), time.Now())
+		if !agree(game.ExposedOneIndices, game.ExposedOne) || !agree(game.ExposedTwoIndices, game.ExposedTwo) {
+			t.Fatalf("exposed indices %v, %v disagree with %v, %v", game.ExposedOneIndices,
+				game.ExposedTwoIndices, game.ExposedOne, game.ExposedTwo)
+		}
+	}
+
+	reconstructed := mustReconstruct(t, game.GameState)
+	if !reflect.DeepEqual(reconstructed.ExposedOneIndices, game.ExposedOneIndices) ||
+		!reflect.DeepEqual(reconstructed.ExposedTwoIndices, game.ExposedTwoIndices) {
+		t.Errorf("reconstructed indices = %v, %v, want %v, %v", reconstructed.ExposedOneIndices,
+			reconstructed.ExposedTwoIndices, game.ExposedOneIndices, game.ExposedTwoIndices)
+	}
+}
+
+func TestFinalBoard(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	never := func(int) bool { return false }
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	if _, ok := game.finalBoard(); ok {
+		t.Fatal("finalBoard succeeded for a game in progress")
+	}
+
+	green := indexOf(game.TwoLayout, Green, never)
+	black := indexOf(game.TwoLayout, Black, never)
+	game.guess("alice", "alice", TeamOne, green, now)
+	game.guess("alice", "alice", TeamOne, black, now)
+	b, ok := game.finalBoard()
+	if !ok {
+		t.Fatal("finalBoard failed for a finished game")
+	}
+	if b.OutcomeReason != OutcomeAssassin || b.Won || b.Columns != 5 || len(b.Cells) != len(game.Words) {
+		t.Fatalf("final board = %+v, want a lost 5-column board of %d cells", b, len(game.Words))
+	}
+	for i, c := range b.Cells {
+		want := FinalCell{Word: game.Words[i], One: game.OneLayout[i], Two: game.TwoLayout[i], Exposed: i == green || i == black}
+		if c != want {
+			t.Errorf("cell %d = %+v, want %+v", i, c, want)
+		}
+	}
+}
+
+func TestTeamTurns(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	state := NewState(0, exampleWords)
+	state.TeamTurns = 2
+	game := mustReconstruct(t, state)
+	if game.TurnsLeft[TeamOne] != 2 || game.TurnsLeft[TeamTwo] != 2 {
+		t.Fatalf("new game turns left = %v, want 2 each", game.TurnsLeft)
+	}
+
+	// Each team uses up its turns by revealing tans.
+	guessTan := func(player string, team int) {
+		layout, exposed := game.TwoLayout, game.ExposedTwo
+		if team == TeamTwo {
+			layout, exposed = game.OneLayout, game.ExposedOne
+		}
+		game.guess(player, player, team, indexOf(layout, Tan, func(i int) bool { return exposed[i] }), now)
+	}
+	guessTan("alice", TeamOne)
+	guessTan("bob", TeamTwo)
+	guessTan("alice", TeamOne)
+	if game.TurnsLeft[TeamOne] != 0 || game.TurnsLeft[TeamTwo] != 1 || game.ActiveTeam != TeamTwo {
+		t.Fatalf("turns left = %v with team %d active, want team one out of turns and team two active", game.TurnsLeft, game.ActiveTeam)
+	}
+	if game.OutcomeReason != "" || !game.teamOutOfTurns(TeamOne) || game.teamOutOfTurns(TeamTwo) {
+		t.Fatalf("with team two's turn left, outcome = %q, want the game in progress", game.OutcomeReason)
+	}
+
+	// The shared timer tokens don't apply, so the game goes on
+	// until team two runs out of turns too.
+	guessTan("bob", TeamTwo)
+	if game.OutcomeReason != OutcomeNoTurns || game.FinishedByPlayer != "bob" {
+		t.Errorf("after both teams ran out of turns, outcome = %q by %q, want %q by bob", game.OutcomeReason, game.FinishedByPlayer, OutcomeNoTurns)
+	}
+	reconstructed := mustReconstruct(t, game.GameState)
+	if !reflect.DeepEqual(reconstructed.TurnsLeft, game.TurnsLeft) || reconstructed.status() != OutcomeNoTurns {
+		t.Errorf("reconstructed turns left = %v, status %q, want %v, %q", reconstructed.TurnsLeft, reconstructed.status(), game.TurnsLeft, OutcomeNoTurns)
+	}
+}
+
+func TestTimerTokens(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	if game := mustReconstruct(t, NewState(0, exampleWords)); game.TokensLeft != timerTokens {
+		t.Errorf("new game tokens left = %d, want %d", game.TokensLeft, timerTokens)
+	}
+	state := NewState(0, exampleWords)
+	state.TimerTokens = 3
+	state.ManualEndTurn = true
+	game := mustReconstruct(t, state)
+	if game.TokensLeft != 3 {
+		t.Fatalf("new game tokens left = %d, want 3", game.TokensLeft)
+	}
+
+	// A turn's first tan uses its token, and ending the turn
+	// doesn't use another.
+	tan := func(i int) bool { return game.ExposedTwo[i] }
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, tan), now)
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Tan, tan), now)
+	if game.TokensLeft != 2 {
+		t.Errorf("after two tans in a turn, tokens left = %d, want 2", game.TokensLeft)
+	}
+	game.addEvent(Event{Type: "end_turn", Team: TeamOne})
+	if game.TokensLeft != 2 {
+		t.Errorf("after ending the turn, tokens left = %d, want 2", game.TokensLeft)
+	}
+
+	// Otherwise, ending the turn uses its token.
+	game.addEvent(Event{Type: "end_turn", Team: TeamTwo})
+	if game.TokensLeft != 1 || game.status() != "" {
+		t.Errorf("tokens left = %d with status %q, want 1 and the game in progress", game.TokensLeft, game.status())
+	}
+	game.addEvent(Event{Type: "end_turn", Team: TeamOne})
+	if game.TokensLeft != 0 || game.status() != OutcomeNoTokens {
+		t.Errorf("tokens left = %d with status %q, want 0 and %q", game.TokensLeft, game.status(), OutcomeNoTokens)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); reconstructed.TokensLeft != 0 {
+		t.Errorf("reconstructed tokens left = %d, want 0", reconstructed.TokensLeft)
+	}
+
+	// Games with per-team turns have no timer tokens.
+	state = NewState(0, exampleWords)
+	state.TeamTurns = 2
+	if game := mustReconstruct(t, state); game.TokensLeft != 0 {
+		t.Errorf("game with per-team turns has %d tokens left, want 0", game.TokensLeft)
+	}
+}
+
+func TestMistakes(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	never := func(int) bool { return false }
+	game := mustReconstruct(t, NewState(0, exampleWords))
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, never), now)
+	if len(game.Tokened) != 0 {
+		t.Errorf("after a green, tokened = %+v, want none", game.Tokened)
+	}
+
+	// A tan ends the turn, using up its token, and is tokened.
+	tan := indexOf(game.TwoLayout, Tan, never)
+	game.guess("alice", "alice", TeamOne, tan, now)
+	want := []TokenedCell{{Index: tan, Team: TeamOne, Turn: 1}}
+	if !reflect.DeepEqual(game.Tokened, want) {
+		t.Errorf("after a tan, tokened = %+v, want %+v", game.Tokened, want)
+	}
+	if game.ActiveTeam != TeamTwo || game.Turn != 2 || game.TokensLeft != timerTokens-1 {
+		t.Errorf("after a tan, team %d active in turn %d with %d tokens left, want team two in turn 2 with %d",
+			game.ActiveTeam, game.Turn, game.TokensLeft, timerTokens-1)
+	}
+
+	tan = indexOf(game.OneLayout, Tan, never)
+	game.guess("bob", "bob", TeamTwo, tan, now)
+	want = append(want, TokenedCell{Index: tan, Team: TeamTwo, Turn: 2})
+	if !reflect.DeepEqual(game.Tokened, want) {
+		t.Errorf("after another tan, tokened = %+v, want %+v", game.Tokened, want)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); !reflect.DeepEqual(reconstructed.Tokened, want) {
+		t.Errorf("reconstructed tokened = %+v, want %+v", reconstructed.Tokened, want)
+	}
+}
+
+func TestSuddenDeath(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	state := NewState(0, exampleWords)
+	state.TimerTokens = 1
+	state.SuddenDeath = true
+	game := mustReconstruct(t, state)
+	game.addEvent(Event{Type: "end_turn", Team: TeamOne})
+	game.checkFinished("alice", TeamOne, now)
+	if game.OutcomeReason != "" || game.Phase != PhaseSuddenDeath {
+		t.Fatalf("out of tokens, outcome = %q in phase %q, want the game in phase %q", game.OutcomeReason, game.Phase, PhaseSuddenDeath)
+	}
+
+	// Either team may guess, and turns don't end.
+	unexposed := func(exposed []bool) func(int) bool {
+		return func(i int) bool { return exposed[i] }
+	}
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, unexposed(game.ExposedTwo)), now)
+	game.guess("bob", "bob", TeamTwo, indexOf(game.OneLayout, Green, unexposed(game.ExposedOne)), now)
+	game.guess("alice", "alice", TeamOne, indexOf(game.TwoLayout, Green, unexposed(game.ExposedTwo)), now)
+	if got := len(game.ExposedOneIndices) + len(game.ExposedTwoIndices); got != 3 {
+		t.Errorf("after three guesses in sudden death, %d cells exposed, want 3", got)
+	}
+	turn := game.Turn
+	game.addEvent(Event{Type: "end_turn", Team: game.ActiveTeam})
+	game.addEvent(Event{Type: "clue", Team: otherTeam(game.ActiveTeam), Word: "fruit", Count: 1})
+	if game.Turn != turn || game.Clue != nil || game.Phase != PhaseSuddenDeath {
+		t.Errorf("in sudden death, turn %d with clue %+v in phase %q, want turn %d without a clue", game.Turn, game.Clue, game.Phase, turn)
+	}
+
+	// Any mistake loses the game.
+	game.guess("bob", "bob", TeamTwo, indexOf(game.OneLayout, Tan, unexposed(game.ExposedOne)), now)
+	if game.OutcomeReason != OutcomeNoTokens || game.Status != StatusLostTime || game.Phase != "" {
+		t.Errorf("after a tan in sudden death, outcome = %q with status %q in phase %q, want %q with status %q",
+			game.OutcomeReason, game.Status, game.Phase, OutcomeNoTokens, StatusLostTime)
+	}
+	if reconstructed := mustReconstruct(t, game.GameState); reconstructed.status() != OutcomeNoTokens {
+		t.Errorf("reconstructed status = %q, want %q", reconstructed.status(), OutcomeNoTokens)
 	}
 }
@@ -0,0 +1,55 @@
+// Package gameapitest provides utilities for testing against the
+// codenames green API handler.
+package gameapitest
+
+import (
+	"math/rand"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbowens/codenamesgreen/gameapi"
+)
+
+// Clock is a fake clock whose time only changes when advanced.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock whose current time is now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock's current time forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// NewServer starts a server running the API handler with the
+// provided word lists and options. Game seeds are drawn from a
+// source seeded with seed, so the boards created are the same
+// from run to run, and the handler reads the time from the
+// returned Clock. The server is closed when the test completes.
+func NewServer(t testing.TB, wordLists map[string][]string, seed int64, opts ...gameapi.Option) (*httptest.Server, *Clock) {
+	clock := NewClock(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC))
+	opts = append([]gameapi.Option{
+		gameapi.WithRandSource(rand.NewSource(seed)),
+		gameapi.WithClock(clock.Now),
+	}, opts...)
+
+	srv := httptest.NewServer(gameapi.Handler(wordLists, opts...))
+	t.Cleanup(srv.Close)
+	return srv, clock
+}
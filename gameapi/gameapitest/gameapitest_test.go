@@ -0,0 +1,59 @@
+package gameapitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var words = func() []string {
+	var words []string
+	for i := 0; i < 100; i++ {
+		words = append(words, fmt.Sprintf("WORD%d", i))
+	}
+	return words
+}()
+
+type game struct {
+	CreatedAt time.Time `json:"created_at"`
+	Words     []string  `json:"words"`
+	OneLayout []string  `json:"one_layout"`
+	TwoLayout []string  `json:"two_layout"`
+}
+
+func newGame(t *testing.T, url, id string) game {
+	t.Helper()
+	b, _ := json.Marshal(map[string]string{"game_id": id})
+	resp, err := http.Post(url+"/new-game", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var g game
+	if err := json.NewDecoder(resp.Body).Decode(&g); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestDeterministicBoards(t *testing.T) {
+	lists := map[string][]string{"example": words}
+	srv1, clock := NewServer(t, lists, 42)
+	srv2, _ := NewServer(t, lists, 42)
+
+	clock.Advance(time.Hour)
+	g1, g2 := newGame(t, srv1.URL, "foo"), newGame(t, srv2.URL, "foo")
+	if !reflect.DeepEqual(g1.Words, g2.Words) ||
+		!reflect.DeepEqual(g1.OneLayout, g2.OneLayout) ||
+		!reflect.DeepEqual(g1.TwoLayout, g2.TwoLayout) {
+		t.Errorf("boards created with the same seed differ:\n%+v\n%+v", g1, g2)
+	}
+	if !g1.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("created_at = %s, want %s", g1.CreatedAt, clock.Now())
+	}
+}
@@ -0,0 +1,134 @@
+package gameapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// gameOverTimeout bounds each call of a game over function,
+	// including delivery of a webhook.
+	gameOverTimeout = 10 * time.Second
+	// maxGameOverCalls bounds the number of game over functions
+	// running at once. Notifications beyond it are dropped.
+	maxGameOverCalls = 16
+)
+
+// GameResult describes a game that has just ended, for the
+// functions registered with WithGameOverFunc and the requests sent
+// to webhooks registered with WithGameOverWebhook.
+//
+// Guesses counts the cells that were revealed. Contributions counts
+// each player's correct and incorrect guesses, keyed by player ID.
+type GameResult struct {
+	GameID           string                  `json:"game_id"`
+	Seed             Seed                    `json:"seed"`
+	OutcomeReason    string                  `json:"outcome_reason"`
+	Won              bool                    `json:"won"`
+	FinishedAt       time.Time               `json:"finished_at"`
+	FinishedByPlayer string                  `json:"finished_by_player,omitempty"`
+	FinishedByTeam   int                     `json:"finished_by_team,omitempty"`
+	Turns            int                     `json:"turns"`
+	Guesses          int                     `json:"guesses"`
+	GreensNeeded     int                     `json:"greens_needed"`
+	Contributions    map[string]Contribution `json:"contributions"`
+}
+
+// WithGameOverFunc registers fn to be called once each game ends,
+// with the ID of the game and its outcome. fn is called from its own
+// goroutine, outside the game's lock, so it may take its time and
+// make requests to the handler. Its context is cancelled after ten
+// seconds. If too many calls are already running, the notification
+// is dropped and logged instead.
+func WithGameOverFunc(fn func(ctx context.Context, result GameResult)) Option {
+	return func(h *handler) {
+		h.gameOver = append(h.gameOver, fn)
+	}
+}
+
+// WithGameOverWebhook registers url to receive a POST request with
+// a JSON-encoded GameResult once each game ends. Like the functions
+// registered with WithGameOverFunc, requests are sent without
+// blocking the game, and give up after ten seconds. They aren't
+// retried.
+func WithGameOverWebhook(url string) Option {
+	return WithGameOverFunc(func(ctx context.Context, result GameResult) {
+		if err := postGameResult(ctx, url, result); err != nil {
+			log.Printf("notifying %s of game %q: %s", url, result.GameID, err)
+		}
+	})
+}
+
+// postGameResult posts result to a webhook at url.
+func postGameResult(ctx context.Context, url string, result GameResult) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// result returns the GameResult of g, which is identified by id.
+// g.mu must be held.
+func (g *Game) result(id string) GameResult {
+	r := GameResult{
+		GameID:           id,
+		Seed:             g.Seed,
+		OutcomeReason:    g.OutcomeReason,
+		Won:              g.OutcomeReason == OutcomeAllGreen,
+		FinishedAt:       g.FinishedAt,
+		FinishedByPlayer: g.FinishedByPlayer,
+		FinishedByTeam:   g.FinishedByTeam,
+		Turns:            g.Turn,
+		Guesses:          len(g.ExposedOneIndices) + len(g.ExposedTwoIndices),
+		GreensNeeded:     g.GreensNeeded,
+		Contributions:    make(map[string]Contribution, len(g.Contributions)),
+	}
+	for id, c := range g.Contributions {
+		r.Contributions[id] = c
+	}
+	return r
+}
+
+// notifyGameOver calls the handler's game over functions if the
+// game identified by id has just ended. wasOver is whether it had
+// ended before the request being handled changed it, so that each
+// game is only reported once. g.mu must be held.
+func (h *handler) notifyGameOver(id string, g *Game, wasOver bool) {
+	if wasOver || g.OutcomeReason == "" || len(h.gameOver) == 0 {
+		return
+	}
+	result := g.result(id)
+	for _, fn := range h.gameOver {
+		select {
+		case h.gameOverCalls <- struct{}{}:
+		default:
+			log.Printf("dropping game over notification for game %q: too many in flight", id)
+			continue
+		}
+		go func(fn func(context.Context, GameResult)) {
+			defer func() { <-h.gameOverCalls }()
+			ctx, cancel := context.WithTimeout(context.Background(), gameOverTimeout)
+			defer cancel()
+			fn(ctx, result)
+		}(fn)
+	}
+}
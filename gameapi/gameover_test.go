@@ -0,0 +1,75 @@
+package gameapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGameOverFunc(t *testing.T) {
+	results := make(chan GameResult, 2)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithGameOverFunc(func(ctx context.Context, r GameResult) { results <- r }))
+	seed := newTestGame(t, h, "foo")
+	g := mustGet(t, h, "foo")
+	never := func(int) bool { return false }
+	black := indexOf(g.TwoLayout, Black, never)
+	tan := indexOf(g.TwoLayout, Tan, never)
+
+	guess := func(i int) *httptest.ResponseRecorder {
+		return post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": i})
+	}
+	if rw := guess(black); rw.Code != 200 {
+		t.Fatalf("POST /guess = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := guess(tan); rw.Code != 409 || errorCode(t, rw) != "game_over" {
+		t.Fatalf("POST /guess after the game ended = %d %s, want 409 game_over", rw.Code, rw.Body)
+	}
+
+	select {
+	case r := <-results:
+		if r.GameID != "foo" || r.OutcomeReason != OutcomeAssassin || r.Won || r.FinishedByPlayer != "alice" {
+			t.Errorf("result = %+v, want alice to have lost game foo to the assassin", r)
+		}
+		if c := r.Contributions["alice"]; c.Incorrect != 1 {
+			t.Errorf("alice's contribution = %+v, want 1 incorrect guess", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("game over function wasn't called")
+	}
+	// Attempted moves after the game ended don't report it again.
+	select {
+	case r := <-results:
+		t.Errorf("game over function called again with %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestGameOverWebhook(t *testing.T) {
+	results := make(chan GameResult, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var r GameResult
+		if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+			t.Errorf("decoding webhook request: %s", err)
+		}
+		results <- r
+	}))
+	defer srv.Close()
+
+	h := Handler(map[string][]string{"example": exampleWords}, WithGameOverWebhook(srv.URL))
+	seed := newTestGame(t, h, "foo")
+	black := indexOf(mustGet(t, h, "foo").TwoLayout, Black, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": black})
+
+	select {
+	case r := <-results:
+		if r.GameID != "foo" || r.OutcomeReason != OutcomeAssassin {
+			t.Errorf("webhook received %+v, want game foo lost to the assassin", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't called")
+	}
+}
@@ -1,465 +1,3573 @@
 package gameapi
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	crand "crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/jbowens/dictionary"
+	"unicode"
+	"unicode/utf8"
 )
 
+// An Option configures optional behavior of the handler
+// returned by Handler.
+type Option func(*handler)
+
+// WithWordlistMetadata attaches display metadata to the
+// handler's word lists, surfaced through /word-lists.
+func WithWordlistMetadata(meta map[string]WordlistMetadata) Option {
+	return func(h *handler) {
+		h.wordListMeta = meta
+	}
+}
+
+// WithLazyWordlists loads the handler's word lists from loader on
+// first use, instead of up front, keeping at most maxLoaded of them
+// in memory. The word lists passed to Handler are ignored.
+//
+// A new game that doesn't name its word lists is drawn from all of
+// them, which loads every list in turn. If maxLoaded is less than
+// the number of lists, each such game evicts and reloads them all,
+// so it's best suited to servers whose clients name their lists.
+func WithLazyWordlists(loader WordlistLoader, maxLoaded int) Option {
+	return func(h *handler) {
+		h.wordLists = newLazyWordlists(loader, maxLoaded)
+	}
+}
+
+// WithRandSource configures the source of randomness used to
+// pick game seeds and game IDs and to jitter poll intervals. By
+// default, it's seeded from the current time.
+func WithRandSource(src rand.Source) Option {
+	return func(h *handler) {
+		h.rand = rand.New(&lockedSource{src: src})
+	}
+}
+
+// lockedSource is a rand.Source that's safe for concurrent use, so
+// that the handler's source may be drawn from without holding h.mu.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// WithCryptoSeeds configures the handler to pick game seeds from
+// crypto/rand, so that boards can't be predicted from the source
+// configured by WithRandSource. Boards are still derived from the
+// seed in the same way.
+func WithCryptoSeeds() Option {
+	return func(h *handler) {
+		h.cryptoSeeds = true
+	}
+}
+
+// WithClock configures the function used to get the current
+// time. By default, it's time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(h *handler) {
+		h.now = now
+	}
+}
+
+// WithPruneTicks configures the channel that triggers removal of
+// old and inactive games. By default, games are pruned every ten
+// minutes.
+func WithPruneTicks(ticks <-chan time.Time) Option {
+	return func(h *handler) {
+		h.pruneTicks = ticks
+	}
+}
+
+// WithPruneObserver configures a function to be called with the
+// PruneStats of each sweep of old and inactive games, such as for
+// recording metrics. It's called after the sweep, outside of the
+// handler's locks.
+func WithPruneObserver(fn func(PruneStats)) Option {
+	return func(h *handler) {
+		h.pruneObserver = fn
+	}
+}
+
+// WithVerboseLog configures a logger for diagnostic output, such
+// as a line describing each sweep of old and inactive games. By
+// default, diagnostic output is discarded.
+func WithVerboseLog(l *log.Logger) Option {
+	return func(h *handler) {
+		h.verboseLog = l
+	}
+}
+
+// WithMaxPlayersPerTeam limits the number of players that may
+// join each team of a game. Requests from a player that would
+// exceed the limit fail with a team_full error. By default,
+// there's no limit.
+func WithMaxPlayersPerTeam(n int) Option {
+	return func(h *handler) {
+		h.maxPlayersPerTeam = n
+	}
+}
+
+// WithGuessCooldown configures the minimum interval between a
+// player's guesses. A guess that follows the player's previous
+// guess too closely, such as an accidental double-tap, fails with
+// a too_fast error. By default, there's no cooldown.
+func WithGuessCooldown(d time.Duration) Option {
+	return func(h *handler) {
+		h.guessCooldown = d
+	}
+}
+
+// WithMinGameIDLength requires the IDs of new games to include at
+// least n ASCII letters and digits, so that strangers can't guess
+// them. Requests to create a game with a weaker ID fail with a
+// weak_game_id error. Clients can get a strong ID from /game-id.
+// By default, any ID is allowed.
+func WithMinGameIDLength(n int) Option {
+	return func(h *handler) {
+		h.minGameIDLength = n
+	}
+}
+
+// WithResetGracePeriod delays resets of games in progress by d,
+// giving the other players a chance to cancel them through
+// /cancel-reset. A reset_pending event is added to the game when a
+// reset is requested, and the reset takes effect once d has passed.
+// By default, resets take effect immediately.
+func WithResetGracePeriod(d time.Duration) Option {
+	return func(h *handler) {
+		h.resetGracePeriod = d
+	}
+}
+
+// WithGameRotation lets a game that's over, or that nobody has
+// played for idle, be replaced by a request for a new game under its
+// ID without the existing game's seed, so that a game ID that's
+// reused over days gets a fresh board each time. Games with players
+// in them are never rotated; they can only be reset with prev_seed.
+// By default, games are never rotated.
+func WithGameRotation(idle time.Duration) Option {
+	return func(h *handler) {
+		h.rotateAfter = idle
+	}
+}
+
+// WithPlayerIDGenerator configures the function that generates the
+// player IDs served by /new-player-id. By default, it's NewPlayerID.
+func WithPlayerIDGenerator(gen func() (string, error)) Option {
+	return func(h *handler) {
+		h.newPlayerID = gen
+	}
+}
+
+// WithGameTTLs configures how long games without players are
+// kept: games in progress that no player has joined for inProgress
+// after they're created, and finished games for finished after they
+// end. By default, they're kept for 24 hours and one hour
+// respectively. See WithEmptyGameTTL for games in progress whose
+// players have all left.
+func WithGameTTLs(inProgress, finished time.Duration) Option {
+	return func(h *handler) {
+		h.gameTTL = inProgress
+		h.finishedGameTTL = finished
+	}
+}
+
+// WithEmptyGameTTL configures how long games in progress whose
+// players have all left are kept after the last one leaves, so
+// that they may rejoin. By default, they're kept for an hour.
+func WithEmptyGameTTL(ttl time.Duration) Option {
+	return func(h *handler) {
+		h.emptyGameTTL = ttl
+	}
+}
+
+// WithPollIntervals configures the intervals that clients polling
+// /events are advised to wait between polls: active for games in
+// progress with players on both teams, and idle for all others. By
+// default, they're one and ten seconds respectively.
+func WithPollIntervals(active, idle time.Duration) Option {
+	return func(h *handler) {
+		h.activePollInterval = active
+		h.idlePollInterval = idle
+	}
+}
+
+// WithMaxWordLength configures the maximum length, in runes, of
+// the words that clients may supply for a new game. By default,
+// it's 64.
+func WithMaxWordLength(n int) Option {
+	return func(h *handler) {
+		h.maxWordLength = n
+	}
+}
+
+// Version is the server's version, as reported by /version unless
+// WithVersion overrides it. Builds may set it with
+//
+//	-ldflags "-X github.com/jbowens/codenamesgreen/gameapi.Version=v1.2.3"
+var Version = "dev"
+
+// WithVersion sets the version reported by /version, in place of
+// Version.
+func WithVersion(v string) Option {
+	return func(h *handler) {
+		h.version = v
+	}
+}
+
+// WithAdminToken enables the /admin/ endpoints for requests that
+// carry token as a bearer token in their Authorization header.
+// Without it, the admin endpoints don't exist.
+func WithAdminToken(token string) Option {
+	return func(h *handler) {
+		h.adminToken = token
+	}
+}
+
 // Handler implements the codenames green server handler.
-func Handler(wordLists map[string][]string) http.Handler {
+//
+// Each word in wordLists may be followed by a tab and a difficulty
+// rating, which is reported to clients alongside the board's words.
+func Handler(wordLists map[string][]string, opts ...Option) http.Handler {
 	h := &handler{
-		mux:       http.NewServeMux(),
-		wordLists: wordLists,
-		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
-		games:     make(map[string]*Game),
+		mux:   http.NewServeMux(),
+		rand:  rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano())}),
+		now:   time.Now,
+		games: NewMemoryStore(),
+
+		gameTTL:            gameTTL,
+		finishedGameTTL:    finishedGameTTL,
+		emptyGameTTL:       emptyGameTTL,
+		activePollInterval: time.Second,
+		idlePollInterval:   10 * time.Second,
+		maxWordLength:      64,
+		gameOverCalls:      make(chan struct{}, maxGameOverCalls),
+		newPlayerID:        NewPlayerID,
+		version:            Version,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.started = h.now()
+	if h.wordLists == nil {
+		lists, ratings := splitDifficulties(wordLists)
+		h.wordLists = staticWordlists{lists: lists, ratings: ratings}
 
-	// Build a list of all words. The combined list
-	// of words is our default word list for new games,
-	// and the set of words we draw from for game IDs.
-	m := map[string]bool{}
-	for _, list := range wordLists {
-		for _, w := range list {
-			if !m[w] {
-				h.allWords = append(h.allWords, w)
-				m[w] = true
+		// Build a list of all words, which we draw
+		// from for game IDs. Lazily loaded lists draw
+		// from a random list instead.
+		m := map[string]bool{}
+		for _, list := range lists {
+			for _, w := range list {
+				if !m[w] {
+					h.allWords = append(h.allWords, w)
+					m[w] = true
+				}
 			}
 		}
+		sort.Strings(h.allWords)
 	}
-	sort.Strings(h.allWords)
 
+	h.mux.HandleFunc("/", handleNotFound)
 	h.mux.HandleFunc("/index", h.handleIndex)
+	h.mux.HandleFunc("/game-id", handleGameID)
+	h.mux.HandleFunc("/new-player-id", h.handleNewPlayerID)
 	h.mux.HandleFunc("/new-game", h.handleNewGame)
+	h.mux.HandleFunc("/cancel-reset", h.handleCancelReset)
+	h.mux.HandleFunc("/validate-words", h.handleValidateWords)
 	h.mux.HandleFunc("/guess", h.handleGuess)
+	h.mux.HandleFunc("/propose-guess", h.handleGuessProposal)
+	h.mux.HandleFunc("/confirm-guess", h.handleGuessProposal)
+	h.mux.HandleFunc("/cancel-guess", h.handleGuessProposal)
 	h.mux.HandleFunc("/end-turn", h.handleEndTurn)
+	h.mux.HandleFunc("/clue", h.handleClue)
 	h.mux.HandleFunc("/chat", h.handleChat)
 	h.mux.HandleFunc("/events", h.handleEvents)
 	h.mux.HandleFunc("/ping", h.handlePing)
+	h.mux.HandleFunc("/switch-team", h.handleSwitchTeam)
 	h.mux.HandleFunc("/stats", h.handleStats)
+	h.mux.HandleFunc("/version", h.handleVersion)
+	h.mux.HandleFunc("/readyz", h.handleReadyz)
+	h.mux.HandleFunc("/word-lists", h.handleWordLists)
+	h.mux.HandleFunc("/word-lists/", h.handleWordList)
+	h.mux.HandleFunc("/schema", handleSchema)
+	h.mux.HandleFunc("/board", h.handleBoard)
+	h.mux.HandleFunc("/reshuffle-layout", h.handleReshuffleLayout)
+	h.mux.HandleFunc("/summary", h.handleSummary)
+	h.mux.HandleFunc("/final-board", h.handleFinalBoard)
+	h.mux.HandleFunc("/cell", h.handleCell)
+	h.mux.HandleFunc("/find-word", h.handleFindWord)
+	h.mux.HandleFunc("/verify-board", h.handleVerifyBoard)
+	h.mux.HandleFunc("/game-states", h.handleGameStates)
+	h.mux.HandleFunc("/watch", h.handleWatch)
+	h.mux.HandleFunc("/admin/export", h.admin(h.handleExport))
+	h.mux.HandleFunc("/admin/game-log", h.admin(h.handleGameLog))
+	h.mux.HandleFunc("/admin/import", h.admin(h.handleImport))
+	h.mux.HandleFunc("/admin/rename", h.admin(h.handleRename))
+	h.mux.HandleFunc("/admin/rewind", h.admin(h.handleRewind))
+	h.mux.HandleFunc("/admin/freeze", h.admin(h.handleFreeze))
+	h.mux.HandleFunc("/admin/unfreeze", h.admin(h.handleFreeze))
+	h.mux.HandleFunc("/admin/maintenance", h.admin(h.handleMaintenance))
 
 	// Periodically remove games that are old and inactive.
+	if h.pruneTicks == nil {
+		h.pruneTicks = time.Tick(pruneInterval)
+	}
 	go func() {
-		for now := range time.Tick(10 * time.Minute) {
-			h.mu.Lock()
-			for id, g := range h.games {
-				remaining := g.pruneOldPlayers(now)
-				if remaining > 0 {
-					continue // at least one player is still in the game
-				}
-				if g.CreatedAt.Add(24 * time.Hour).After(time.Now()) {
-					continue // hasn't been 24 hours since the game started
-				}
-				delete(h.games, id)
-			}
-			h.mu.Unlock()
+		for range h.pruneTicks {
+			h.prune(h.now())
 		}
 	}()
 
 	return h
 }
 
+const (
+	// pruneInterval is how often old and inactive games are removed.
+	pruneInterval = 10 * time.Minute
+	// gameTTL is the minimum time a game in progress is kept
+	// after it's created.
+	gameTTL = 24 * time.Hour
+	// finishedGameTTL is the minimum time a finished game is
+	// kept after it ends.
+	finishedGameTTL = time.Hour
+	// emptyGameTTL is the minimum time a game in progress is
+	// kept after its last player leaves.
+	emptyGameTTL = time.Hour
+)
+
+// PruneStats describes a sweep of old and inactive games. Duration
+// is how long the whole sweep took, and LockHeld is how much of it
+// was spent holding the lock that serializes the creation and
+// replacement of games.
+type PruneStats struct {
+	PlayersPruned int
+	GamesDeleted  int
+	Duration      time.Duration
+	LockHeld      time.Duration
+}
+
+// prune removes players that haven't been seen recently, and then
+// removes games that have no players and have outlived their TTL.
+// Finished games are kept for h.finishedGameTTL after they end, and
+// games in progress for h.emptyGameTTL after their last player left,
+// or for h.gameTTL after they're created if no player has joined.
+func (h *handler) prune(now time.Time) {
+	start := time.Now()
+	h.applyDueResets()
+
+	var stats PruneStats
+	locked := time.Now()
+	h.mu.Lock()
+	stats.GamesDeleted = h.games.Prune(func(id string, g *Game) bool {
+		remaining, pruned := g.pruneOldPlayers(now)
+		stats.PlayersPruned += pruned
+		if remaining > 0 {
+			return false // at least one player is still in the game
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		expires := g.CreatedAt.Add(h.gameTTL)
+		if g.OutcomeReason != "" {
+			expires = g.FinishedAt.Add(h.finishedGameTTL)
+		} else if !g.LastEmptyAt.IsZero() {
+			expires = g.LastEmptyAt.Add(h.emptyGameTTL)
+		}
+		if expires.After(now) && expires.Before(now.Add(pruneInterval)) {
+			g.debug.addf(now, debugPruneKept, "", "", "empty, expires at %s", expires.Format(time.RFC3339))
+		}
+		return !expires.After(now)
+	})
+	h.mu.Unlock()
+	stats.LockHeld = time.Since(locked)
+	stats.Duration = time.Since(start)
+
+	if h.verboseLog != nil {
+		h.verboseLog.Printf("pruned %d players and %d games in %s, holding the lock for %s",
+			stats.PlayersPruned, stats.GamesDeleted, stats.Duration, stats.LockHeld)
+	}
+	if h.pruneObserver != nil {
+		h.pruneObserver(stats)
+	}
+}
+
 type handler struct {
-	mux       *http.ServeMux
-	wordLists map[string][]string
-	allWords  []string
-	rand      *rand.Rand
+	mux          *http.ServeMux
+	wordLists    wordlistStore
+	wordListMeta map[string]WordlistMetadata
+	allWords     []string
+	rand         *rand.Rand
+	now          func() time.Time
+	pruneTicks   <-chan time.Time
+
+	pruneObserver func(PruneStats)
+	verboseLog    *log.Logger
+
+	gameTTL            time.Duration
+	finishedGameTTL    time.Duration
+	emptyGameTTL       time.Duration
+	activePollInterval time.Duration
+	idlePollInterval   time.Duration
+
+	cryptoSeeds       bool
+	maxPlayersPerTeam int
+	maxWordLength     int
+	guessCooldown     time.Duration
+	minGameIDLength   int
+	resetGracePeriod  time.Duration
+	rotateAfter       time.Duration
+	newPlayerID       func() (string, error)
+	version           string
+	started           time.Time
+
+	// adminToken is the bearer token that requests to the admin
+	// endpoints must carry. They're disabled if it's empty.
+	adminToken string
+
+	// gameOver holds the functions called when a game ends, and
+	// gameOverCalls bounds how many of them run at once.
+	gameOver      []func(context.Context, GameResult)
+	gameOverCalls chan struct{}
 
+	// maintenance is non-zero while the server is in maintenance
+	// mode. It's accessed atomically. See handleMaintenance.
+	maintenance int32
+
+	// mu serializes the creation and replacement of games.
 	mu    sync.Mutex
-	games map[string]*Game
+	games Store
+
+	// previews holds the expiry of each board previewed by a
+	// recent dry run. See issuePreview. h.mu must be held.
+	previews map[previewKey]time.Time
 }
 
 func (h *handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	// Allow all cross-origin requests.
+	// Allow all cross-origin requests, except to the admin
+	// endpoints, which web pages have no business calling.
 	header := rw.Header()
-	header.Set("Access-Control-Allow-Origin", "*")
-	header.Set("Access-Control-Allow-Methods", "*")
-	header.Set("Access-Control-Allow-Headers", "Content-Type")
-	header.Set("Access-Control-Max-Age", "1728000") // 20 days
+	if !strings.HasPrefix(req.URL.Path, "/admin/") {
+		header.Set("Access-Control-Allow-Origin", "*")
+		header.Set("Access-Control-Allow-Methods", "*")
+		header.Set("Access-Control-Allow-Headers", "Content-Type, Idempotency-Key, X-Game-Password, X-Request-ID")
+		header.Set("Access-Control-Max-Age", "1728000") // 20 days
+		header.Set("Access-Control-Expose-Headers", "Retry-After, X-Request-ID")
+	}
+
+	// Echo the client's request ID, or make one up, so that a
+	// client's report can be matched with the server's logs.
+	// Error responses include it too.
+	header.Set("X-Request-ID", requestID(req))
 
 	if req.Method == "OPTIONS" {
+		// Only preflight requests for real endpoints succeed,
+		// so that a typo in a URL fails early. Every other
+		// path falls through to the catch-all pattern.
+		if _, pattern := h.mux.Handler(req); pattern == "/" {
+			handleNotFound(rw, req)
+			return
+		}
 		rw.WriteHeader(http.StatusOK)
 		return
 	}
 	h.mux.ServeHTTP(rw, req)
 }
 
+// admin wraps the handler of an admin endpoint, so that it only
+// serves requests that carry the handler's admin token. Without a
+// token, the admin endpoints don't exist.
+func (h *handler) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		if h.adminToken == "" {
+			handleNotFound(rw, req)
+			return
+		}
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) != 1 {
+			writeError(rw, "bad_admin_token", "This endpoint requires an admin token.", 401)
+			return
+		}
+		next(rw, req)
+	}
+}
+
 // POST /index
 func (h *handler) handleIndex(rw http.ResponseWriter, req *http.Request) {
 	// Autogenerate a game ID from the set of words that we know about, skipping
 	// any that already have games in-memory.
 	id := ""
-	h.mu.Lock()
 	for {
-		w1 := strings.ToLower(h.allWords[h.rand.Int63n(int64(len(h.allWords)))])
-		w2 := strings.ToLower(h.allWords[h.rand.Int63n(int64(len(h.allWords)))])
+		w1 := strings.ToLower(h.randomWord())
+		w2 := strings.ToLower(h.randomWord())
 		id := fmt.Sprintf("%s-%s", w1, w2)
-		if _, ok := h.games[id]; !ok {
+		h.mu.Lock()
+		_, ok := h.games.Get(id)
+		h.mu.Unlock()
+		if !ok {
 			break
 		}
 	}
-	h.mu.Unlock()
-
+
+	writeJSON(rw, struct {
+		AutogeneratedID string `json:"autogenerated_id"`
+	}{id})
+}
+
+// randomWord picks a random word for a game ID. h.mu must not be
+// held, since picking from a lazily loaded list may read it from
+// disk.
+func (h *handler) randomWord() string {
+	if len(h.allWords) > 0 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.allWords[h.rand.Int63n(int64(len(h.allWords)))]
+	}
+	if names := h.wordLists.names(); len(names) > 0 {
+		h.mu.Lock()
+		name := names[h.rand.Intn(len(names))]
+		h.mu.Unlock()
+		words, _, _ := h.wordLists.list(name)
+		if len(words) > 0 {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return words[h.rand.Intn(len(words))]
+		}
+	}
+	return "green"
+}
+
+// gameIDAlphabet is the alphabet of generated game IDs. It leaves
+// out characters that are easily confused, such as 0 and o.
+const gameIDAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+
+// gameIDLength is the length of generated game IDs. With 32
+// symbols, they have 80 bits of entropy.
+const gameIDLength = 16
+
+// NewGameID returns a random game ID that's infeasible to guess.
+func NewGameID() (string, error) {
+	return randomID(gameIDLength)
+}
+
+// NewPlayerID returns a random player ID, drawn from the same
+// alphabet as generated game IDs, that's infeasible to collide
+// with another.
+func NewPlayerID() (string, error) {
+	return randomID(gameIDLength)
+}
+
+// randomID returns n random characters of gameIDAlphabet.
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = gameIDAlphabet[int(b[i])%len(gameIDAlphabet)]
+	}
+	return string(b), nil
+}
+
+// validPlayerID returns true iff id may identify a player.
+func validPlayerID(id string) bool {
+	return id != ""
+}
+
+// countAlphanumeric returns the number of ASCII letters and
+// digits in id.
+func countAlphanumeric(id string) int {
+	n := 0
+	for _, r := range id {
+		if ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+			n++
+		}
+	}
+	return n
+}
+
+// GET /game-id
+// This endpoint returns a random game ID that's infeasible to guess,
+// for clients of servers that require strong game IDs.
+func handleGameID(rw http.ResponseWriter, req *http.Request) {
+	id, err := NewGameID()
+	if err != nil {
+		writeError(rw, "internal_error", "Unable to generate a game ID.", 500)
+		return
+	}
+	writeJSON(rw, struct {
+		GameID string `json:"game_id"`
+	}{id})
+}
+
+// GET /new-player-id
+// This endpoint returns a player ID for a client to adopt, along
+// with a suggested display name.
+func (h *handler) handleNewPlayerID(rw http.ResponseWriter, req *http.Request) {
+	id, err := h.newPlayerID()
+	if err != nil || !validPlayerID(id) {
+		writeError(rw, "internal_error", "Unable to generate a player ID.", 500)
+		return
+	}
+	name := sanitizeName(strings.ToLower(h.randomWord()))
+
+	writeJSON(rw, struct {
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+	}{id, name})
+}
+
+// newGameRequest is the body of a request to /new-game.
+type newGameRequest struct {
+	GameID       string   `json:"game_id"`
+	Words        []string `json:"words,omitempty"`
+	WordLists    []string `json:"word_lists,omitempty"`
+	StartingTeam int      `json:"starting_team,omitempty"`
+	PrevSeed     *string  `json:"prev_seed,omitempty"` // a string because of js number precision
+	Reset        *bool    `json:"reset,omitempty"`
+
+	// AvoidWords are words to leave off the board if possible,
+	// such as those used in recent games. If AvoidPrevious is
+	// set, the words of the game being replaced are avoided too.
+	AvoidWords    []string `json:"avoid_words,omitempty"`
+	AvoidPrevious bool     `json:"avoid_previous,omitempty"`
+
+	// MinLen and MaxLen, if non-zero, limit the length in
+	// runes of the words that may be drawn.
+	MinLen int `json:"min_len,omitempty"`
+	MaxLen int `json:"max_len,omitempty"`
+
+	// HintMode tells the players how many greens are left
+	// to find at the start of each turn.
+	HintMode bool `json:"hint_mode,omitempty"`
+
+	// ConfirmGuesses requires guesses to be proposed and
+	// confirmed through /propose-guess and /confirm-guess
+	// instead of made through /guess.
+	ConfirmGuesses bool `json:"confirm_guesses,omitempty"`
+
+	// Fixed pins words to cells of the board, with the given
+	// colors. The rest of the board is drawn as usual.
+	Fixed []FixedCell `json:"fixed,omitempty"`
+
+	// Blacks, if non-zero, is the number of blacks in each
+	// layout, in place of the usual three.
+	Blacks int `json:"blacks,omitempty"`
+
+	// AutoEndTurn, if false, leaves the turn with the guessing
+	// team when it reveals a tan, until it calls /end-turn. It
+	// defaults to true.
+	AutoEndTurn *bool `json:"auto_end_turn,omitempty"`
+
+	// MirrorDoubleGreens reveals cells that are green in both
+	// layouts in both of them at once.
+	MirrorDoubleGreens bool `json:"mirror_double_greens,omitempty"`
+
+	// TeamTurns, if non-zero, gives each team this many turns,
+	// in place of the timer tokens that the teams share.
+	TeamTurns int `json:"team_turns,omitempty"`
+
+	// TimerTokens, if non-zero, is the number of timer tokens
+	// that the teams share, in place of the usual nine.
+	TimerTokens int `json:"timer_tokens,omitempty"`
+
+	// SuddenDeath lets the teams play on once the timer tokens
+	// run out, as in the board game, instead of losing.
+	SuddenDeath bool `json:"sudden_death,omitempty"`
+
+	// Prereveal lists cells of each layout to reveal before the
+	// game starts, as a handicap. Unless PrerevealAny is set,
+	// they must be green, and mustn't reveal every green.
+	Prereveal    *Prereveal `json:"prereveal,omitempty"`
+	PrerevealAny bool       `json:"prereveal_any,omitempty"`
+
+	// Password, if set, is required of requests to play the
+	// game, in the X-Game-Password header. Spectators lets
+	// requests without it view the game. A reset keeps the old
+	// game's password unless it sets a new one.
+	Password   string `json:"password,omitempty"`
+	Spectators bool   `json:"spectators,omitempty"`
+
+	// Players assigns players to teams in advance, keyed by
+	// player ID. They're pruned like any other player if they
+	// don't check in.
+	Players map[string]int `json:"players,omitempty"`
+
+	// Balanced draws the board evenly from each of the word
+	// lists, instead of from their merged words. It can't be
+	// combined with Words.
+	Balanced bool `json:"balanced,omitempty"`
+
+	// DryRun returns the game that the request would create,
+	// without storing it or replacing an existing game.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// BoardSeed, if set, is the seed of the game returned by a
+	// recent dry run for the same game ID, to create the board
+	// that it previewed, given the same request otherwise. Only
+	// seeds issued by dry runs are accepted, and each only once.
+	// Like PrevSeed, it's a string.
+	BoardSeed *string `json:"board_seed,omitempty"`
+}
+
+// POST /new-game
+// This endpoint serves three intents:
+//
+//   - Create: if no game with the ID exists, a new one is created.
+//   - Join: if reset is false, or prev_seed is absent or doesn't
+//     match, the existing game is returned untouched.
+//   - Reset: if prev_seed matches the existing game's seed, the
+//     game is replaced with a new one.
+//
+// Clients can learn the current seed from the game returned when
+// joining, or from /events. With dry_run, the game that would be
+// created is returned without being stored, and any existing game
+// is left as it is. To create the previewed board, repeat the
+// request without dry_run, with board_seed set to its state's seed,
+// within ten minutes.
+//
+// If the handler has a reset grace period, resetting a game in
+// progress doesn't take effect right away. Instead, the existing
+// game is returned with a status of 202, its reset_at set, and a
+// reset_pending event added. It's replaced once the grace period
+// has passed, unless the reset is cancelled through /cancel-reset
+// first. A reset requested while another is pending fails with
+// reset_pending.
+func (h *handler) handleNewGame(rw http.ResponseWriter, req *http.Request) {
+	var body newGameRequest
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if body.StartingTeam != NoTeam && !validTeam(body.StartingTeam) {
+		writeFieldError(rw, "bad_team", "Starting team must be 1 or 2.",
+			map[string]string{"starting_team": "must be 1 or 2"}, 422)
+		return
+	}
+	for i, w := range body.Words {
+		body.Words[i] = normalizeWord(w)
+	}
+	if check := h.checkWords(body.Words); len(check.Rejected) > 0 {
+		r := check.Rejected[0]
+		writeFieldError(rw, "bad_word", fmt.Sprintf("Word %d %s.", r.Index, r.Reason),
+			map[string]string{fmt.Sprintf("words[%d]", r.Index): r.Reason}, 422)
+		return
+	}
+	if body.Balanced && len(body.Words) > 0 {
+		// Balancing draws evenly from each word list, and the
+		// client's own words don't come from any.
+		writeFieldError(rw, "bad_balanced", "A board can only be balanced across word lists, not the request's words.",
+			map[string]string{"balanced": "can't be combined with words"}, 422)
+		return
+	}
+	dist := colorDistribution
+	if body.Blacks != 0 {
+		var err error
+		if dist, err = blackDistribution(body.Blacks); err != nil {
+			writeFieldError(rw, "bad_blacks", fmt.Sprintf("The number of %s.", err),
+				map[string]string{"blacks": fmt.Sprintf("must be between %d and %d", minBlacks, maxBlacks)}, 422)
+			return
+		}
+	}
+	if i, problem := checkFixed(body.Fixed, dist); problem != "" {
+		writeFieldError(rw, "bad_fixed", fmt.Sprintf("Fixed cell %d %s.", i, problem),
+			map[string]string{fmt.Sprintf("fixed[%d]", i): problem}, 422)
+		return
+	}
+	var fixedWords []string
+	for i, f := range body.Fixed {
+		if problem := h.checkWord(f.Word); problem != "" {
+			writeFieldError(rw, "bad_word", fmt.Sprintf("Fixed word %d %s.", i, problem),
+				map[string]string{fmt.Sprintf("fixed[%d].word", i): problem}, 422)
+			return
+		}
+		fixedWords = append(fixedWords, f.Word)
+	}
+	if code, field, problem := h.checkRoster(body.Players); code != "" {
+		writeFieldError(rw, code, fmt.Sprintf("The roster's %s %s.", field, problem),
+			map[string]string{field: problem}, 422)
+		return
+	}
+	if body.TeamTurns < 0 {
+		writeFieldError(rw, "bad_team_turns", "The number of turns must be positive.",
+			map[string]string{"team_turns": "must be positive"}, 422)
+		return
+	}
+	if body.TimerTokens < 0 {
+		writeFieldError(rw, "bad_timer_tokens", "The number of timer tokens must be positive.",
+			map[string]string{"timer_tokens": "must be positive"}, 422)
+		return
+	}
+	if body.Prereveal != nil {
+		if field := body.Prereveal.check(); field != "" {
+			writeFieldError(rw, "bad_prereveal", fmt.Sprintf("Cell %s is out of range.", field),
+				map[string]string{field: "is out of range"}, 422)
+			return
+		}
+	}
+	if field, problem := checkWordLengths(body.MinLen, body.MaxLen); field != "" {
+		writeFieldError(rw, "bad_length", "Word lengths must be positive, with min_len at most max_len.",
+			map[string]string{field: problem}, 422)
+		return
+	}
+	var prevSeed *Seed
+	if body.PrevSeed != nil {
+		i, err := strconv.ParseInt(*body.PrevSeed, 10, 64)
+		if err != nil {
+			writeFieldError(rw, "bad_prev_seed", "prev_seed must be an integer.",
+				map[string]string{"prev_seed": "must be an integer"}, 400)
+			return
+		}
+		prevSeed = new(Seed)
+		*prevSeed = Seed(i)
+	}
+	var boardSeed *int64
+	if body.BoardSeed != nil {
+		i, err := strconv.ParseInt(*body.BoardSeed, 10, 64)
+		if err != nil {
+			writeFieldError(rw, "bad_board_seed", "board_seed must be an integer.",
+				map[string]string{"board_seed": "must be an integer"}, 400)
+			return
+		}
+		boardSeed = &i
+	}
+	if boardSeed != nil && body.DryRun {
+		writeFieldError(rw, "bad_board_seed", "A dry run can't confirm another dry run's board.",
+			map[string]string{"board_seed": "can't be combined with dry_run"}, 422)
+		return
+	}
+
+	h.applyDueReset(body.GameID)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// If the game already exists, make sure that the request includes
+	// the existing game's seed so a delayed request doesn't reset an
+	// existing game.
+	oldGame, ok := h.games.Get(body.GameID)
+	if ok {
+		oldGame.mu.Lock()
+		defer oldGame.mu.Unlock()
+	}
+	joining := body.Reset != nil && !*body.Reset
+	rotating := ok && !joining && h.rotatable(oldGame)
+	resetting := ok && !joining && prevSeed != nil && *prevSeed == oldGame.Seed
+	if ok && !body.DryRun && !authorize(rw, req, oldGame, resetting || rotating) {
+		return
+	}
+	if ok && !body.DryRun && !rotating && !resetting {
+		writeJSON(rw, oldGame)
+		return
+	}
+	if !body.DryRun && atomic.LoadInt32(&h.maintenance) != 0 {
+		writeError(rw, "maintenance", "The server isn't starting new games right now.", 503)
+		return
+	}
+	if !ok && countAlphanumeric(body.GameID) < h.minGameIDLength {
+		writeFieldError(rw, "weak_game_id",
+			fmt.Sprintf("Game IDs must include at least %d letters and digits.", h.minGameIDLength),
+			map[string]string{"game_id": "too weak"}, 422)
+		return
+	}
+	if ok && !body.DryRun && oldGame.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if ok && !body.DryRun && oldGame.pendingReset != nil {
+		writeError(rw, "reset_pending", "A reset of the game is already pending.", 409)
+		return
+	}
+	if boardSeed != nil && (!h.previewed(body.GameID, *boardSeed) || (ok && Seed(*boardSeed) == oldGame.Seed)) {
+		writeFieldError(rw, "bad_board_seed", "board_seed must be the seed of a recent dry run for the game.",
+			map[string]string{"board_seed": "wasn't issued by a recent dry run"}, 422)
+		return
+	}
+
+	// Use the words provided by the client if any, otherwise
+	// merge the requested word lists, defaulting to all of them.
+	words, sourceLists, mergedLists := body.Words, []string{}, []string{}
+	var ratings map[string]int
+	if len(words) == 0 {
+		sourceLists = body.WordLists
+		if len(sourceLists) == 0 {
+			sourceLists = h.wordLists.names()
+		}
+		sort.Strings(sourceLists)
+
+		var err error
+		words, ratings, err = h.mergeWordLists(sourceLists)
+		if err != nil {
+			writeError(rw, "unknown_word_list", fmt.Sprintf("Invalid word lists: %s.", err), 400)
+			return
+		}
+		mergedLists = sourceLists
+	}
+	filteredWords := false
+	if body.MinLen > 0 || body.MaxLen > 0 {
+		words, filteredWords = filterWordLengths(words, body.MinLen, body.MaxLen), true
+	}
+	// Only distinct words count, and pinned words can't be drawn
+	// again for the rest of the board.
+	needed := len(colorDistribution) - len(body.Fixed)
+	available := len(avoidWords(words, fixedWords))
+	if available < needed {
+		field := "words"
+		if len(body.Words) == 0 {
+			field = "word_lists"
+		}
+		writeFieldError(rw, "too_few_words",
+			fmt.Sprintf("A word list must have at least %d distinct words, but only %d are available.", needed, available),
+			map[string]string{field: "too few words"}, 422)
+		return
+	}
+
+	avoid := body.AvoidWords
+	if body.AvoidPrevious && oldGame != nil {
+		avoid = append(avoid, oldGame.Words...)
+	}
+	if len(avoid) > 0 {
+		// Fall back to the full word list if avoiding the words
+		// would leave too few to draw a board from.
+		if filtered := avoidWords(words, avoid); len(avoidWords(filtered, fixedWords)) >= needed {
+			words, filteredWords = filtered, filteredWords || len(filtered) < len(words)
+		}
+	}
+
+	var seed int64
+	var err error
+	if boardSeed != nil {
+		seed = *boardSeed
+	} else if seed, err = h.newSeed(); err != nil {
+		writeError(rw, "internal_error", "Unable to pick a seed for the game.", 500)
+		return
+	}
+	var bounds []int
+	if body.Balanced && len(body.Fixed) == 0 {
+		words, bounds = h.partitionWords(words, mergedLists)
+	}
+	state := NewState(seed, words)
+	state.SourceLists = sourceLists
+	state.FilteredWords = filteredWords
+	state.WordDifficulty = ratedWords(words, ratings)
+	if len(bounds) > 1 {
+		state.ListBounds = bounds
+	}
+	state.StartingTeam = body.StartingTeam
+	state.HintMode = body.HintMode
+	state.ConfirmGuesses = body.ConfirmGuesses
+	state.ManualEndTurn = body.AutoEndTurn != nil && !*body.AutoEndTurn
+	state.MirrorDoubleGreens = body.MirrorDoubleGreens
+	state.TeamTurns = body.TeamTurns
+	state.TimerTokens = body.TimerTokens
+	state.SuddenDeath = body.SuddenDeath
+	state.Prereveal = body.Prereveal
+	state.Spectators = body.Spectators
+	if body.Password != "" {
+		if state.PasswordHash, err = hashPassword(body.Password); err != nil {
+			writeError(rw, "internal_error", "Unable to hash the password.", 500)
+			return
+		}
+	} else if oldGame != nil {
+		state.PasswordHash, state.Spectators = oldGame.PasswordHash, oldGame.Spectators
+	}
+	if body.Blacks != 0 {
+		state.Distribution = dist[:]
+	}
+	if len(body.Fixed) > 0 {
+		state.Board = pinBoard(rand.New(rand.NewSource(seed)), words, body.Fixed, dist)
+	}
+	if state.StartingTeam == NoTeam {
+		state.StartingTeam = TeamOne
+		if oldGame != nil {
+			// Alternate the starting team between games.
+			state.StartingTeam = otherTeam(oldGame.startingTeam())
+		}
+	}
+	game, err := ReconstructGame(state)
+	if err != nil {
+		writeError(rw, "bad_state", fmt.Sprintf("Invalid game state: %s.", err), 400)
+		return
+	}
+	if field := game.checkPrereveal(); field != "" && !body.PrerevealAny {
+		writeFieldError(rw, "bad_prereveal", fmt.Sprintf("Cell %s isn't green.", field),
+			map[string]string{field: "isn't green"}, 422)
+		return
+	}
+	if game.status() != "" {
+		if !body.PrerevealAny {
+			writeFieldError(rw, "bad_prereveal", "The prerevealed cells would end the game.",
+				map[string]string{"prereveal": "would end the game"}, 422)
+			return
+		}
+		game.checkFinished("", NoTeam, h.now())
+	}
+	if body.DryRun {
+		h.issuePreview(body.GameID, seed)
+		game.CreatedAt = h.now()
+		writeJSON(rw, game)
+		return
+	}
+	if boardSeed != nil {
+		delete(h.previews, previewKey{body.GameID, seed})
+	}
+	if oldGame != nil && !rotating && h.resetGracePeriod > 0 && oldGame.OutcomeReason == "" {
+		h.scheduleReset(body.GameID, oldGame, game, body.Players, requestIDOf(rw))
+		writeJSONStatus(rw, oldGame, 202)
+		return
+	}
+
+	g := game
+	h.replaceGame(body.GameID, oldGame, g, body.Players, requestIDOf(rw))
+	writeJSON(rw, g)
+}
+
+// previewTTL is how long the seed of a dry run may be passed to
+// /new-game as board_seed, and maxPreviews bounds how many such
+// seeds are kept.
+const (
+	previewTTL  = 10 * time.Minute
+	maxPreviews = 1024
+)
+
+// previewKey identifies the board previewed by a dry run.
+type previewKey struct {
+	gameID string
+	seed   int64
+}
+
+// issuePreview records that a dry run for the game identified by
+// gameID previewed a board drawn from seed. h.mu must be held.
+func (h *handler) issuePreview(gameID string, seed int64) {
+	now := h.now()
+	for k, expires := range h.previews {
+		if !expires.After(now) || len(h.previews) >= maxPreviews {
+			delete(h.previews, k)
+		}
+	}
+	if h.previews == nil {
+		h.previews = map[previewKey]time.Time{}
+	}
+	h.previews[previewKey{gameID, seed}] = now.Add(previewTTL)
+}
+
+// previewed reports whether a recent dry run for the game identified
+// by gameID previewed a board drawn from seed. h.mu must be held.
+func (h *handler) previewed(gameID string, seed int64) bool {
+	expires, ok := h.previews[previewKey{gameID, seed}]
+	return ok && expires.After(h.now())
+}
+
+// rotatable reports whether g may be replaced without its seed. See
+// WithGameRotation. g.mu must be held.
+func (h *handler) rotatable(g *Game) bool {
+	if h.rotateAfter <= 0 || len(g.Players) > 0 {
+		return false
+	}
+	if g.OutcomeReason != "" {
+		return true
+	}
+	idleSince := g.CreatedAt
+	if !g.LastEmptyAt.IsZero() {
+		idleSince = g.LastEmptyAt
+	}
+	return !h.now().Before(idleSince.Add(h.rotateAfter))
+}
+
+// replaceGame stores game under id, in place of oldGame if it's
+// non-nil, and assigns the players in roster to their teams. The
+// replacement is logged for the request identified by requestID.
+// h.mu and oldGame.mu must be held.
+func (h *handler) replaceGame(id string, oldGame, game *Game, roster map[string]int, requestID string) {
+	if oldGame != nil {
+		// Carry over the players but without teams in case
+		// they want to switch them up.
+		for pid, p := range oldGame.Players {
+			game.Players[pid] = Player{LastSeen: p.LastSeen}
+		}
+		game.debug = oldGame.debug.clone()
+		game.debug.addf(h.now(), debugReset, "", requestID, "seed %d replaced by %d", oldGame.Seed, game.Seed)
+
+		// Wake up any clients waiting on this game.
+		oldGame.notifyAll()
+	}
+	for pid, team := range roster {
+		p := game.Players[pid]
+		p.Team, p.LastSeen = team, h.now()
+		game.Players[pid] = p
+	}
+	game.CreatedAt = h.now()
+	h.games.Put(id, game)
+}
+
+// scheduleReset arranges for game to replace oldGame, which is
+// stored under id, once the handler's reset grace period has
+// passed, on behalf of the request identified by requestID.
+// oldGame.mu must be held.
+func (h *handler) scheduleReset(id string, oldGame, game *Game, roster map[string]int, requestID string) {
+	at := h.now().Add(h.resetGracePeriod)
+	oldGame.pendingReset = &pendingReset{
+		game:      game,
+		roster:    roster,
+		requestID: requestID,
+		at:        at,
+		timer:     time.AfterFunc(h.resetGracePeriod, func() { h.applyDueReset(id) }),
+	}
+	oldGame.ResetAt = &at
+	oldGame.addEvent(Event{Type: "reset_pending"})
+	oldGame.debug.addf(h.now(), debugReset, "", requestID, "seed %d pending replacement by %d at %s",
+		oldGame.Seed, game.Seed, at.Format(time.RFC3339))
+	h.games.Save(id, oldGame)
+}
+
+// POST /cancel-reset
+// This endpoint cancels a game's pending reset, adding a
+// reset_cancelled event. It fails with no_pending_reset if the
+// game has no reset pending.
+func (h *handler) handleCancelReset(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+
+	// A reset whose grace period has passed can't be cancelled.
+	h.applyDueReset(body.GameID)
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if !g.cancelReset() {
+		writeError(rw, "no_pending_reset", "The game has no pending reset.", 409)
+		return
+	}
+	g.addEvent(Event{Type: "reset_cancelled", PlayerID: body.PlayerID, Name: body.Name})
+	g.debug.addf(h.now(), debugReset, body.PlayerID, requestIDOf(rw), "pending reset cancelled")
+	h.games.Save(body.GameID, g)
+	writeJSON(rw, map[string]string{"status": "ok"})
+}
+
+// POST /reshuffle-layout
+// This endpoint replaces a game with a new one that keeps the same
+// words but reassigns the layouts. Like a rematch through /new-game,
+// the request must include the current seed, and the new game has a
+// new seed so that clients notice the change. A reset pending for
+// the game is cancelled, adding a reset_cancelled event.
+func (h *handler) handleReshuffleLayout(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID string `json:"game_id"`
+		Seed   Seed   `json:"seed"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldGame, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, oldGame, true) {
+		return
+	}
+	oldGame.mu.Lock()
+	defer oldGame.mu.Unlock()
+	if body.Seed != oldGame.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if oldGame.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+
+	if len(oldGame.Board) > 0 {
+		writeError(rw, "pinned_board", "A board with pinned words can't be reshuffled.", 409)
+		return
+	}
+
+	seed, err := h.newSeed()
+	if err != nil {
+		writeError(rw, "internal_error", "Unable to pick a seed for the game.", 500)
+		return
+	}
+	state := NewState(seed, oldGame.WordSet)
+	state.SourceLists = oldGame.SourceLists
+	state.FilteredWords = oldGame.FilteredWords
+	state.WordDifficulty = oldGame.WordDifficulty
+	state.StartingTeam = otherTeam(oldGame.startingTeam())
+	state.HintMode = oldGame.HintMode
+	state.ConfirmGuesses = oldGame.ConfirmGuesses
+	state.ManualEndTurn = oldGame.ManualEndTurn
+	state.MirrorDoubleGreens = oldGame.MirrorDoubleGreens
+	state.TeamTurns = oldGame.TeamTurns
+	state.TimerTokens = oldGame.TimerTokens
+	state.SuddenDeath = oldGame.SuddenDeath
+	state.PasswordHash = oldGame.PasswordHash
+	state.Spectators = oldGame.Spectators
+	state.Distribution = oldGame.Distribution
+	state.ListBounds = oldGame.ListBounds
+	state.WordSeed = oldGame.WordSeed
+	if state.WordSeed == 0 {
+		state.WordSeed = oldGame.Seed
+	}
+	game, err := ReconstructGame(state)
+	if err != nil {
+		writeError(rw, "bad_state", fmt.Sprintf("Invalid game state: %s.", err), 400)
+		return
+	}
+
+	// The reshuffled game replaces any reset that was pending.
+	if oldGame.cancelReset() {
+		oldGame.addEvent(Event{Type: "reset_cancelled"})
+		oldGame.debug.addf(h.now(), debugReset, "", requestIDOf(rw), "pending reset cancelled by reshuffling")
+	}
+
+	// Carry over the players but without teams, as with a
+	// rematch, and wake up any clients waiting on the old game.
+	for id, p := range oldGame.Players {
+		game.Players[id] = Player{Name: p.Name, LastSeen: p.LastSeen}
+	}
+	game.debug = oldGame.debug.clone()
+	game.debug.addf(h.now(), debugReset, "", requestIDOf(rw), "seed %d reshuffled to %d", oldGame.Seed, game.Seed)
+	oldGame.notifyAll()
+
+	g := game
+	g.CreatedAt = h.now()
+	h.games.Put(body.GameID, g)
+	writeJSON(rw, g)
+}
+
+// GET /board?code=...&word_set_hash=...
+// This endpoint previews the board identified by a share code,
+// without creating a game. The word_set_hash of the game that the
+// code was shared from must be included, so that a preview of a
+// word list that's since changed is refused instead of showing a
+// different board.
+func (h *handler) handleBoard(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	seed, lists, err := decodeShareCode(query.Get("code"))
+	if err != nil {
+		writeError(rw, "bad_share_code", "Invalid share code.", 400)
+		return
+	}
+	hash := query.Get("word_set_hash")
+	if hash == "" {
+		writeError(rw, "malformed_query", "Missing word_set_hash.", 400)
+		return
+	}
+	words, ratings, err := h.mergeWordLists(lists)
+	if err != nil {
+		writeError(rw, "unknown_word_list", fmt.Sprintf("Invalid word lists: %s.", err), 404)
+		return
+	}
+	if hash != wordSetHash(words) {
+		writeError(rw, "word_set_mismatch", "The word lists have changed since the board was shared.", 409)
+		return
+	}
+
+	state := NewState(int64(seed), words)
+	state.SourceLists = lists
+	state.WordDifficulty = ratedWords(words, ratings)
+	game, err := ReconstructGame(state)
+	if err != nil {
+		writeError(rw, "bad_state", fmt.Sprintf("Invalid game state: %s.", err), 400)
+		return
+	}
+	writeJSON(rw, game)
+}
+
+// ratedWords returns the difficulty ratings of the words in words
+// that are rated in ratings, or nil if none of them are.
+func ratedWords(words []string, ratings map[string]int) map[string]int {
+	var rated map[string]int
+	for _, w := range words {
+		if d, ok := ratings[w]; ok {
+			if rated == nil {
+				rated = map[string]int{}
+			}
+			rated[w] = d
+		}
+	}
+	return rated
+}
+
+// POST /validate-words
+// This endpoint checks a list of words that a client intends to
+// supply to /new-game, without creating a game.
+func (h *handler) handleValidateWords(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Words []string `json:"words"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	writeJSON(rw, h.checkWords(body.Words))
+}
+
+// newSeed picks the seed for a new game. h.mu must be held.
+func (h *handler) newSeed() (int64, error) {
+	if !h.cryptoSeeds {
+		return h.rand.Int63(), nil
+	}
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:])), nil
+}
+
+// checkWord describes the problem with a word supplied by a
+// client, or returns the empty string if it's acceptable.
+func (h *handler) checkWord(w string) string {
+	if utf8.RuneCountInString(w) > h.maxWordLength {
+		return fmt.Sprintf("is longer than %d characters", h.maxWordLength)
+	}
+	for _, r := range w {
+		if unicode.IsControl(r) {
+			return "contains a control character"
+		}
+	}
+	return ""
+}
+
+// checkRoster checks the players assigned to teams in a request to
+// /new-game. If there's a problem, it returns the error code, the
+// offending field and a description of the problem.
+func (h *handler) checkRoster(players map[string]int) (code, field, problem string) {
+	perTeam := map[int]int{}
+	for id, team := range players {
+		switch {
+		case !validPlayerID(id):
+			return "bad_player_id", "players", "has an empty player ID"
+		case !validTeam(team):
+			return "bad_team", fmt.Sprintf("players[%s]", id), "must be 1 or 2"
+		}
+		perTeam[team]++
+	}
+	for _, team := range []int{TeamOne, TeamTwo} {
+		if h.maxPlayersPerTeam > 0 && perTeam[team] > h.maxPlayersPerTeam {
+			return "team_full", "players", fmt.Sprintf("has more than %d players on team %d", h.maxPlayersPerTeam, team)
+		}
+	}
+	return "", "", ""
+}
+
+// wordCheck is the result of checking a list of words supplied
+// by a client. Count is the number of distinct acceptable words,
+// Duplicates is the number of repeated words that were dropped,
+// and Enough reports whether Count is enough to draw a board from.
+type wordCheck struct {
+	Count      int            `json:"count"`
+	Duplicates int            `json:"duplicates"`
+	Rejected   []rejectedWord `json:"rejected"`
+	Enough     bool           `json:"enough"`
+}
+
+// rejectedWord is a word that failed checkWord, and its index in
+// the list that was checked.
+type rejectedWord struct {
+	Index  int    `json:"index"`
+	Word   string `json:"word"`
+	Reason string `json:"reason"`
+}
+
+// checkWords checks a list of words supplied by a client for a
+// new game. It's shared by /new-game and /validate-words, so that
+// a list that validates is accepted for a new game.
+func (h *handler) checkWords(words []string) wordCheck {
+	check := wordCheck{Rejected: []rejectedWord{}}
+	seen := map[string]bool{}
+	for i, w := range words {
+		if problem := h.checkWord(w); problem != "" {
+			check.Rejected = append(check.Rejected, rejectedWord{Index: i, Word: w, Reason: problem})
+			continue
+		}
+		if w = normalizeWord(w); seen[w] {
+			check.Duplicates++
+			continue
+		}
+		seen[w] = true
+		check.Count++
+	}
+	check.Enough = check.Count >= len(colorDistribution)
+	return check
+}
+
+// checkWordLengths returns the field of a newGameRequest that's
+// out of range, if any, given its min_len and max_len, along with
+// the problem with it.
+func checkWordLengths(min, max int) (field, problem string) {
+	switch {
+	case min < 0:
+		return "min_len", "must not be negative"
+	case max < 0:
+		return "max_len", "must not be negative"
+	case max > 0 && min > max:
+		return "min_len", "must be at most max_len"
+	}
+	return "", ""
+}
+
+// filterWordLengths returns the words of words that have at least
+// min and, if max is non-zero, at most max runes.
+func filterWordLengths(words []string, min, max int) []string {
+	filtered := []string{}
+	for _, w := range words {
+		n := utf8.RuneCountInString(w)
+		if n >= min && (max == 0 || n <= max) {
+			filtered = append(filtered, w)
+		}
+	}
+	return filtered
+}
+
+// avoidWords returns the distinct words of words that aren't in
+// avoid.
+func avoidWords(words, avoid []string) []string {
+	skip := map[string]bool{}
+	for _, w := range avoid {
+		skip[w] = true
+	}
+	filtered := []string{}
+	for _, w := range words {
+		if !skip[w] {
+			filtered = append(filtered, w)
+			skip[w] = true
+		}
+	}
+	return filtered
+}
+
+// mergeWordLists returns the sorted, deduplicated union of the
+// named word lists, along with the difficulty ratings of the rated
+// words. A word that's rated by two lists gets the higher rating.
+func (h *handler) mergeWordLists(names []string) ([]string, map[string]int, error) {
+	m := map[string]bool{}
+	words := []string{}
+	ratings := map[string]int{}
+	for _, name := range names {
+		list, listRatings, ok := h.wordLists.list(name)
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown word list %q", name)
+		}
+		for _, w := range list {
+			if !m[w] {
+				words = append(words, w)
+				m[w] = true
+			}
+			if d, ok := listRatings[w]; ok {
+				if prev, rated := ratings[w]; !rated || d > prev {
+					ratings[w] = d
+				}
+			}
+		}
+	}
+	sort.Strings(words)
+	return words, ratings, nil
+}
+
+// partitionWords reorders words into pools, one for each of the
+// named word lists, for a balanced draw. Each word goes in the pool
+// of the first list that contains it. It returns the reordered
+// words along with the end of each pool in them, leaving out the
+// pools of lists that contain none of the words.
+func (h *handler) partitionWords(words, lists []string) ([]string, []int) {
+	remaining := map[string]bool{}
+	for _, w := range words {
+		remaining[w] = true
+	}
+	pooled := make([]string, 0, len(remaining))
+	var bounds []int
+	for _, name := range lists {
+		start := len(pooled)
+		list, _, _ := h.wordLists.list(name)
+		for _, w := range list {
+			if remaining[w] {
+				pooled = append(pooled, w)
+				delete(remaining, w)
+			}
+		}
+		if len(pooled) > start {
+			bounds = append(bounds, len(pooled))
+		}
+	}
+	return pooled, bounds
+}
+
+// guessRequest is the body of a request to /guess.
+type guessRequest struct {
+	GameID   string `json:"game_id"`
+	Seed     Seed   `json:"seed"`
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+	Team     int    `json:"team"`
+	Index    int    `json:"index"`
+}
+
+// guessResponse is the body of a successful response to /guess.
+// Seed and Event identify the game, and the number of its last
+// event, that the guess was applied to.
+type guessResponse struct {
+	Status string `json:"status"`
+	Seed   Seed   `json:"seed"`
+	Event  int    `json:"event"`
+}
+
+// POST /guess
+func (h *handler) handleGuess(rw http.ResponseWriter, req *http.Request) {
+	var body guessRequest
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+	if body.Index < 0 || body.Index >= len(colorDistribution) {
+		writeFieldError(rw, "bad_index", "Index is out of range.",
+			map[string]string{"index": "out of range"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		h.rejectGuess(rw, g, body, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		h.rejectGuess(rw, g, body, "game_frozen", "The game is frozen.", nil, 423)
+		return
+	}
+
+	if g.ConfirmGuesses {
+		h.rejectGuess(rw, g, body, "confirmation_required", "Guesses in this game must be proposed and confirmed.", nil, 409)
+		return
+	}
+	// The game may have been reset while we were waiting for
+	// its lock. Guessing on the discarded board would be lost.
+	if cur, ok := h.games.Get(body.GameID); !ok || cur != g {
+		h.rejectGuess(rw, g, body, "game_replaced", "The game has been replaced by a new one.", nil, 409)
+		return
+	}
+
+	// If the client is retrying a guess that we've already applied,
+	// return the original response instead of applying it again.
+	key := req.Header.Get("Idempotency-Key")
+	if resp, ok := g.idempotency.get(key, h.now()); ok {
+		writeJSON(rw, resp)
+		return
+	}
+
+	// Players may only guess for the team they've joined. Switching
+	// teams must be done explicitly, not as a side effect of a guess.
+	if p, ok := g.Players[body.PlayerID]; ok && p.Team != NoTeam && p.Team != body.Team {
+		h.rejectGuess(rw, g, body, "wrong_team", "Player belongs to a different team.", nil, 403)
+		return
+	}
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		h.rejectGuess(rw, g, body, "team_full", "That team is full.", nil, 409)
+		return
+	}
+	if g.teamOutOfTurns(body.Team) {
+		h.rejectGuess(rw, g, body, "no_turns_left", "The team has no turns left.", nil, 409)
+		return
+	}
+	// Once the game is over, such as after a black is revealed,
+	// the board is settled.
+	if g.status() != "" {
+		h.rejectGuess(rw, g, body, "game_over", "The game is over.", nil, 409)
+		return
+	}
+	if body.Team != g.ActiveTeam && !g.inSuddenDeath() {
+		h.rejectGuess(rw, g, body, "not_your_turn", "It's the other team's turn to guess.", nil, 409)
+		return
+	}
+
+	now := h.now()
+	if last, ok := g.lastGuess[body.PlayerID]; ok && now.Sub(last) < h.guessCooldown {
+		wait := h.guessCooldown - now.Sub(last)
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		h.rejectGuess(rw, g, body, "too_fast", "Guesses are too close together.", nil, 429)
+		return
+	}
+
+	wasOver := g.OutcomeReason != ""
+	g.markSeen(body.PlayerID, body.Name, body.Team, now)
+	g.guess(body.PlayerID, body.Name, body.Team, body.Index, now)
+	g.debug.addf(now, debugGuess, body.PlayerID, requestIDOf(rw), "index %d for team %d, %d events", body.Index, body.Team, len(g.Events))
+	h.games.Save(body.GameID, g)
+	h.notifyGameOver(body.GameID, g, wasOver)
+	if h.guessCooldown > 0 {
+		if g.lastGuess == nil {
+			g.lastGuess = map[string]time.Time{}
+		}
+		g.lastGuess[body.PlayerID] = now
+	}
+
+	resp := guessResponse{Status: "ok", Seed: g.Seed, Event: len(g.Events)}
+	if key != "" {
+		if g.idempotency == nil {
+			g.idempotency = idempotencyCache{}
+		}
+		g.idempotency.put(key, resp, h.now())
+	}
+	writeJSON(rw, resp)
+}
+
+// rejectGuess records the rejection of a guess in g's debug log,
+// and then writes the error. g.mu must be held.
+func (h *handler) rejectGuess(rw http.ResponseWriter, g *Game, body guessRequest, code, message string, fields map[string]string, statusCode int) {
+	g.debug.addf(h.now(), debugGuessRejected, body.PlayerID, requestIDOf(rw), "%s: index %d for team %d", code, body.Index, body.Team)
+	writeFieldError(rw, code, message, fields, statusCode)
+}
+
+// POST /propose-guess
+// POST /confirm-guess
+// POST /cancel-guess
+// In games that require guesses to be confirmed, these endpoints
+// propose a guess for the player's team, replacing any earlier
+// proposal, then either confirm the team's proposal, making the
+// guess, or cancel it.
+func (h *handler) handleGuessProposal(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+		Team     int    `json:"team"`
+		Index    int    `json:"index"`
+	}
+
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+	if body.Index < 0 || body.Index >= len(colorDistribution) {
+		writeFieldError(rw, "bad_index", "Index is out of range.",
+			map[string]string{"index": "out of range"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if !g.ConfirmGuesses {
+		writeError(rw, "confirmation_off", "Guesses in this game don't need to be confirmed.", 409)
+		return
+	}
+	if p, ok := g.Players[body.PlayerID]; ok && p.Team != NoTeam && p.Team != body.Team {
+		writeError(rw, "wrong_team", "Player belongs to a different team.", 403)
+		return
+	}
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+
+	if g.teamOutOfTurns(body.Team) {
+		writeError(rw, "no_turns_left", "The team has no turns left.", 409)
+		return
+	}
+
+	if req.URL.Path != "/cancel-guess" {
+		if g.status() != "" {
+			writeError(rw, "game_over", "The game is over.", 409)
+			return
+		}
+		if body.Team != g.ActiveTeam && !g.inSuddenDeath() {
+			writeError(rw, "not_your_turn", "It's the other team's turn to guess.", 409)
+			return
+		}
+	}
+
+	proposal, proposed := g.Proposals[body.Team]
+	if req.URL.Path == "/confirm-guess" && !proposed {
+		writeError(rw, "no_proposal", "The team hasn't proposed a guess.", 409)
+		return
+	}
+
+	now := h.now()
+	wasOver := g.OutcomeReason != ""
+	g.markSeen(body.PlayerID, body.Name, body.Team, now)
+	switch req.URL.Path {
+	case "/propose-guess":
+		g.addEvent(Event{
+			Type:     "propose_guess",
+			Team:     body.Team,
+			Index:    body.Index,
+			PlayerID: body.PlayerID,
+			Name:     body.Name,
+		})
+	case "/confirm-guess":
+		// The guess is credited to the player who proposed it.
+		g.addGuess(proposal.PlayerID, proposal.Name, body.Team, proposal.Index, now)
+	case "/cancel-guess":
+		if proposed {
+			g.addEvent(Event{
+				Type:     "cancel_guess",
+				Team:     body.Team,
+				PlayerID: body.PlayerID,
+				Name:     body.Name,
+			})
+		}
+	}
+	h.games.Save(body.GameID, g)
+	h.notifyGameOver(body.GameID, g, wasOver)
+	writeJSON(rw, map[string]string{"status": "ok"})
+}
+
+// endTurnRequest is the body of a request to /end-turn.
+type endTurnRequest struct {
+	GameID   string `json:"game_id"`
+	Seed     Seed   `json:"seed"`
+	PlayerID string `json:"player_id"`
+	Name     string `json:"name"`
+	Team     int    `json:"team"`
+}
+
+// endTurnResponse is the body of a successful response to
+// /end-turn. It describes the turn that the pass began, or the
+// outcome of the game if the pass ended it, so that clients
+// needn't wait for their next poll to show it.
+type endTurnResponse struct {
+	Status        string      `json:"status"`
+	Seed          Seed        `json:"seed"`
+	Event         int         `json:"event"`
+	Turn          int         `json:"turn"`
+	ActiveTeam    int         `json:"active_team"`
+	Phase         string      `json:"phase"`
+	TokensLeft    int         `json:"tokens_left"`
+	TurnsLeft     map[int]int `json:"turns_left,omitempty"`
+	GameStatus    string      `json:"game_status"`
+	OutcomeReason string      `json:"outcome_reason,omitempty"`
+}
+
+// POST /end-turn
+// This endpoint passes: the guessing team ends its turn, using up a
+// timer token, and the other team guesses next.
+func (h *handler) handleEndTurn(rw http.ResponseWriter, req *http.Request) {
+	var body endTurnRequest
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	if g.teamOutOfTurns(body.Team) {
+		writeError(rw, "no_turns_left", "The team has no turns left.", 409)
+		return
+	}
+	switch {
+	case g.status() != "":
+		writeError(rw, "game_over", "The game is over.", 409)
+		return
+	case g.inSuddenDeath():
+		writeError(rw, "sudden_death", "Turns don't end in sudden death.", 409)
+		return
+	case body.Team != g.ActiveTeam:
+		writeError(rw, "not_your_turn", "It's the other team's turn to guess.", 409)
+		return
+	}
+	wasOver := g.OutcomeReason != ""
+	g.markSeen(body.PlayerID, body.Name, body.Team, h.now())
+	g.addEvent(Event{
+		Type:     "end_turn",
+		Team:     body.Team,
+		PlayerID: body.PlayerID,
+		Name:     body.Name,
+	})
+	g.checkFinished(body.PlayerID, body.Team, h.now())
+	h.games.Save(body.GameID, g)
+	h.notifyGameOver(body.GameID, g, wasOver)
+	writeJSON(rw, endTurnResponse{
+		Status:        "ok",
+		Seed:          g.Seed,
+		Event:         len(g.Events),
+		Turn:          g.Turn,
+		ActiveTeam:    g.ActiveTeam,
+		Phase:         g.Phase,
+		TokensLeft:    g.TokensLeft,
+		TurnsLeft:     g.TurnsLeft,
+		GameStatus:    g.Status,
+		OutcomeReason: g.OutcomeReason,
+	})
+}
+
+// POST /clue
+// This endpoint records the clue that a team gives for the other
+// team to guess from, so that every client can show it. A team may
+// give one clue in each of the other team's turns.
+func (h *handler) handleClue(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+		Team     int    `json:"team"`
+		Word     string `json:"word"`
+		Count    int    `json:"count"`
+	}
+
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+	body.Word = strings.TrimSpace(body.Word)
+	problem := h.checkWord(body.Word)
+	if body.Word == "" {
+		problem = "is empty"
+	}
+	if problem != "" {
+		writeFieldError(rw, "bad_clue", fmt.Sprintf("The clue %s.", problem),
+			map[string]string{"word": problem}, 422)
+		return
+	}
+	if body.Count < 0 || body.Count > maxClueCount {
+		writeFieldError(rw, "bad_clue", fmt.Sprintf("The count must be between 0 and %d.", maxClueCount),
+			map[string]string{"count": fmt.Sprintf("must be between 0 and %d", maxClueCount)}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if p, ok := g.Players[body.PlayerID]; ok && p.Team != NoTeam && p.Team != body.Team {
+		writeError(rw, "wrong_team", "Player belongs to a different team.", 403)
+		return
+	}
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	switch {
+	case g.status() != "":
+		writeError(rw, "game_over", "The game is over.", 409)
+		return
+	case g.inSuddenDeath():
+		writeError(rw, "sudden_death", "No more clues may be given in sudden death.", 409)
+		return
+	case body.Team == g.ActiveTeam:
+		writeError(rw, "not_your_turn", "The team is guessing, not giving a clue.", 409)
+		return
+	case g.Clue != nil:
+		writeError(rw, "clue_given", "A clue has already been given this turn.", 409)
+		return
+	}
+
+	g.markSeen(body.PlayerID, body.Name, body.Team, h.now())
+	g.addEvent(Event{
+		Type:     "clue",
+		Team:     body.Team,
+		PlayerID: body.PlayerID,
+		Name:     body.Name,
+		Word:     body.Word,
+		Count:    body.Count,
+	})
+	h.games.Save(body.GameID, g)
+	writeJSON(rw, g.Clue)
+}
+
+// POST /chat
+// Chat messages are added to the game's events, for clients
+// following them through /events, and to a buffer of recent
+// messages that may be polled through GET /chat.
+func (h *handler) handleChat(rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "GET" {
+		h.handleChatMessages(rw, req)
+		return
+	}
+
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+		Team     int    `json:"team"`
+		Message  string `json:"message"`
+	}
+
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) || body.Message == "" {
+		fields := map[string]string{}
+		if !validPlayerID(body.PlayerID) {
+			fields["player_id"] = "required"
+		}
+		if body.Message == "" {
+			fields["message"] = "required"
+		}
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.", fields, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if utf8.RuneCountInString(body.Message) > maxChatLength {
+		writeFieldError(rw, "message_too_long",
+			fmt.Sprintf("Messages may be at most %d characters.", maxChatLength),
+			map[string]string{"message": "too long"}, 422)
+		return
+	}
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	now := h.now()
+	if last, ok := g.lastChat[body.PlayerID]; ok && now.Sub(last) < chatCooldown {
+		wait := chatCooldown - now.Sub(last)
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(rw, "too_fast", "Messages are too close together.", 429)
+		return
+	}
+	if g.lastChat == nil {
+		g.lastChat = map[string]time.Time{}
+	}
+	g.lastChat[body.PlayerID] = now
+
+	g.markSeen(body.PlayerID, body.Name, body.Team, now)
+	g.chat.add(ChatMessage{
+		PlayerID: body.PlayerID,
+		Name:     body.Name,
+		Team:     body.Team,
+		Message:  body.Message,
+		Time:     now,
+	})
+	g.addEvent(Event{
+		Type:     "chat",
+		Team:     body.Team,
+		PlayerID: body.PlayerID,
+		Name:     body.Name,
+		Message:  body.Message,
+	})
+	h.games.Save(body.GameID, g)
+	writeJSON(rw, map[string]string{"status": "ok"})
+}
+
+// GET /chat?game_id=...&since=...
+// This endpoint returns the game's recent chat messages numbered
+// after since, for clients that poll for them. Only the most recent
+// maxChatMessages messages are kept.
+func (h *handler) handleChatMessages(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	gameID := query.Get("game_id")
+	if gameID == "" {
+		writeError(rw, "malformed_query", "Missing game_id.", 400)
+		return
+	}
+	var since int
+	if s := query.Get("since"); s != "" {
+		var err error
+		if since, err = strconv.Atoi(s); err != nil {
+			writeError(rw, "malformed_query", "since must be an integer.", 400)
+			return
+		}
+	}
+
+	g, ok := h.games.Get(gameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, false) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(rw, struct {
+		Messages []ChatMessage `json:"messages"`
+	}{g.chat.since(since)})
+}
+
+// eventsRequest is the body of a request to /events.
+type eventsRequest struct {
+	GameID    string `json:"game_id"`
+	Seed      Seed   `json:"seed"`
+	PlayerID  string `json:"player_id"`
+	Name      string `json:"name"`
+	Team      int    `json:"team"`
+	LastEvent int    `json:"last_event"`
+}
+
+// POST /events
+func (h *handler) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	var body eventsRequest
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if body.Team != NoTeam && !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 0, 1 or 2.",
+			map[string]string{"team": "must be 0, 1 or 2"}, 422)
+		return
+	}
+
+	h.applyDueReset(body.GameID)
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, false) {
+		return
+	}
+	if !g.allowed(req.Header.Get(passwordHeader), true) {
+		body.Team = NoTeam // spectators can't join a team
+	}
+
+	g.mu.Lock()
+	if body.Seed != g.Seed {
+		evts, _ := g.eventsSince(body.LastEvent)
+		update := g.update(evts, h.pollAfter(g))
+		g.mu.Unlock()
+		writeUpdate(rw, req, update)
+		return
+	}
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		g.mu.Unlock()
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	// Players may follow a frozen game, but not join it.
+	if !g.Frozen {
+		g.markSeen(body.PlayerID, body.Name, body.Team, h.now())
+		h.games.Save(body.GameID, g)
+	}
+
+	evts, ch := g.eventsSince(body.LastEvent)
+	update := g.update(evts, h.pollAfter(g))
+
+	// Release the mutex.
+	// We reacquire it when we reretrieve the game.
+	g.mu.Unlock()
+
+	if len(evts) > 0 {
+		writeUpdate(rw, req, update)
+		return
+	}
+
+	// Wait until a new event becomes available, the client
+	// gives up, or we time out.
+	select {
+	case <-ch:
+		// re-retrieve the game in case it was replaced
+		// while we were waiting for events.
+		g, ok := h.games.Get(body.GameID)
+		if !ok {
+			writeError(rw, "not_found", "Game not found", 404)
+			return
+		}
+		g.mu.Lock()
+		evts, _ = g.eventsSince(body.LastEvent)
+		update = g.update(evts, h.pollAfter(g))
+		g.mu.Unlock()
+
+	case <-req.Context().Done():
+	case <-time.After(25 * time.Second):
+	}
+	writeUpdate(rw, req, update)
+}
+
+// pollAfter returns the number of milliseconds that clients
+// following g should wait before polling again. Games with a
+// player on each team that aren't over are polled more often than
+// idle or finished ones, and the interval is jittered by up to a
+// fifth in either direction so that clients don't poll in lockstep.
+// g.mu must be held.
+func (h *handler) pollAfter(g *Game) int64 {
+	interval := h.idlePollInterval
+	if g.OutcomeReason == "" && g.hasPlayers(TeamOne) && g.hasPlayers(TeamTwo) {
+		interval = h.activePollInterval
+	}
+	ms := interval.Milliseconds()
+	if ms <= 0 {
+		return 0
+	}
+	return ms + h.rand.Int63n(ms/5*2+1) - ms/5
+}
+
+// POST /ping
+// This endpoint is a convenient way to record updates to player config
+// without waiting for the long-polling loop to make a new request.
+// It only calls `markSeen` with the provided player information
+// and has no other effects. Pings to a frozen game succeed without
+// changing it, so that clients needn't treat them specially.
+func (h *handler) handlePing(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+		Team     int    `json:"team"`
+	}
+
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if body.Team != NoTeam && !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 0, 1 or 2.",
+			map[string]string{"team": "must be 0, 1 or 2"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, false) {
+		return
+	}
+	if !g.allowed(req.Header.Get(passwordHeader), true) {
+		body.Team = NoTeam // spectators can't join a team
+	}
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.teamFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	if !g.Frozen {
+		g.markSeen(body.PlayerID, body.Name, body.Team, h.now())
+		h.games.Save(body.GameID, g)
+	}
+	writeJSON(rw, map[string]string{"status": "ok"})
+}
+
+// POST /switch-team
+// This endpoint moves a player to a different team. Other
+// endpoints only assign a team to players that haven't picked
+// one yet, so that a stale request can't switch a player's team.
+func (h *handler) handleSwitchTeam(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID   string `json:"game_id"`
+		Seed     Seed   `json:"seed"`
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+		Team     int    `json:"team"`
+	}
+
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	body.Name = sanitizeName(body.Name)
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if body.Seed != g.Seed {
+		writeFieldError(rw, "bad_seed", "Request intended for a different game seed.",
+			map[string]string{"seed": "doesn't match the game"}, 400)
+		return
+	}
+	if g.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if g.switchFull(body.PlayerID, body.Team, h.maxPlayersPerTeam) {
+		writeError(rw, "team_full", "That team is full.", 409)
+		return
+	}
+	g.switchTeam(body.PlayerID, body.Name, body.Team, h.now())
+	h.games.Save(body.GameID, g)
+	writeJSON(rw, map[string]string{"status": "ok"})
+}
+
+// GET /admin/export?game_id=...
+// This endpoint returns the game's GameState so that it may be
+// archived or imported into another server. Like every admin
+// endpoint, it requires the admin token. The export of a game with
+// a password includes its password_hash, so it also requires the
+// password.
+func (h *handler) handleExport(rw http.ResponseWriter, req *http.Request) {
+	gameID := req.URL.Query().Get("game_id")
+	if gameID == "" {
+		writeError(rw, "malformed_query", "Missing game_id.", 400)
+		return
+	}
+
+	g, ok := h.games.Get(gameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+
+	if !authorize(rw, req, g, true) {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(rw, g.persisted())
+}
+
+// GET /admin/game-log?game_id=...
+// This endpoint returns the game's debug log, which records recent
+// guesses, including those that were rejected and why, along with
+// resets and games that were nearly pruned.
+func (h *handler) handleGameLog(rw http.ResponseWriter, req *http.Request) {
+	gameID := req.URL.Query().Get("game_id")
+	if gameID == "" {
+		writeError(rw, "malformed_query", "Missing game_id.", 400)
+		return
+	}
+
+	g, ok := h.games.Get(gameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(rw, struct {
+		Entries []DebugLogEntry `json:"entries"`
+	}{g.debug.all()})
+}
+
+// POST /admin/import
+// This endpoint restores a game from a GameState previously returned
+// by /admin/export. An existing game with the same ID is only replaced
+// if force is set, and if it has a password, only by a request that
+// carries it.
+func (h *handler) handleImport(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID string         `json:"game_id"`
+		Force  bool           `json:"force"`
+		State  persistedState `json:"state"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	game, err := ReconstructGame(*body.State.state())
+	if err != nil {
+		writeError(rw, "bad_state", fmt.Sprintf("Invalid game state: %s.", err), 400)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldGame, ok := h.games.Get(body.GameID)
+	if ok && !body.Force {
+		writeError(rw, "game_exists", "A game with that ID already exists.", 409)
+		return
+	}
+	if ok && !authorize(rw, req, oldGame, true) {
+		return
+	}
+
+	if oldGame != nil {
+		// Wake up any clients waiting on the replaced game.
+		oldGame.mu.Lock()
+		oldGame.notifyAll()
+		oldGame.mu.Unlock()
+	}
+
+	g := game
+	g.CreatedAt = h.now()
+	h.games.Put(body.GameID, g)
+	writeJSON(rw, g)
+}
+
+// POST /admin/rename
+// This endpoint moves a game from one ID to another, keeping its
+// state, players and pending reset, if any. It fails if a game with the new ID already
+// exists. Clients following the game under its old ID are woken up,
+// and find that it no longer exists.
+func (h *handler) handleRename(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.From == "" || body.To == "" {
+		fields := map[string]string{}
+		if body.From == "" {
+			fields["from"] = "required"
+		}
+		if body.To == "" {
+			fields["to"] = "required"
+		}
+		writeFieldError(rw, "missing_game_id", "The request must include from and to game IDs.", fields, 400)
+		return
+	}
+	if countAlphanumeric(body.To) < h.minGameIDLength {
+		writeFieldError(rw, "weak_game_id",
+			fmt.Sprintf("Game IDs must include at least %d letters and digits.", h.minGameIDLength),
+			map[string]string{"to": "too weak"}, 422)
+		return
+	}
+
+	// Holding h.mu keeps another request from creating a game
+	// under the new ID, or replacing the one under the old ID,
+	// while the game moves.
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	g, ok := h.games.Get(body.From)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+	if _, ok := h.games.Get(body.To); ok {
+		writeFieldError(rw, "game_exists", "A game with that ID already exists.",
+			map[string]string{"to": "already exists"}, 409)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h.games.Put(body.To, g)
+	h.games.Delete(body.From)
+	h.rearmReset(body.To, g)
+	g.debug.addf(h.now(), debugRename, "", requestIDOf(rw), "renamed from %q", body.From)
+	// Wake up any clients waiting on the game's old ID.
+	g.notifyAll()
+	writeJSON(rw, g)
+}
+
+// POST /admin/freeze
+// POST /admin/unfreeze
+// These endpoints freeze a game, so that it can be viewed but
+// not changed, and unfreeze it again. Requests that would change
+// a frozen game, such as guesses or resets, fail with game_frozen.
+// Freezing a game cancels its pending reset, if it has one. Players
+// may still follow a frozen game through /events and /ping, but
+// they don't join it or change their names or teams.
+func (h *handler) handleFreeze(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID string `json:"game_id"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+
+	g, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.Frozen = req.URL.Path == "/admin/freeze"
+	if g.Frozen && g.cancelReset() {
+		g.addEvent(Event{Type: "reset_cancelled"})
+		g.debug.addf(h.now(), debugReset, "", requestIDOf(rw), "pending reset cancelled by freezing")
+	}
+	// Freezing doesn't usually add an event, so Save might
+	// not write it through to the store.
+	h.games.Put(body.GameID, g)
+	writeJSON(rw, map[string]bool{"frozen": g.Frozen})
+}
+
+// POST /admin/maintenance
+// This endpoint turns maintenance mode on or off, returning whether
+// it's on. In maintenance mode, requests to start or reset a game
+// fail with maintenance, while games in progress carry on, so that
+// the server can be drained before a deploy. /readyz reports the
+// server as unready while it's in maintenance mode.
+func (h *handler) handleMaintenance(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	var v int32
+	if body.Enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&h.maintenance, v)
+	writeJSON(rw, map[string]bool{"maintenance": body.Enabled})
+}
+
+// GET /readyz
+// This endpoint reports whether the server is ready for new games,
+// for load balancers. It fails with status 503 while the server is
+// in maintenance mode.
+func (h *handler) handleReadyz(rw http.ResponseWriter, req *http.Request) {
+	maintenance := atomic.LoadInt32(&h.maintenance) != 0
+	status := http.StatusOK
+	if maintenance {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSONStatus(rw, map[string]bool{"ready": !maintenance, "maintenance": maintenance}, status)
+}
+
+// POST /admin/rewind
+// This endpoint returns the game as it was after its first n
+// events, for investigating disputes about what the board looked
+// like. The live game is only replaced with the rewound one if
+// apply is set, in which case clients must reload the game,
+// because the events after n are discarded.
+func (h *handler) handleRewind(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameID string `json:"game_id"`
+		Event  int    `json:"event"`
+		Apply  bool   `json:"apply"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oldGame, ok := h.games.Get(body.GameID)
+	if !ok {
+		writeError(rw, "not_found", "Game not found", 404)
+		return
+	}
+
+	oldGame.mu.Lock()
+	defer oldGame.mu.Unlock()
+	if body.Apply && oldGame.Frozen {
+		writeError(rw, "game_frozen", "The game is frozen.", 423)
+		return
+	}
+	if body.Event < 0 || body.Event > len(oldGame.Events) {
+		writeFieldError(rw, "bad_event", "Event is out of range.",
+			map[string]string{"event": "out of range"}, 422)
+		return
+	}
+	game, err := rewind(&oldGame.GameState, body.Event)
+	if err != nil {
+		writeError(rw, "bad_state", fmt.Sprintf("Invalid game state: %s.", err), 400)
+		return
+	}
+	g := game
+	g.CreatedAt = oldGame.CreatedAt
+
+	if body.Apply {
+		// Wake up any clients waiting on the replaced game.
+		oldGame.notifyAll()
+		h.games.Put(body.GameID, g)
+	}
+	writeJSON(rw, g)
+}
+
+type GameUpdate struct {
+	Seed   Seed    `json:"seed"`
+	Events []Event `json:"events"`
+
+	// PollAfterMS advises clients how long to wait, in
+	// milliseconds, before polling for events again.
+	PollAfterMS int64 `json:"poll_after_ms"`
+
+	// Status is the game's status, as in Game.Status.
+	Status string `json:"status"`
+
+	// Final is set once the game is over, revealing the whole
+	// board, both layouts included, to every player.
+	Final *FinalBoard `json:"final,omitempty"`
+
+	// lastEvent, exposedOne and exposedTwo are the parts of the
+	// game's state that a state frame carries beyond the fields
+	// above, for clients that ask for one instead of JSON.
+	lastEvent  uint32
+	exposedOne uint32
+	exposedTwo uint32
+}
+
+// update returns a GameUpdate carrying evts. g.mu must be held.
+func (g *Game) update(evts []Event, pollAfter int64) GameUpdate {
+	update := GameUpdate{
+		Seed:        g.Seed,
+		Events:      evts,
+		PollAfterMS: pollAfter,
+		Status:      g.Status,
+		lastEvent:   uint32(len(g.Events)),
+		exposedOne:  exposedBits(g.ExposedOne),
+		exposedTwo:  exposedBits(g.ExposedTwo),
+	}
+	if b, ok := g.finalBoard(); ok {
+		update.Final = &b
+	}
+	return update
+}
+
+// GET /word-lists
+// This endpoint describes the available word lists so that
+// clients may offer a choice between them.
+func (h *handler) handleWordLists(rw http.ResponseWriter, req *http.Request) {
+	type wordList struct {
+		Name     string `json:"name"`
+		Label    string `json:"label"`
+		Category string `json:"category,omitempty"`
+		Count    int    `json:"count"`
+	}
+
+	lists := []wordList{}
+	for _, name := range h.wordLists.names() {
+		n, ok := h.wordLists.count(name)
+		if !ok {
+			continue
+		}
+		meta := h.wordListMeta[name]
+		if meta.Label == "" {
+			meta.Label = name
+		}
+		lists = append(lists, wordList{
+			Name:     name,
+			Label:    meta.Label,
+			Category: meta.Category,
+			Count:    n,
+		})
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+
+	writeJSON(rw, struct {
+		WordLists []wordList `json:"word_lists"`
+	}{lists})
+}
+
+// wordListMaxAge is how long clients may cache the words of a
+// word list. Lists only change when the server restarts.
+const wordListMaxAge = time.Hour
+
+// GET /word-lists/{name}
+// This endpoint returns the sorted words of a single word list, so
+// that clients can render boards or check words offline. A list may
+// be large, so the response is compressed for clients that accept
+// gzip, and may be cached and revalidated by its ETag.
+func (h *handler) handleWordList(rw http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/word-lists/")
+	words, _, ok := h.wordLists.list(name)
+	if !ok {
+		writeError(rw, "unknown_word_list", fmt.Sprintf("Unknown word list %q.", name), 404)
+		return
+	}
+
+	etag := `"` + wordSetHash(words) + `"`
+	header := rw.Header()
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(wordListMaxAge.Seconds())))
+	header.Set("ETag", etag)
+	header.Set("Vary", "Accept-Encoding")
+	if req.Header.Get("If-None-Match") == etag {
+		rw.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp := struct {
+		Name  string   `json:"name"`
+		Words []string `json:"words"`
+	}{name, words}
+	if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+		writeJSON(rw, resp)
+		return
+	}
+	j, err := json.Marshal(resp)
+	if err != nil {
+		writeError(rw, "internal_error", "Unable to marshal response: "+err.Error(), 500)
+		return
+	}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(rw)
+	gz.Write(j)
+	gz.Close()
+}
+
+// maxBatchGames is the maximum number of games that may be
+// requested from /game-states at once.
+const maxBatchGames = 50
+
+// POST /game-states
+// This endpoint returns several games at once, keyed by their
+// IDs, for lobby views. Games that don't exist, or whose password
+// the request doesn't carry, are omitted. If fields is set, each
+// game only includes the listed top-level fields, such as
+// greens_needed and turn, so that dashboards can leave out the
+// words and layouts.
+func (h *handler) handleGameStates(rw http.ResponseWriter, req *http.Request) {
+	var body struct {
+		GameIDs []string `json:"game_ids"`
+		Fields  []string `json:"fields,omitempty"`
+	}
+	if err := decodeBody(req, &body); err != nil {
+		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+		return
+	}
+	if len(body.GameIDs) > maxBatchGames {
+		writeFieldError(rw, "too_many_games",
+			fmt.Sprintf("At most %d games may be requested at once.", maxBatchGames),
+			map[string]string{"game_ids": "too many"}, 422)
+		return
+	}
+
+	games := map[string]json.RawMessage{}
+	for _, id := range body.GameIDs {
+		g, ok := h.games.Get(id)
+		if !ok || !g.allowed(req.Header.Get(passwordHeader), false) {
+			continue
+		}
+		g.mu.Lock()
+		b, err := json.Marshal(g)
+		g.mu.Unlock()
+		if err == nil && len(body.Fields) > 0 {
+			b, err = projectFields(b, body.Fields)
+		}
+		if err != nil {
+			writeError(rw, "internal_error", "Unable to marshal response: "+err.Error(), 500)
+			return
+		}
+		games[id] = b
+	}
 	writeJSON(rw, struct {
-		AutogeneratedID string `json:"autogenerated_id"`
-	}{id})
+		Games map[string]json.RawMessage `json:"games"`
+	}{games})
 }
 
-// POST /new-game
-func (h *handler) handleNewGame(rw http.ResponseWriter, req *http.Request) {
+// watchedGame is a game that a client of /watch is subscribed to,
+// along with the seed and last event that it's already seen.
+type watchedGame struct {
+	GameID    string `json:"game_id"`
+	Seed      Seed   `json:"seed"`
+	LastEvent int    `json:"last_event"`
+}
+
+// WatchUpdate is an update to one of the games watched through
+// /watch. Like a GameUpdate, it carries the game's seed and the
+// events since the client's last event. Error is set instead if
+// the game doesn't exist or the client may not view it.
+type WatchUpdate struct {
+	GameID string  `json:"game_id"`
+	Seed   Seed    `json:"seed"`
+	Events []Event `json:"events,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// POST /watch
+// This endpoint follows several games at once over a single long
+// poll, for lobby views. The client subscribes to games by listing
+// them, and unsubscribes by leaving them out of its next request.
+// It responds as soon as any of the games has new events, or has
+// been replaced, with an update for each such game. Games that
+// don't exist are reported with an error of not_found, and games
+// whose password the request doesn't carry with bad_password,
+// rather than failing the request. Unlike /events, watching a game
+// doesn't count as being seen in it.
+func (h *handler) handleWatch(rw http.ResponseWriter, req *http.Request) {
 	var body struct {
-		GameID   string   `json:"game_id"`
-		Words    []string `json:"words,omitempty"`
-		PrevSeed *Seed    `json:"prev_seed,omitempty"` // a string because of js number precision
+		Games []watchedGame `json:"games"`
 	}
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" {
+	if err := decodeBody(req, &body); err != nil {
 		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
 		return
 	}
-
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	// If the game already exists, make sure that the request includes
-	// the existing game's seed so a delayed request doesn't reset an
-	// existing game.
-	oldGame, ok := h.games[body.GameID]
-	if ok {
-		oldGame.mu.Lock()
-		defer oldGame.mu.Unlock()
-	}
-	if ok && (body.PrevSeed == nil || *body.PrevSeed != oldGame.Seed) {
-		writeJSON(rw, oldGame)
+	if len(body.Games) > maxBatchGames {
+		writeFieldError(rw, "too_many_games",
+			fmt.Sprintf("At most %d games may be watched at once.", maxBatchGames),
+			map[string]string{"games": "too many"}, 422)
 		return
 	}
 
-	words := body.Words
-	if len(words) == 0 {
-		words = h.allWords
-	}
-	if len(words) < len(colorDistribution) {
-		writeError(rw, "too_few_words",
-			fmt.Sprintf("A word list must have at least %d words.", len(colorDistribution)), 400)
-		return
+	updates, changed, pollAfter := h.watchUpdates(body.Games, req.Header.Get(passwordHeader))
+	if len(updates) == 0 && len(changed) > 0 {
+		// Wait until one of the games has new events, the
+		// client gives up, or we time out.
+		done := make(chan struct{})
+		woken := make(chan struct{}, 1)
+		for _, ch := range changed {
+			go func(ch chan struct{}) {
+				select {
+				case <-ch:
+					select {
+					case woken <- struct{}{}:
+					default:
+					}
+				case <-done:
+				}
+			}(ch)
+		}
+		select {
+		case <-woken:
+			updates, _, pollAfter = h.watchUpdates(body.Games, req.Header.Get(passwordHeader))
+		case <-req.Context().Done():
+		case <-time.After(25 * time.Second):
+		}
+		close(done)
 	}
+	writeJSON(rw, struct {
+		Updates     []WatchUpdate `json:"updates"`
+		PollAfterMS int64         `json:"poll_after_ms"`
+	}{updates, pollAfter})
+}
 
-	game := ReconstructGame(NewState(h.rand.Int63(), words))
-	if oldGame != nil {
-		// Carry over the players but without teams in case
-		// they want to switch them up.
-		for id, p := range oldGame.players {
-			game.players[id] = Player{LastSeen: p.LastSeen}
+// watchUpdates returns the updates to the watched games, along with
+// the channels that are closed when those without updates change
+// and the shortest interval that any of the games should be polled
+// after.
+func (h *handler) watchUpdates(watched []watchedGame, password string) (updates []WatchUpdate, changed []chan struct{}, pollAfter int64) {
+	updates = []WatchUpdate{}
+	pollAfter = h.idlePollInterval.Milliseconds()
+	for _, w := range watched {
+		g, ok := h.games.Get(w.GameID)
+		if !ok {
+			updates = append(updates, WatchUpdate{GameID: w.GameID, Error: "not_found"})
+			continue
 		}
-
-		// Wake up any clients waiting on this game.
-		oldGame.notifyAll()
+		if !g.allowed(password, false) {
+			updates = append(updates, WatchUpdate{GameID: w.GameID, Error: "bad_password"})
+			continue
+		}
+		g.mu.Lock()
+		evts, ch := g.eventsSince(w.LastEvent)
+		if len(evts) > 0 || g.Seed != w.Seed {
+			updates = append(updates, WatchUpdate{GameID: w.GameID, Seed: g.Seed, Events: evts})
+		} else {
+			changed = append(changed, ch)
+		}
+		if p := h.pollAfter(g); p < pollAfter {
+			pollAfter = p
+		}
+		g.mu.Unlock()
 	}
-
-	g := &game
-	g.CreatedAt = time.Now()
-	h.games[body.GameID] = g
-	writeJSON(rw, g)
+	return updates, changed, pollAfter
 }
 
-// POST /guess
-func (h *handler) handleGuess(rw http.ResponseWriter, req *http.Request) {
-	var body struct {
-		GameID   string `json:"game_id"`
-		Seed     Seed   `json:"seed"`
-		PlayerID string `json:"player_id"`
-		Name     string `json:"name"`
-		Team     int    `json:"team"`
-		Index    int    `json:"index"`
+// projectFields returns the JSON object b with only the listed
+// top-level fields. Fields that b doesn't have are skipped.
+func projectFields(b []byte, fields []string) ([]byte, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, f := range fields {
+		if v, ok := all[f]; ok {
+			projected[f] = v
+		}
 	}
+	return json.Marshal(projected)
+}
 
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" || body.Team == 0 || body.PlayerID == "" {
-		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+// GET /summary?game_id=...
+// This endpoint describes a game for the summary screen. Cells
+// that haven't been revealed keep their colors hidden until the
+// game is over.
+func (h *handler) handleSummary(rw http.ResponseWriter, req *http.Request) {
+	gameID := req.URL.Query().Get("game_id")
+	if gameID == "" {
+		writeError(rw, "malformed_query", "Missing game_id.", 400)
 		return
 	}
 
-	h.mu.Lock()
-	g, ok := h.games[body.GameID]
-	h.mu.Unlock()
+	g, ok := h.games.Get(gameID)
 	if !ok {
 		writeError(rw, "not_found", "Game not found", 404)
 		return
 	}
-
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	if body.Seed != g.Seed {
-		writeError(rw, "bad_seed", "Request intended for a different game seed.", 400)
+	if !authorize(rw, req, g, false) {
 		return
 	}
 
-	g.markSeen(body.PlayerID, body.Name, body.Team, time.Now())
-	g.guess(body.PlayerID, body.Name, body.Team, body.Index, time.Now())
-	writeJSON(rw, map[string]string{"status": "ok"})
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeJSON(rw, g.summary())
 }
 
-// POST /end-turn
-func (h *handler) handleEndTurn(rw http.ResponseWriter, req *http.Request) {
-	var body struct {
-		GameID   string `json:"game_id"`
-		Seed     Seed   `json:"seed"`
-		PlayerID string `json:"player_id"`
-		Name     string `json:"name"`
-		Team     int    `json:"team"`
-	}
-
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" || body.Team == 0 || body.PlayerID == "" {
-		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
+// GET /final-board?game_id=...
+// This endpoint describes a finished game's board in one payload,
+// for rendering an image of the result to share. It fails with
+// game_in_progress until the game is over.
+func (h *handler) handleFinalBoard(rw http.ResponseWriter, req *http.Request) {
+	gameID := req.URL.Query().Get("game_id")
+	if gameID == "" {
+		writeError(rw, "malformed_query", "Missing game_id.", 400)
 		return
 	}
 
-	h.mu.Lock()
-	g, ok := h.games[body.GameID]
-	h.mu.Unlock()
+	g, ok := h.games.Get(gameID)
 	if !ok {
 		writeError(rw, "not_found", "Game not found", 404)
 		return
 	}
+	if !authorize(rw, req, g, false) {
+		return
+	}
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	if body.Seed != g.Seed {
-		writeError(rw, "bad_seed", "Request intended for a different game seed.", 400)
+	board, ok := g.finalBoard()
+	if !ok {
+		writeError(rw, "game_in_progress", "The game isn't over yet.", 409)
 		return
 	}
-
-	g.markSeen(body.PlayerID, body.Name, body.Team, time.Now())
-	g.addEvent(Event{
-		Type:     "end_turn",
-		Team:     body.Team,
-		PlayerID: body.PlayerID,
-		Name:     body.Name,
-	})
-	writeJSON(rw, map[string]string{"status": "ok"})
+	writeJSON(rw, board)
 }
 
-// POST /chat
-func (h *handler) handleChat(rw http.ResponseWriter, req *http.Request) {
+// POST /cell
+// This endpoint returns the color of a single cell in a team's
+// layout. While the game is in progress, players may only look
+// up cells in the layout of the team they've joined, so players
+// who haven't joined a team can't look up either. This keeps
+// clients from peeking by accident; it doesn't keep a layout
+// secret, since the game state carries both layouts.
+func (h *handler) handleCell(rw http.ResponseWriter, req *http.Request) {
 	var body struct {
 		GameID   string `json:"game_id"`
-		Seed     Seed   `json:"seed"`
 		PlayerID string `json:"player_id"`
-		Name     string `json:"name"`
 		Team     int    `json:"team"`
-		Message  string `json:"message"`
+		Index    int    `json:"index"`
 	}
 
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" || body.Team == 0 || body.PlayerID == "" || body.Message == "" {
+	if err := decodeBody(req, &body); err != nil {
 		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
 		return
 	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
+	if body.Index < 0 || body.Index >= len(colorDistribution) {
+		writeFieldError(rw, "bad_index", "Index is out of range.",
+			map[string]string{"index": "out of range"}, 422)
+		return
+	}
 
-	h.mu.Lock()
-	g, ok := h.games[body.GameID]
-	h.mu.Unlock()
+	g, ok := h.games.Get(body.GameID)
 	if !ok {
 		writeError(rw, "not_found", "Game not found", 404)
 		return
 	}
+	if !authorize(rw, req, g, false) {
+		return
+	}
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	if body.Seed != g.Seed {
-		writeError(rw, "bad_seed", "Request intended for a different game seed.", 400)
+	switch g.layoutHidden(body.PlayerID, body.Team) {
+	case "wrong_team":
+		writeError(rw, "wrong_team", "Player belongs to a different team.", 403)
+		return
+	case "not_on_team":
+		writeError(rw, "not_on_team", "Player hasn't joined the team.", 403)
 		return
 	}
 
-	g.markSeen(body.PlayerID, body.Name, body.Team, time.Now())
-	g.addEvent(Event{
-		Type:     "chat",
-		Team:     body.Team,
-		PlayerID: body.PlayerID,
-		Name:     body.Name,
-		Message:  body.Message,
-	})
-	writeJSON(rw, map[string]string{"status": "ok"})
+	layout, exposed := g.OneLayout, g.ExposedOne
+	if body.Team == TeamTwo {
+		layout, exposed = g.TwoLayout, g.ExposedTwo
+	}
+	writeJSON(rw, struct {
+		Index   int   `json:"index"`
+		Color   Color `json:"color"`
+		Exposed bool  `json:"exposed"`
+	}{body.Index, layout[body.Index], exposed[body.Index]})
 }
 
-// POST /events
-func (h *handler) handleEvents(rw http.ResponseWriter, req *http.Request) {
+// POST /find-word
+// This endpoint looks up a word on the board, ignoring case, and
+// returns its index along with its color in the layout of the
+// requesting team and whether that cell has been revealed. Like
+// /cell, it won't look up a layout for a player outside its team
+// while the game is in progress. It fails with word_not_found if
+// the word isn't on the board.
+func (h *handler) handleFindWord(rw http.ResponseWriter, req *http.Request) {
 	var body struct {
-		GameID    string `json:"game_id"`
-		Seed      Seed   `json:"seed"`
-		PlayerID  string `json:"player_id"`
-		Name      string `json:"name"`
-		Team      int    `json:"team"`
-		LastEvent int    `json:"last_event"`
+		GameID   string `json:"game_id"`
+		PlayerID string `json:"player_id"`
+		Team     int    `json:"team"`
+		Word     string `json:"word"`
 	}
 
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" || body.PlayerID == "" {
+	if err := decodeBody(req, &body); err != nil {
 		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
 		return
 	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
+	if !validPlayerID(body.PlayerID) {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"player_id": "required"}, 400)
+		return
+	}
+	word := normalizeWord(body.Word)
+	if word == "" {
+		writeFieldError(rw, "malformed_body", "Unable to parse request body.",
+			map[string]string{"word": "required"}, 400)
+		return
+	}
+	if !validTeam(body.Team) {
+		writeFieldError(rw, "bad_team", "Team must be 1 or 2.",
+			map[string]string{"team": "must be 1 or 2"}, 422)
+		return
+	}
 
-	h.mu.Lock()
-	g, ok := h.games[body.GameID]
-	h.mu.Unlock()
+	g, ok := h.games.Get(body.GameID)
 	if !ok {
 		writeError(rw, "not_found", "Game not found", 404)
 		return
 	}
-
-	g.mu.Lock()
-	seed := g.Seed
-	if body.Seed != seed {
-		evts, _ := g.eventsSince(body.LastEvent)
-		g.mu.Unlock()
-		writeJSON(rw, GameUpdate{Seed: seed, Events: evts})
+	if !authorize(rw, req, g, false) {
 		return
 	}
-	g.markSeen(body.PlayerID, body.Name, body.Team, time.Now())
-
-	evts, ch := g.eventsSince(body.LastEvent)
 
-	// Release the mutex.
-	// We reacquire it when we reretrieve the game.
-	g.mu.Unlock()
-
-	if len(evts) > 0 {
-		writeJSON(rw, GameUpdate{Seed: seed, Events: evts})
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch g.layoutHidden(body.PlayerID, body.Team) {
+	case "wrong_team":
+		writeError(rw, "wrong_team", "Player belongs to a different team.", 403)
+		return
+	case "not_on_team":
+		writeError(rw, "not_on_team", "Player hasn't joined the team.", 403)
 		return
 	}
 
-	// Wait until a new event becomes available, the client
-	// gives up, or we time out.
-	select {
-	case <-ch:
-		// re-retrieve the game in case it was replaced
-		// while we were waiting for events.
-		h.mu.Lock()
-		g, ok := h.games[body.GameID]
-		h.mu.Unlock()
-		if !ok {
-			writeError(rw, "not_found", "Game not found", 404)
+	layout, exposed := g.OneLayout, g.ExposedOne
+	if body.Team == TeamTwo {
+		layout, exposed = g.TwoLayout, g.ExposedTwo
+	}
+	for i, w := range g.Words {
+		if strings.EqualFold(w, word) {
+			writeJSON(rw, struct {
+				Index   int   `json:"index"`
+				Color   Color `json:"color"`
+				Exposed bool  `json:"exposed"`
+			}{i, layout[i], exposed[i]})
 			return
 		}
-		g.mu.Lock()
-		evts, _ = g.eventsSince(body.LastEvent)
-		seed = g.Seed
-		g.mu.Unlock()
-
-	case <-req.Context().Done():
-	case <-time.After(25 * time.Second):
 	}
-	writeJSON(rw, GameUpdate{Seed: seed, Events: evts})
+	writeError(rw, "word_not_found", "The word isn't on the board.", 404)
 }
 
-// POST /ping
-// This endpoint is a convenient way to record updates to player config
-// without waiting for the long-polling loop to make a new request.
-// It only calls `markSeen` with the provided player information
-// and has no other effects.
-func (h *handler) handlePing(rw http.ResponseWriter, req *http.Request) {
+// POST /verify-board
+// This endpoint checks a board computed by a client against the
+// game's board, for settling disputes about what the board was.
+// The board is drawn again from the game's stored state, exactly
+// as it was when the game was created, and compared with the
+// client's seed, word set hash, words and layouts. The response
+// reports whether they match and, if not, the first difference.
+// Until the game is over, a difference in a layout is reported
+// without its cell or colors.
+func (h *handler) handleVerifyBoard(rw http.ResponseWriter, req *http.Request) {
 	var body struct {
-		GameID   string `json:"game_id"`
-		Seed     Seed   `json:"seed"`
-		PlayerID string `json:"player_id"`
-		Name     string `json:"name"`
-		Team     int    `json:"team"`
+		GameID      string   `json:"game_id"`
+		Seed        Seed     `json:"seed"`
+		WordSetHash string   `json:"word_set_hash"`
+		Words       []string `json:"words"`
+		OneLayout   []Color  `json:"one_layout"`
+		TwoLayout   []Color  `json:"two_layout"`
 	}
-
-	err := json.NewDecoder(req.Body).Decode(&body)
-	if err != nil || body.GameID == "" || body.PlayerID == "" {
+	if err := decodeBody(req, &body); err != nil {
 		writeError(rw, "malformed_body", "Unable to parse request body.", 400)
 		return
 	}
+	if body.GameID == "" {
+		writeFieldError(rw, "missing_game_id", "The request must include a game_id.",
+			map[string]string{"game_id": "required"}, 400)
+		return
+	}
 
-	h.mu.Lock()
-	g, ok := h.games[body.GameID]
-	h.mu.Unlock()
+	g, ok := h.games.Get(body.GameID)
 	if !ok {
 		writeError(rw, "not_found", "Game not found", 404)
 		return
 	}
-	if body.Seed != g.Seed {
-		writeError(rw, "bad_seed", "Request intended for a different game seed.", 400)
+	if !authorize(rw, req, g, false) {
 		return
 	}
-
 	g.mu.Lock()
-	g.markSeen(body.PlayerID, body.Name, body.Team, time.Now())
+	drawn, err := rewind(&g.GameState, 0)
+	over := g.status() != ""
 	g.mu.Unlock()
-	writeJSON(rw, map[string]string{"status": "ok"})
-}
+	if err != nil {
+		writeError(rw, "internal_error", fmt.Sprintf("Unable to draw the board: %s.", err), 500)
+		return
+	}
 
-type GameUpdate struct {
-	Seed   Seed    `json:"seed"`
-	Events []Event `json:"events"`
+	type verifyResponse struct {
+		Match    bool           `json:"match"`
+		Mismatch *BoardMismatch `json:"mismatch,omitempty"`
+	}
+	switch {
+	case body.Seed != drawn.Seed:
+		writeJSON(rw, verifyResponse{Mismatch: &BoardMismatch{Field: "seed", Index: -1,
+			Server: strconv.FormatInt(int64(drawn.Seed), 10), Client: strconv.FormatInt(int64(body.Seed), 10)}})
+		return
+	case body.WordSetHash != drawn.WordSetHash:
+		writeJSON(rw, verifyResponse{Mismatch: &BoardMismatch{Field: "word_set_hash", Index: -1,
+			Server: drawn.WordSetHash, Client: body.WordSetHash}})
+		return
+	}
+
+	mismatch := drawn.compareBoard(body.Words, body.OneLayout, body.TwoLayout)
+	if mismatch != nil && mismatch.Field != "words" && !over {
+		// The layouts are hidden until the game is over. Reporting
+		// where they differ, let alone the server's colors, would
+		// let a client recover them a cell at a time.
+		mismatch = &BoardMismatch{Field: mismatch.Field, Index: -1}
+	}
+	writeJSON(rw, verifyResponse{Match: mismatch == nil, Mismatch: mismatch})
 }
 
+// GET /stats
+// This endpoint counts the games with players and their players.
+// Players are also counted by team, with those who haven't picked a
+// team yet counted as unassigned.
 func (h *handler) handleStats(rw http.ResponseWriter, req *http.Request) {
-	var players, games int
-	h.mu.Lock()
-	for _, g := range h.games {
+	var players, games, one, two, unassigned int
+	h.games.Range(func(id string, g *Game) bool {
 		g.mu.Lock()
-		players += len(g.players)
-		if len(g.players) > 0 {
+		players += len(g.Players)
+		if len(g.Players) > 0 {
 			games++
 		}
+		one += g.teamSize(TeamOne)
+		two += g.teamSize(TeamTwo)
+		unassigned += g.teamSize(NoTeam)
 		g.mu.Unlock()
+		return true
+	})
+
+	type teamPlayers struct {
+		One        int `json:"one"`
+		Two        int `json:"two"`
+		Unassigned int `json:"unassigned"`
 	}
-	h.mu.Unlock()
+	writeJSON(rw, struct {
+		ActiveGames   int         `json:"active_games"`
+		ActivePlayers int         `json:"active_players"`
+		TeamPlayers   teamPlayers `json:"team_players"`
+	}{ActiveGames: games, ActivePlayers: players, TeamPlayers: teamPlayers{one, two, unassigned}})
+}
 
+// GET /version
+// This endpoint describes the server, so that reports of problems
+// can say which build they were seen on. It doesn't look at any
+// games.
+func (h *handler) handleVersion(rw http.ResponseWriter, req *http.Request) {
 	writeJSON(rw, struct {
-		ActiveGames   int `json:"active_games"`
-		ActivePlayers int `json:"active_players"`
-	}{ActiveGames: games, ActivePlayers: players})
+		Version       string    `json:"version"`
+		GoVersion     string    `json:"go_version"`
+		StartedAt     time.Time `json:"started_at"`
+		UptimeSeconds int64     `json:"uptime_seconds"`
+		WordLists     []string  `json:"word_lists"`
+	}{
+		Version:       h.version,
+		GoVersion:     runtime.Version(),
+		StartedAt:     h.started,
+		UptimeSeconds: int64(h.now().Sub(h.started).Seconds()),
+		WordLists:     h.wordLists.names(),
+	})
+}
+
+// errorResponse is the body of every error response.
+//
+// Requests that can't be parsed, or that are missing a required
+// field, fail with status 400. Requests that parse but whose values
+// are invalid, such as an unknown team, fail with status 422.
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Fields maps the names of request fields that failed
+	// validation to a description of the problem.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// RequestID is the ID of the request, as in the response's
+	// X-Request-ID header.
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// maxRequestIDLength bounds the length of a request ID supplied
+// by a client.
+const maxRequestIDLength = 64
+
+// requestID returns the request's X-Request-ID header if it's
+// a reasonable ID, and otherwise a new random ID.
+func requestID(req *http.Request) string {
+	id := req.Header.Get("X-Request-ID")
+	valid := id != "" && len(id) <= maxRequestIDLength
+	for _, r := range id {
+		if !strings.ContainsRune("-_.:", r) && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && !('0' <= r && r <= '9') {
+			valid = false
+		}
+	}
+	if valid {
+		return id
+	}
+
+	var b [16]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		// Fall back to the less random math/rand, since an ID
+		// is still better than none.
+		binary.LittleEndian.PutUint64(b[:], rand.Uint64())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// requestIDOf returns the ID that ServeHTTP assigned to the request
+// that rw responds to.
+func requestIDOf(rw http.ResponseWriter) string {
+	return rw.Header().Get("X-Request-ID")
+}
+
+// handleNotFound responds to any request that doesn't match
+// one of the registered routes.
+func handleNotFound(rw http.ResponseWriter, req *http.Request) {
+	writeError(rw, "not_found", "No such endpoint.", 404)
+}
+
+// decodeBody decodes the JSON request body into v. An empty
+// body is treated the same as an empty JSON object, so that
+// missing fields are reported as such.
+func decodeBody(req *http.Request, v interface{}) error {
+	err := json.NewDecoder(req.Body).Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+	return err
 }
 
 func writeError(rw http.ResponseWriter, code, message string, statusCode int) {
-	rw.WriteHeader(statusCode)
-	writeJSON(rw, struct {
-		Code    string `json:"code"`
-		Message string `json:"message"`
-	}{Code: code, Message: message})
+	writeFieldError(rw, code, message, nil, statusCode)
+}
+
+// writeFieldError is like writeError, but also reports which
+// request fields failed validation.
+func writeFieldError(rw http.ResponseWriter, code, message string, fields map[string]string, statusCode int) {
+	id := requestIDOf(rw)
+	if statusCode >= 500 {
+		log.Printf("request %s: %s: %s", id, code, message)
+	}
+	writeJSONStatus(rw, errorResponse{Code: code, Message: message, Fields: fields, RequestID: id}, statusCode)
 }
 
 func writeJSON(rw http.ResponseWriter, resp interface{}) {
-	j, err := json.Marshal(resp)
-	if err != nil {
-		http.Error(rw, "unable to marshal response: "+err.Error(), 500)
-		return
+	writeJSONStatus(rw, resp, http.StatusOK)
+}
+
+// jsonBuffers pools the buffers that responses are encoded into,
+// so that each poll doesn't allocate a fresh one.
+var jsonBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledJSONBuffer is the capacity beyond which a buffer isn't
+// returned to jsonBuffers, so that a rare large response, such as
+// an export, isn't held onto.
+const maxPooledJSONBuffer = 64 << 10
+
+func writeJSONStatus(rw http.ResponseWriter, resp interface{}, statusCode int) {
+	buf := jsonBuffers.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledJSONBuffer {
+			jsonBuffers.Put(buf)
+		}
+	}()
+
+	if err := json.NewEncoder(buf).Encode(resp); err != nil {
+		statusCode = http.StatusInternalServerError
+		buf.Reset()
+		json.NewEncoder(buf).Encode(errorResponse{
+			Code:      "internal_error",
+			Message:   "Unable to marshal response: " + err.Error(),
+			RequestID: requestIDOf(rw),
+		})
 	}
+	// Unlike json.Marshal, Encode ends the value with a newline.
+	j := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
 
+	// The headers must be set before the status code is written.
 	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
 	rw.Write(j)
 }
 
+// DefaultWordlists loads the word lists in the wordlists
+// directory. Lists that fail to load are logged and skipped, but
+// the "green" list is required.
 func DefaultWordlists() (map[string][]string, error) {
-	matches, err := filepath.Glob("wordlists/*txt")
+	lists, skipped, err := LoadWordlists("wordlists", "green")
+	for name, err := range skipped {
+		log.Printf("skipping word list %q: %s", name, err)
+	}
+	return lists, err
+}
+
+// LoadWordlists loads each of the .txt files in dir as a word
+// list named after the file. A list that fails to load, or that
+// has no words, is skipped rather than failing the others, and
+// the reason is recorded in skipped. It returns an error if no
+// lists load, or if any of the required lists are skipped.
+func LoadWordlists(dir string, required ...string) (lists map[string][]string, skipped map[string]error, err error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.txt"))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	lists := map[string][]string{}
+	lists = map[string][]string{}
+	skipped = map[string]error{}
 	for _, m := range matches {
 		base := filepath.Base(m)
 		name := strings.TrimSuffix(base, filepath.Ext(base))
 
-		d, err := dictionary.Load(m)
+		words, err := loadWordlistFile(m)
 		if err != nil {
-			return nil, err
+			skipped[name] = err
+			continue
+		}
+		if len(words) == 0 {
+			skipped[name] = errors.New("no words")
+			continue
 		}
-		words := d.Words()
-		sort.Strings(words)
 		lists[name] = words
 	}
-	return lists, nil
+
+	if len(lists) == 0 {
+		return nil, skipped, fmt.Errorf("no word lists loaded from %s", dir)
+	}
+	for _, name := range required {
+		if _, ok := lists[name]; !ok {
+			return nil, skipped, fmt.Errorf("required word list %q didn't load", name)
+		}
+	}
+	return lists, skipped, nil
+}
+
+// loadWordlistFile parses the word list in the file at path. Like
+// lists loaded from a URL, it may start with a byte order mark.
+func loadWordlistFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseWordlist(f)
 }
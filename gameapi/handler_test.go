@@ -0,0 +1,3094 @@
+package gameapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// post issues a JSON POST request against h and returns the recorded
+// response.
+func post(h http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	b, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	if strings.HasPrefix(path, "/admin/") {
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw
+}
+
+// testAdminToken is the admin token of handlers in tests that use
+// the admin endpoints. post passes it to them.
+const testAdminToken = "admin-secret"
+
+// adminRequest returns a request to an admin endpoint that carries
+// testAdminToken.
+func adminRequest(method, path string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
+// newTestGame creates a new game with the provided ID and returns its seed.
+func newTestGame(t *testing.T, h http.Handler, id string) string {
+	t.Helper()
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": id})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		State struct {
+			Seed string `json:"seed"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.State.Seed
+}
+
+// mustGet returns the game stored by h with the provided ID.
+func mustGet(t *testing.T, h http.Handler, id string) *Game {
+	t.Helper()
+	g, ok := h.(*handler).games.Get(id)
+	if !ok {
+		t.Fatalf("game %q not found", id)
+	}
+	return g
+}
+
+func errorCode(t *testing.T, rw *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unable to decode error body %q: %s", rw.Body, err)
+	}
+	return resp.Code
+}
+
+func TestGuessBadTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+
+	for _, team := range []int{-1, 0, 3, 5} {
+		rw := post(h, "/guess", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      team,
+			"index":     0,
+		})
+		if rw.Code != 422 || errorCode(t, rw) != "bad_team" {
+			t.Errorf("guess with team %d = %d %s, want 422 bad_team", team, rw.Code, rw.Body)
+		}
+	}
+
+	rw := post(h, "/guess", map[string]interface{}{
+		"game_id":   "foo",
+		"seed":      seed,
+		"player_id": "alice",
+		"team":      TeamOne,
+		"index":     green,
+	})
+	if rw.Code != 200 {
+		t.Errorf("guess with team %d = %d, want 200", TeamOne, rw.Code)
+	}
+}
+
+func TestPingBadTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+
+	for team, want := range map[int]int{NoTeam: 200, TeamOne: 200, 3: 422, -2: 422} {
+		rw := post(h, "/ping", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      team,
+		})
+		if rw.Code != want {
+			t.Errorf("ping with team %d = %d, want %d", team, rw.Code, want)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	post(h, "/ping", map[string]interface{}{
+		"game_id":   "foo",
+		"seed":      seed,
+		"player_id": "alice",
+		"team":      TeamOne,
+	})
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, adminRequest("GET", "/admin/export?game_id=foo"))
+	if rw.Code != 200 {
+		t.Fatalf("GET /admin/export = %d, want 200", rw.Code)
+	}
+	var state struct {
+		Seed    string            `json:"seed"`
+		WordSet []string          `json:"word_set"`
+		Players map[string]Player `json:"players"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Seed != seed {
+		t.Errorf("exported seed = %q, want %q", state.Seed, seed)
+	}
+	if len(state.WordSet) != len(exampleWords) {
+		t.Errorf("len(word_set) = %d, want %d", len(state.WordSet), len(exampleWords))
+	}
+	if state.Players["alice"].Team != TeamOne {
+		t.Errorf("exported players = %v, want alice on team %d", state.Players, TeamOne)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, adminRequest("GET", "/admin/export?game_id=bar"))
+	if rw.Code != 404 {
+		t.Errorf("GET /admin/export for missing game = %d, want 404", rw.Code)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	export := func(h http.Handler, auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/export?game_id=foo", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// Without a token, the admin endpoints don't exist.
+	h := Handler(map[string][]string{"example": exampleWords})
+	newTestGame(t, h, "foo")
+	if rw := export(h, "Bearer "); rw.Code != 404 || errorCode(t, rw) != "not_found" {
+		t.Errorf("GET /admin/export without an admin token = %d %s, want 404 not_found", rw.Code, rw.Body)
+	}
+
+	h = Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	newTestGame(t, h, "foo")
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken, "Basic " + testAdminToken} {
+		if rw := export(h, auth); rw.Code != 401 || errorCode(t, rw) != "bad_admin_token" {
+			t.Errorf("GET /admin/export with Authorization %q = %d %s, want 401 bad_admin_token", auth, rw.Code, rw.Body)
+		}
+	}
+	rw := export(h, "Bearer "+testAdminToken)
+	if rw.Code != 200 {
+		t.Fatalf("GET /admin/export with the admin token = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// Web pages can't call the admin endpoints.
+	if got := rw.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("GET /admin/export Access-Control-Allow-Origin = %q, want none", got)
+	}
+	if got := post(h, "/stats", nil).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("POST /stats Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestImport(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, adminRequest("GET", "/admin/export?game_id=foo"))
+	var state map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	other := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "foo", "state": state})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/import = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := newTestGame(t, other, "foo"); got != seed {
+		t.Errorf("imported game seed = %q, want %q", got, seed)
+	}
+
+	// Importing over an existing game requires force.
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "foo", "state": state})
+	if rw.Code != 409 {
+		t.Errorf("POST /admin/import over existing game = %d, want 409", rw.Code)
+	}
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "foo", "state": state, "force": true})
+	if rw.Code != 200 {
+		t.Errorf("POST /admin/import with force = %d, want 200", rw.Code)
+	}
+
+	// Inconsistent states are rejected.
+	bad := map[string]interface{}{}
+	for k, v := range state {
+		bad[k] = v
+	}
+	bad["word_set"] = exampleWords[:10]
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "bar", "state": bad})
+	if rw.Code != 400 || errorCode(t, rw) != "bad_state" {
+		t.Errorf("POST /admin/import with short word set = %d %s, want 400 bad_state", rw.Code, rw.Body)
+	}
+	bad["word_set"] = exampleWords
+	bad["seed"] = "not-a-number"
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "bar", "state": bad})
+	if rw.Code != 400 {
+		t.Errorf("POST /admin/import with bad seed = %d, want 400", rw.Code)
+	}
+	bad["seed"] = seed
+	bad["events"] = []Event{{Number: 1, Type: "guess", Team: TeamOne, Index: 25}}
+	rw = post(other, "/admin/import", map[string]interface{}{"game_id": "bar", "state": bad})
+	if rw.Code != 400 || errorCode(t, rw) != "bad_state" {
+		t.Errorf("POST /admin/import with bad index = %d %s, want 400 bad_state", rw.Code, rw.Body)
+	}
+}
+
+func TestRename(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+	events := len(mustGet(t, h, "foo").Events)
+	newTestGame(t, h, "bar")
+
+	rw := post(h, "/admin/rename", map[string]interface{}{"from": "foo", "to": "bar"})
+	if rw.Code != 409 || errorCode(t, rw) != "game_exists" {
+		t.Errorf("POST /admin/rename onto an existing game = %d %s, want 409 game_exists", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "bar"); len(g.Events) != 0 {
+		t.Errorf("renaming onto an existing game replaced it")
+	}
+
+	rw = post(h, "/admin/rename", map[string]interface{}{"from": "foo", "to": "baz"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/rename = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "baz")
+	if fmt.Sprint(int64(g.Seed)) != seed || len(g.Events) != events || g.Players["alice"].Team != TeamOne {
+		t.Errorf("renamed game = seed %d, %d events, players %v, want the original game", g.Seed, len(g.Events), g.Players)
+	}
+	rw = post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": 1})
+	if rw.Code != 404 {
+		t.Errorf("POST /guess under the old ID = %d, want 404", rw.Code)
+	}
+
+	rw = post(h, "/admin/rename", map[string]interface{}{"from": "foo", "to": "qux"})
+	if rw.Code != 404 {
+		t.Errorf("POST /admin/rename of a missing game = %d, want 404", rw.Code)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+
+	for path, want := range map[string]int{
+		"/no-such-endpoint": 404,
+		"/guess":            400,
+	} {
+		rw := post(h, path, map[string]interface{}{})
+		if rw.Code != want {
+			t.Errorf("POST %s = %d, want %d", path, rw.Code, want)
+		}
+		if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("POST %s Content-Type = %q, want application/json", path, ct)
+		}
+		if errorCode(t, rw) == "" {
+			t.Errorf("POST %s body = %s, want an error code", path, rw.Body)
+		}
+	}
+
+	// Responses that can't be marshalled are reported as JSON, too.
+	rw := httptest.NewRecorder()
+	writeJSON(rw, func() {})
+	if rw.Code != 500 {
+		t.Errorf("writeJSON(func) = %d, want 500", rw.Code)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("writeJSON(func) Content-Type = %q, want application/json", ct)
+	}
+	if code := errorCode(t, rw); code != "internal_error" {
+		t.Errorf("writeJSON(func) code = %q, want internal_error", code)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+
+	for path, want := range map[string]int{
+		"/guess": 200,
+		"/gues":  404,
+	} {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("OPTIONS", path, nil))
+		if rw.Code != want {
+			t.Errorf("OPTIONS %s = %d, want %d", path, rw.Code, want)
+		}
+		if origin := rw.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+			t.Errorf("OPTIONS %s Access-Control-Allow-Origin = %q, want *", path, origin)
+		}
+	}
+
+	// The actual request carries the CORS headers too.
+	rw := post(h, "/gues", map[string]interface{}{})
+	if origin := rw.Header().Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("POST /gues Access-Control-Allow-Origin = %q, want *", origin)
+	}
+}
+
+func TestGameOmitsWordSet(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+
+	var resp struct {
+		Words []string               `json:"words"`
+		State map[string]interface{} `json:"state"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.State["word_set"]; ok {
+		t.Errorf("game response includes word_set: %s", rw.Body)
+	}
+	if len(resp.Words) != len(colorDistribution) {
+		t.Errorf("len(words) = %d, want %d", len(resp.Words), len(colorDistribution))
+	}
+
+	// The full word set is still available for reconstruction.
+	g := mustGet(t, h, "foo")
+	if len(g.WordSet) != len(exampleWords) {
+		t.Errorf("len(WordSet) = %d, want %d", len(g.WordSet), len(exampleWords))
+	}
+}
+
+func TestMissingGameID(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+
+	for _, path := range []string{"/new-game", "/guess", "/end-turn", "/chat", "/events", "/ping"} {
+		for _, body := range []string{"", "{}", `{"player_id": "alice"}`} {
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest("POST", path, strings.NewReader(body)))
+			if rw.Code != 400 || errorCode(t, rw) != "missing_game_id" {
+				t.Errorf("POST %s %q = %d %s, want 400 missing_game_id", path, body, rw.Code, rw.Body)
+			}
+		}
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("POST", path, strings.NewReader("{")))
+		if rw.Code != 400 || errorCode(t, rw) != "malformed_body" {
+			t.Errorf("POST %s with broken JSON = %d %s, want 400 malformed_body", path, rw.Code, rw.Body)
+		}
+	}
+}
+
+func TestWordLists(t *testing.T) {
+	h := Handler(map[string][]string{
+		"animals": exampleWords[:30],
+		"example": exampleWords,
+	}, WithWordlistMetadata(map[string]WordlistMetadata{
+		"animals": {Label: "Animals", Category: "kids"},
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/word-lists", nil))
+	var resp struct {
+		WordLists []struct {
+			Name     string `json:"name"`
+			Label    string `json:"label"`
+			Category string `json:"category"`
+			Count    int    `json:"count"`
+		} `json:"word_lists"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.WordLists) != 2 {
+		t.Fatalf("GET /word-lists = %s, want 2 lists", rw.Body)
+	}
+	if l := resp.WordLists[0]; l.Name != "animals" || l.Label != "Animals" || l.Category != "kids" || l.Count != 30 {
+		t.Errorf("GET /word-lists animals = %+v", l)
+	}
+	if l := resp.WordLists[1]; l.Name != "example" || l.Label != "example" || l.Category != "" {
+		t.Errorf("GET /word-lists example = %+v, want label falling back to the name", l)
+	}
+}
+
+func TestWordList(t *testing.T) {
+	h := Handler(map[string][]string{"animals": exampleWords[:30]})
+	get := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+	var resp struct {
+		Name  string   `json:"name"`
+		Words []string `json:"words"`
+	}
+
+	rw := get("/word-lists/animals", nil)
+	if rw.Code != 200 {
+		t.Fatalf("GET /word-lists/animals = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != "animals" || !reflect.DeepEqual(resp.Words, exampleWords[:30]) {
+		t.Errorf("GET /word-lists/animals = %s, want the list's words", rw.Body)
+	}
+	if rw.Header().Get("Cache-Control") == "" {
+		t.Error("GET /word-lists/animals has no Cache-Control header")
+	}
+
+	etag := rw.Header().Get("ETag")
+	if rw := get("/word-lists/animals", map[string]string{"If-None-Match": etag}); rw.Code != 304 {
+		t.Errorf("GET /word-lists/animals with a matching ETag = %d, want 304", rw.Code)
+	}
+
+	rw = get("/word-lists/animals", map[string]string{"Accept-Encoding": "gzip"})
+	if rw.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("GET /word-lists/animals accepting gzip has Content-Encoding %q", rw.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(rw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(gz).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp.Words, exampleWords[:30]) {
+		t.Errorf("gzipped GET /word-lists/animals words = %v", resp.Words)
+	}
+
+	rw = get("/word-lists/plants", nil)
+	if rw.Code != 404 || errorCode(t, rw) != "unknown_word_list" {
+		t.Errorf("GET /word-lists/plants = %d %s, want 404 unknown_word_list", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameSourceLists(t *testing.T) {
+	h := Handler(map[string][]string{
+		"one": exampleWords[:100],
+		"two": exampleWords[100:],
+	})
+
+	for _, tc := range []struct {
+		body map[string]interface{}
+		want []string
+	}{
+		{map[string]interface{}{"game_id": "a"}, []string{"one", "two"}},
+		{map[string]interface{}{"game_id": "b", "word_lists": []string{"two"}}, []string{"two"}},
+		{map[string]interface{}{"game_id": "c", "words": exampleWords[:30]}, []string{}},
+	} {
+		rw := post(h, "/new-game", tc.body)
+		var resp struct {
+			State struct {
+				SourceLists []string `json:"source_lists"`
+			} `json:"state"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resp.State.SourceLists, tc.want) {
+			t.Errorf("POST /new-game %v source_lists = %v, want %v", tc.body, resp.State.SourceLists, tc.want)
+		}
+
+		id := tc.body["game_id"].(string)
+		g := mustGet(t, h, id)
+		if got := mustReconstruct(t, g.GameState).SourceLists; !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("reconstructed source lists = %v, want %v", got, tc.want)
+		}
+	}
+
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "d", "word_lists": []string{"three"}})
+	if rw.Code != 400 || errorCode(t, rw) != "unknown_word_list" {
+		t.Errorf("POST /new-game with unknown list = %d %s, want 400 unknown_word_list", rw.Code, rw.Body)
+	}
+	if !strings.Contains(rw.Body.String(), `Invalid word lists: unknown word list \"three\".`) {
+		t.Errorf("POST /new-game with unknown list = %s, want the list named in the message", rw.Body)
+	}
+}
+
+func TestGuessWrongTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	guess := func(team, index int) *httptest.ResponseRecorder {
+		return post(h, "/guess", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      team,
+			"index":     index,
+		})
+	}
+
+	// The first guess establishes the player's team, and ends the
+	// turn so that team two may guess next.
+	tan := indexOf(mustGet(t, h, "foo").TwoLayout, Tan, func(int) bool { return false })
+	if rw := guess(TeamOne, tan); rw.Code != 200 {
+		t.Fatalf("first guess = %d, want 200", rw.Code)
+	}
+	if rw := guess(TeamTwo, (tan+1)%25); rw.Code != 403 || errorCode(t, rw) != "wrong_team" {
+		t.Errorf("guess for other team = %d %s, want 403 wrong_team", rw.Code, rw.Body)
+	}
+
+	// Switching teams explicitly is still allowed.
+	post(h, "/switch-team", map[string]interface{}{
+		"game_id":   "foo",
+		"seed":      seed,
+		"player_id": "alice",
+		"team":      TeamTwo,
+	})
+	if rw := guess(TeamTwo, (tan+1)%25); rw.Code != 200 {
+		t.Errorf("guess after switching teams = %d %s, want 200", rw.Code, rw.Body)
+	}
+}
+
+func TestGuessNotYourTurn(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	request := func(path, player string, team int, extra map[string]interface{}) *httptest.ResponseRecorder {
+		body := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return post(h, path, body)
+	}
+
+	if g := mustGet(t, h, "foo"); g.ActiveTeam != TeamOne || g.Phase != PhaseClue {
+		t.Fatalf("new game has active team %d in phase %q, want team one in phase %q", g.ActiveTeam, g.Phase, PhaseClue)
+	}
+	if rw := request("/guess", "bob", TeamTwo, map[string]interface{}{"index": 0}); rw.Code != 409 || errorCode(t, rw) != "not_your_turn" {
+		t.Errorf("team two guessing = %d %s, want 409 not_your_turn", rw.Code, rw.Body)
+	}
+	if rw := request("/clue", "bob", TeamTwo, map[string]interface{}{"word": "fruit", "count": 2}); rw.Code != 200 {
+		t.Fatalf("POST /clue = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "foo"); g.Phase != PhaseGuess {
+		t.Errorf("phase after clue = %q, want %q", g.Phase, PhaseGuess)
+	}
+	if rw := request("/guess", "bob", TeamTwo, map[string]interface{}{"index": 0}); rw.Code != 409 || errorCode(t, rw) != "not_your_turn" {
+		t.Errorf("team two guessing after clue = %d %s, want 409 not_your_turn", rw.Code, rw.Body)
+	}
+
+	// Once team one's turn ends, the roles swap.
+	if rw := request("/end-turn", "alice", TeamOne, nil); rw.Code != 200 {
+		t.Fatalf("POST /end-turn = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "foo"); g.ActiveTeam != TeamTwo || g.Phase != PhaseClue {
+		t.Errorf("after end of turn, active team %d in phase %q, want team two in phase %q", g.ActiveTeam, g.Phase, PhaseClue)
+	}
+	if rw := request("/guess", "alice", TeamOne, map[string]interface{}{"index": 0}); rw.Code != 409 || errorCode(t, rw) != "not_your_turn" {
+		t.Errorf("team one guessing = %d %s, want 409 not_your_turn", rw.Code, rw.Body)
+	}
+	green := indexOf(mustGet(t, h, "foo").OneLayout, Green, func(int) bool { return false })
+	if rw := request("/guess", "bob", TeamTwo, map[string]interface{}{"index": green}); rw.Code != 200 {
+		t.Errorf("team two guessing = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestGuessAfterAssassin(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	g := mustGet(t, h, "foo")
+	never := func(int) bool { return false }
+	guess := func(player string, team, index int) *httptest.ResponseRecorder {
+		return post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team, "index": index})
+	}
+	events := func(player string, team int) GameUpdate {
+		rw := post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team})
+		var update GameUpdate
+		if err := json.Unmarshal(rw.Body.Bytes(), &update); err != nil || rw.Code != 200 {
+			t.Fatalf("POST /events = %d %s: %v", rw.Code, rw.Body, err)
+		}
+		return update
+	}
+
+	if update := events("bob", TeamTwo); update.Final != nil {
+		t.Errorf("in-progress game's update reveals the board: %+v", update.Final)
+	}
+	if rw := guess("alice", TeamOne, indexOf(g.TwoLayout, Black, never)); rw.Code != 200 {
+		t.Fatalf("guessing a black = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// Every player sees the whole board.
+	for _, team := range []int{TeamOne, TeamTwo} {
+		update := events("player"+fmt.Sprint(team), team)
+		if update.Status != StatusLostAssassin || update.Final == nil || update.Final.OutcomeReason != OutcomeAssassin {
+			t.Fatalf("team %d update has status %q with final board %+v, want %q with the board", team, update.Status, update.Final, StatusLostAssassin)
+		}
+		for i, c := range update.Final.Cells {
+			if c.One != g.OneLayout[i] || c.Two != g.TwoLayout[i] {
+				t.Errorf("team %d final cell %d = %+v, want colors %s and %s", team, i, c, g.OneLayout[i], g.TwoLayout[i])
+				break
+			}
+		}
+	}
+
+	// No more guesses are accepted, from either team.
+	if rw := guess("alice", TeamOne, indexOf(g.TwoLayout, Green, never)); rw.Code != 409 || errorCode(t, rw) != "game_over" {
+		t.Errorf("team one guessing after the assassin = %d %s, want 409 game_over", rw.Code, rw.Body)
+	}
+	if rw := guess("bob", TeamTwo, indexOf(g.OneLayout, Green, never)); rw.Code != 409 || errorCode(t, rw) != "game_over" {
+		t.Errorf("team two guessing after the assassin = %d %s, want 409 game_over", rw.Code, rw.Body)
+	}
+	if n := len(mustGet(t, h, "foo").ExposedTwoIndices); n != 1 {
+		t.Errorf("after the assassin, %d cells of team two's layout exposed, want 1", n)
+	}
+}
+
+func TestSwitchTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMaxPlayersPerTeam(1))
+	seed := newTestGame(t, h, "foo")
+	request := func(path, player string, team int) *httptest.ResponseRecorder {
+		return post(h, path, map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": player,
+			"team":      team,
+		})
+	}
+	team := func(player string) int {
+		return mustGet(t, h, "foo").Players[player].Team
+	}
+
+	request("/ping", "alice", TeamOne)
+	request("/ping", "bob", TeamTwo)
+
+	// A stray team in a heartbeat doesn't move an established player.
+	if rw := request("/ping", "alice", TeamTwo); rw.Code != 200 {
+		t.Errorf("ping with another team = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := team("alice"); got != TeamOne {
+		t.Errorf("alice's team after a stray ping = %d, want %d", got, TeamOne)
+	}
+
+	if rw := request("/switch-team", "alice", 3); rw.Code != 422 || errorCode(t, rw) != "bad_team" {
+		t.Errorf("POST /switch-team to team 3 = %d %s, want 422 bad_team", rw.Code, rw.Body)
+	}
+	if rw := request("/switch-team", "alice", TeamTwo); rw.Code != 409 || errorCode(t, rw) != "team_full" {
+		t.Errorf("POST /switch-team to a full team = %d %s, want 409 team_full", rw.Code, rw.Body)
+	}
+	request("/switch-team", "bob", TeamOne)
+	if got := team("bob"); got != TeamTwo {
+		t.Errorf("bob's team after switching to a full team = %d, want %d", got, TeamTwo)
+	}
+
+	h = Handler(map[string][]string{"example": exampleWords})
+	seed = newTestGame(t, h, "foo")
+	request("/ping", "alice", TeamOne)
+	if rw := request("/switch-team", "alice", TeamTwo); rw.Code != 200 {
+		t.Fatalf("POST /switch-team = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	if got := g.Players["alice"].Team; got != TeamTwo {
+		t.Errorf("alice's team after switching = %d, want %d", got, TeamTwo)
+	}
+	if last := g.Events[len(g.Events)-1]; last.Type != "join_side" || last.Team != TeamTwo {
+		t.Errorf("last event = %+v, want join_side for team two", last)
+	}
+}
+
+func TestGuessIdempotencyKey(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	guess := func(key string, index int) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      TeamOne,
+			"index":     index,
+		})
+		req := httptest.NewRequest("POST", "/guess", bytes.NewReader(b))
+		req.Header.Set("Idempotency-Key", key)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+	guesses := func() (n int) {
+		for _, e := range mustGet(t, h, "foo").Events {
+			if e.Type == "guess" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Guess greens, so that team one keeps guessing.
+	g := mustGet(t, h, "foo")
+	first := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+	second := indexOf(g.TwoLayout, Green, func(i int) bool { return i == first })
+	guess("abc", first)
+	if rw := guess("abc", second); rw.Code != 200 {
+		t.Errorf("retried guess = %d, want 200", rw.Code)
+	}
+	if n := guesses(); n != 1 {
+		t.Errorf("guesses after retry = %d, want 1", n)
+	}
+	guess("def", second)
+	if n := guesses(); n != 2 {
+		t.Errorf("guesses after new key = %d, want 2", n)
+	}
+}
+
+// recordingStore wraps a Store, recording the IDs of games put.
+type recordingStore struct {
+	Store
+	puts []string
+}
+
+func (s *recordingStore) Put(id string, g *Game) {
+	s.puts = append(s.puts, id)
+	s.Store.Put(id, g)
+}
+
+func TestWithStore(t *testing.T) {
+	store := &recordingStore{Store: NewMemoryStore()}
+	h := Handler(map[string][]string{"example": exampleWords}, WithStore(store), WithAdminToken(testAdminToken))
+	newTestGame(t, h, "foo")
+	newTestGame(t, h, "bar")
+
+	if !reflect.DeepEqual(store.puts, []string{"foo", "bar"}) {
+		t.Errorf("store puts = %v, want [foo bar]", store.puts)
+	}
+	if g, ok := store.Get("foo"); !ok || len(g.Words) != len(colorDistribution) {
+		t.Errorf("store.Get(foo) = %v, %t, want the new game", g, ok)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, adminRequest("GET", "/admin/export?game_id=baz"))
+	if rw.Code != 404 {
+		t.Errorf("GET /admin/export for missing game = %d, want 404", rw.Code)
+	}
+
+	deleted := store.Prune(func(id string, g *Game) bool { return id == "foo" })
+	if _, ok := store.Get("foo"); ok || deleted != 1 {
+		t.Errorf("Prune deleted %d games, foo still present = %t", deleted, ok)
+	}
+}
+
+func TestNewGameBalanced(t *testing.T) {
+	big, small := exampleWords[:300], exampleWords[300:330]
+	h := Handler(map[string][]string{"big": big, "small": small})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "word_lists": []string{"big", "small"}, "balanced": true})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	inSmall := map[string]bool{}
+	for _, w := range small {
+		inSmall[w] = true
+	}
+	n := 0
+	for _, w := range g.Words {
+		if inSmall[w] {
+			n++
+		}
+	}
+	if n != 12 && n != 13 {
+		t.Errorf("balanced board has %d words from the small list, want 12 or 13", n)
+	}
+
+	// The board is reproducible from its state.
+	again, err := ReconstructGame(*g.GameState.persisted().state())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Words, g.Words) {
+		t.Errorf("reconstructed words = %v, want %v", again.Words, g.Words)
+	}
+
+	// The request's own words aren't from any list to balance.
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "words": exampleWords, "balanced": true})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_balanced" {
+		t.Errorf("POST /new-game with balanced words = %d %s, want 422 bad_balanced", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameAutoEndTurn(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	for auto, wantManual := range map[bool]bool{true: false, false: true} {
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "auto_end_turn": auto})
+		if rw.Code != 200 {
+			t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+		}
+		if g := mustGet(t, h, "foo"); g.ManualEndTurn != wantManual {
+			t.Errorf("auto_end_turn %t: ManualEndTurn = %t, want %t", auto, g.ManualEndTurn, wantManual)
+		}
+		h.(*handler).games.Delete("foo")
+	}
+}
+
+func TestNewGameRoster(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithPruneTicks(make(chan time.Time)),
+		WithMaxPlayersPerTeam(1))
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "players": map[string]int{"alice": TeamOne, "bob": TeamTwo}})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	want := map[string]Player{"alice": {Team: TeamOne, LastSeen: now}, "bob": {Team: TeamTwo, LastSeen: now}}
+	if !reflect.DeepEqual(g.Players, want) {
+		t.Errorf("players = %v, want %v", g.Players, want)
+	}
+
+	// Players that never check in are pruned.
+	h.(*handler).prune(now.Add(time.Minute))
+	if n := len(mustGet(t, h, "foo").Players); n != 0 {
+		t.Errorf("%d players after pruning, want 0", n)
+	}
+
+	testCases := map[string]struct {
+		players map[string]int
+		code    string
+	}{
+		"bad team":  {map[string]int{"alice": 3}, "bad_team"},
+		"empty ID":  {map[string]int{"": TeamOne}, "bad_player_id"},
+		"team full": {map[string]int{"alice": TeamOne, "bob": TeamOne}, "team_full"},
+	}
+	for name, tc := range testCases {
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": "bar", "players": tc.players})
+		if rw.Code != 422 || errorCode(t, rw) != tc.code {
+			t.Errorf("%s: POST /new-game = %d %s, want 422 %s", name, rw.Code, rw.Body, tc.code)
+		}
+	}
+}
+
+func TestResetGracePeriod(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithResetGracePeriod(time.Minute))
+	seed := newTestGame(t, h, "foo")
+	before := mustGet(t, h, "foo")
+	reset := map[string]interface{}{"game_id": "foo", "prev_seed": seed}
+	poll := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "last_event": 1 << 20}
+
+	// The reset is pending until the grace period has passed.
+	rw := post(h, "/new-game", reset)
+	if rw.Code != 202 {
+		t.Fatalf("POST /new-game resetting = %d, want 202: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	if g != before || g.ResetAt == nil || !g.ResetAt.Equal(now.Add(time.Minute)) {
+		t.Fatalf("after requesting a reset, game = %p reset at %v, want the same game reset at %s", g, g.ResetAt, now.Add(time.Minute))
+	}
+	if e := g.Events[len(g.Events)-1]; e.Type != "reset_pending" {
+		t.Errorf("last event = %+v, want reset_pending", e)
+	}
+	if rw := post(h, "/new-game", reset); rw.Code != 409 || errorCode(t, rw) != "reset_pending" {
+		t.Errorf("POST /new-game resetting again = %d %s, want 409 reset_pending", rw.Code, rw.Body)
+	}
+
+	now = now.Add(time.Minute)
+	post(h, "/events", poll)
+	if g := mustGet(t, h, "foo"); g == before || fmt.Sprint(int64(g.Seed)) == seed {
+		t.Fatal("game wasn't reset after the grace period")
+	}
+
+	// A cancelled reset never takes effect.
+	seed = fmt.Sprint(int64(mustGet(t, h, "foo").Seed))
+	before = mustGet(t, h, "foo")
+	cancel := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "bob"}
+	if rw := post(h, "/cancel-reset", cancel); rw.Code != 409 || errorCode(t, rw) != "no_pending_reset" {
+		t.Errorf("POST /cancel-reset without a pending reset = %d %s, want 409 no_pending_reset", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": seed}); rw.Code != 202 {
+		t.Fatalf("POST /new-game resetting = %d, want 202: %s", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/cancel-reset", cancel); rw.Code != 200 {
+		t.Fatalf("POST /cancel-reset = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	now = now.Add(time.Hour)
+	h.(*handler).prune(now)
+	if g := mustGet(t, h, "foo"); g != before || g.ResetAt != nil {
+		t.Error("cancelled reset took effect")
+	}
+	if e := before.Events[len(before.Events)-1]; e.Type != "reset_cancelled" || e.PlayerID != "bob" {
+		t.Errorf("last event = %+v, want reset_cancelled by bob", e)
+	}
+}
+
+func TestFreezeWithResetPending(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken),
+		WithClock(func() time.Time { return now }),
+		WithResetGracePeriod(time.Minute))
+	seed := newTestGame(t, h, "foo")
+	before := mustGet(t, h, "foo")
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": seed}); rw.Code != 202 {
+		t.Fatalf("POST /new-game resetting = %d, want 202: %s", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/admin/freeze", map[string]interface{}{"game_id": "foo"}); rw.Code != 200 {
+		t.Fatalf("POST /admin/freeze = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "foo"); g.ResetAt != nil {
+		t.Errorf("frozen game still resets at %s", g.ResetAt)
+	}
+	cancel := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "bob"}
+	if rw := post(h, "/cancel-reset", cancel); rw.Code != 423 || errorCode(t, rw) != "game_frozen" {
+		t.Errorf("POST /cancel-reset on a frozen game = %d %s, want 423 game_frozen", rw.Code, rw.Body)
+	}
+
+	// The reset never replaces the frozen game.
+	now = now.Add(time.Hour)
+	h.(*handler).applyDueResets()
+	if g := mustGet(t, h, "foo"); g != before || !g.Frozen {
+		t.Errorf("after the grace period, game = %p frozen %t, want the same frozen game", g, g.Frozen)
+	}
+}
+
+func TestRenameWithResetPending(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken),
+		WithResetGracePeriod(20*time.Millisecond))
+	seed := newTestGame(t, h, "foo")
+	before := mustGet(t, h, "foo")
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": seed}); rw.Code != 202 {
+		t.Fatalf("POST /new-game resetting = %d, want 202: %s", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/admin/rename", map[string]interface{}{"from": "foo", "to": "bar"}); rw.Code != 200 {
+		t.Fatalf("POST /admin/rename = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// The reset follows the game to its new ID.
+	deadline := time.Now().Add(5 * time.Second)
+	for mustGet(t, h, "bar") == before {
+		if time.Now().After(deadline) {
+			t.Fatal("pending reset didn't replace the renamed game")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReshuffleWithResetPending(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithResetGracePeriod(time.Minute))
+	seed := newTestGame(t, h, "foo")
+	before := mustGet(t, h, "foo")
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": seed}); rw.Code != 202 {
+		t.Fatalf("POST /new-game resetting = %d, want 202: %s", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/reshuffle-layout", map[string]interface{}{"game_id": "foo", "seed": seed}); rw.Code != 200 {
+		t.Fatalf("POST /reshuffle-layout = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if e := before.Events[len(before.Events)-1]; e.Type != "reset_cancelled" || before.ResetAt != nil {
+		t.Errorf("last event of the reshuffled game = %+v, want reset_cancelled", e)
+	}
+
+	// The cancelled reset never replaces the reshuffled game.
+	reshuffled := mustGet(t, h, "foo")
+	now = now.Add(time.Hour)
+	h.(*handler).applyDueResets()
+	if g := mustGet(t, h, "foo"); g != reshuffled || g.ResetAt != nil {
+		t.Errorf("after the grace period, game = %p resetting at %v, want the reshuffled game", g, g.ResetAt)
+	}
+}
+
+func TestGameRotation(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithGameRotation(10*time.Minute))
+	newGame := func(id string) string {
+		t.Helper()
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": id})
+		if rw.Code != 200 {
+			t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+		}
+		return fmt.Sprint(int64(mustGet(t, h, id).Seed))
+	}
+	never := func(int) bool { return false }
+
+	// A finished game is kept while anyone is still in it...
+	seed := newTestGame(t, h, "foo")
+	post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	black := indexOf(mustGet(t, h, "foo").TwoLayout, Black, never)
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": black})
+	if got := newGame("foo"); got != seed {
+		t.Errorf("finished game with players was replaced with seed %s", got)
+	}
+	// ...and replaced once they've all left.
+	now = now.Add(time.Minute)
+	h.(*handler).prune(now)
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "reset": false}); rw.Code != 200 || fmt.Sprint(int64(mustGet(t, h, "foo").Seed)) != seed {
+		t.Errorf("joining finished game = %d, want the existing game: %s", rw.Code, rw.Body)
+	}
+	if got := newGame("foo"); got == seed {
+		t.Error("finished game wasn't replaced after its players left")
+	}
+
+	// An active game is only replaced once it's been idle long
+	// enough.
+	seed = newTestGame(t, h, "bar")
+	post(h, "/events", map[string]interface{}{"game_id": "bar", "seed": seed, "player_id": "alice", "team": TeamOne})
+	if got := newGame("bar"); got != seed {
+		t.Errorf("active game was replaced with seed %s", got)
+	}
+	now = now.Add(time.Minute)
+	h.(*handler).prune(now)
+	now = now.Add(9 * time.Minute)
+	if got := newGame("bar"); got != seed {
+		t.Errorf("game idle for 9 minutes was replaced with seed %s", got)
+	}
+	now = now.Add(time.Minute)
+	if got := newGame("bar"); got == seed {
+		t.Error("game idle for 10 minutes wasn't replaced")
+	}
+}
+
+func TestEndTurn(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	endTurn := func(team int) (endTurnResponse, *httptest.ResponseRecorder) {
+		rw := post(h, "/end-turn", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": team})
+		var resp endTurnResponse
+		if rw.Code == 200 {
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, rw
+	}
+
+	if _, rw := endTurn(TeamTwo); rw.Code != 409 || errorCode(t, rw) != "not_your_turn" {
+		t.Errorf("team two ending team one's turn = %d %s, want 409 not_your_turn", rw.Code, rw.Body)
+	}
+	resp, rw := endTurn(TeamOne)
+	if rw.Code != 200 {
+		t.Fatalf("POST /end-turn = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	want := endTurnResponse{
+		Status:     "ok",
+		Seed:       mustGet(t, h, "foo").Seed,
+		Event:      len(mustGet(t, h, "foo").Events),
+		Turn:       2,
+		ActiveTeam: TeamTwo,
+		Phase:      PhaseClue,
+		TokensLeft: timerTokens - 1,
+		GameStatus: StatusInProgress,
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("POST /end-turn = %+v, want %+v", resp, want)
+	}
+
+	// Passing every turn runs out the timer.
+	for team := TeamTwo; resp.TokensLeft > 0; team = otherTeam(team) {
+		if resp, rw = endTurn(team); rw.Code != 200 {
+			t.Fatalf("POST /end-turn for team %d = %d, want 200: %s", team, rw.Code, rw.Body)
+		}
+	}
+	if resp.GameStatus != StatusLostTime || resp.OutcomeReason != OutcomeNoTokens {
+		t.Errorf("out of tokens, status = %q with outcome %q, want %q with outcome %q", resp.GameStatus, resp.OutcomeReason, StatusLostTime, OutcomeNoTokens)
+	}
+	if _, rw := endTurn(resp.ActiveTeam); rw.Code != 409 || errorCode(t, rw) != "game_over" {
+		t.Errorf("POST /end-turn after the game ended = %d %s, want 409 game_over", rw.Code, rw.Body)
+	}
+}
+
+func TestNoTurnsLeft(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "team_turns": 1})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	seed := fmt.Sprint(int64(mustGet(t, h, "foo").Seed))
+	turn := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne}
+	if rw := post(h, "/end-turn", turn); rw.Code != 200 {
+		t.Fatalf("POST /end-turn = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := post(h, "/end-turn", turn); rw.Code != 409 || errorCode(t, rw) != "no_turns_left" {
+		t.Errorf("POST /end-turn without turns left = %d %s, want 409 no_turns_left", rw.Code, rw.Body)
+	}
+	turn["index"] = 0
+	if rw := post(h, "/guess", turn); rw.Code != 409 || errorCode(t, rw) != "no_turns_left" {
+		t.Errorf("POST /guess without turns left = %d %s, want 409 no_turns_left", rw.Code, rw.Body)
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "team_turns": -1})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_team_turns" {
+		t.Errorf("POST /new-game with negative team_turns = %d %s, want 422 bad_team_turns", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameTimerTokens(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "timer_tokens": 4})
+	var resp Game
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil || rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d %s: %v", rw.Code, rw.Body, err)
+	}
+	if resp.TimerTokens != 4 || resp.TokensLeft != 4 {
+		t.Errorf("timer tokens = %d with %d left, want 4 with 4 left", resp.TimerTokens, resp.TokensLeft)
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "timer_tokens": -1})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_timer_tokens" {
+		t.Errorf("POST /new-game with negative timer_tokens = %d %s, want 422 bad_timer_tokens", rw.Code, rw.Body)
+	}
+}
+
+func TestSuddenDeathRestrictions(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "timer_tokens": 1, "sudden_death": true})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	seed := fmt.Sprint(int64(g.Seed))
+	request := func(path, player string, team int, extra map[string]interface{}) *httptest.ResponseRecorder {
+		body := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team}
+		for k, v := range extra {
+			body[k] = v
+		}
+		return post(h, path, body)
+	}
+	if rw := request("/end-turn", "alice", TeamOne, nil); rw.Code != 200 {
+		t.Fatalf("POST /end-turn = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "foo"); g.Phase != PhaseSuddenDeath || g.Status != StatusInProgress {
+		t.Fatalf("out of tokens, phase = %q with status %q, want %q with status %q", g.Phase, g.Status, PhaseSuddenDeath, StatusInProgress)
+	}
+
+	if rw := request("/clue", "alice", TeamOne, map[string]interface{}{"word": "fruit", "count": 1}); rw.Code != 409 || errorCode(t, rw) != "sudden_death" {
+		t.Errorf("POST /clue in sudden death = %d %s, want 409 sudden_death", rw.Code, rw.Body)
+	}
+	if rw := request("/end-turn", "bob", TeamTwo, nil); rw.Code != 409 || errorCode(t, rw) != "sudden_death" {
+		t.Errorf("POST /end-turn in sudden death = %d %s, want 409 sudden_death", rw.Code, rw.Body)
+	}
+	never := func(int) bool { return false }
+	for _, team := range []int{TeamOne, TeamTwo} {
+		layout := g.TwoLayout
+		if team == TeamTwo {
+			layout = g.OneLayout
+		}
+		rw := request("/guess", "player"+fmt.Sprint(team), team, map[string]interface{}{"index": indexOf(layout, Green, never)})
+		if rw.Code != 200 {
+			t.Errorf("team %d guessing in sudden death = %d, want 200: %s", team, rw.Code, rw.Body)
+		}
+	}
+}
+
+func TestNewGameDryRun(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice"})
+	before := mustGet(t, h, "foo")
+
+	for _, body := range []map[string]interface{}{
+		{"game_id": "foo", "prev_seed": seed, "dry_run": true, "min_len": 4},
+		{"game_id": "foo", "dry_run": true},
+		{"game_id": "bar", "dry_run": true, "blacks": 5},
+	} {
+		rw := post(h, "/new-game", body)
+		if rw.Code != 200 {
+			t.Fatalf("POST /new-game %v = %d, want 200: %s", body, rw.Code, rw.Body)
+		}
+		var g Game
+		if err := json.Unmarshal(rw.Body.Bytes(), &g); err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Words) != len(colorDistribution) || len(g.Players) != 0 {
+			t.Errorf("POST /new-game %v = %s, want a new board without players", body, rw.Body)
+		}
+	}
+
+	if g := mustGet(t, h, "foo"); g != before || len(g.Players) != 1 {
+		t.Error("dry run replaced the existing game")
+	}
+	if _, ok := h.(*handler).games.Get("bar"); ok {
+		t.Error("dry run stored a new game")
+	}
+
+	// Confirming the dry run with its seed creates the board it
+	// previewed.
+	body := map[string]interface{}{"game_id": "foo", "prev_seed": seed, "dry_run": true, "avoid_previous": true}
+	rw := post(h, "/new-game", body)
+	var preview Game
+	if err := json.Unmarshal(rw.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	body["dry_run"], body["board_seed"] = false, fmt.Sprint(int64(preview.Seed))
+	if rw := post(h, "/new-game", body); rw.Code != 200 {
+		t.Fatalf("POST /new-game %v = %d, want 200: %s", body, rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	if g.Seed != preview.Seed || !reflect.DeepEqual(g.Words, preview.Words) ||
+		!reflect.DeepEqual(g.OneLayout, preview.OneLayout) || !reflect.DeepEqual(g.TwoLayout, preview.TwoLayout) ||
+		g.ActiveTeam != preview.ActiveTeam {
+		t.Errorf("confirmed board = %v %v %v, want the preview %v %v %v",
+			g.Words, g.OneLayout, g.TwoLayout, preview.Words, preview.OneLayout, preview.TwoLayout)
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "board_seed": "x"})
+	if rw.Code != 400 || errorCode(t, rw) != "bad_board_seed" {
+		t.Errorf("POST /new-game with a bad board_seed = %d %s, want 400 bad_board_seed", rw.Code, rw.Body)
+	}
+
+	// Only seeds issued by dry runs are accepted, each only once,
+	// so a reset can't keep the game's seed or pick its own.
+	current := fmt.Sprint(int64(g.Seed))
+	for _, body := range []map[string]interface{}{
+		{"game_id": "foo", "prev_seed": current, "board_seed": current},
+		{"game_id": "foo", "prev_seed": current, "board_seed": "12345"},
+		{"game_id": "bar", "board_seed": current},
+		{"game_id": "bar", "board_seed": current, "dry_run": true},
+	} {
+		if rw := post(h, "/new-game", body); rw.Code != 422 || errorCode(t, rw) != "bad_board_seed" {
+			t.Errorf("POST /new-game %v = %d %s, want 422 bad_board_seed", body, rw.Code, rw.Body)
+		}
+	}
+	if g := mustGet(t, h, "foo"); g.Seed != preview.Seed {
+		t.Errorf("game seed = %d after rejected board seeds, want %d", g.Seed, preview.Seed)
+	}
+}
+
+func TestMinGameIDLength(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMinGameIDLength(8))
+	for _, id := range []string{"foo", "a-b-c-d-e-f-g", "ééééééééé"} {
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": id})
+		if rw.Code != 422 || errorCode(t, rw) != "weak_game_id" {
+			t.Errorf("POST /new-game with ID %q = %d %s, want 422 weak_game_id", id, rw.Code, rw.Body)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/game-id", nil))
+	var resp struct {
+		GameID string `json:"game_id"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GameID) != gameIDLength || strings.Trim(resp.GameID, gameIDAlphabet) != "" {
+		t.Errorf("GET /game-id = %q, want %d characters from %q", resp.GameID, gameIDLength, gameIDAlphabet)
+	}
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": resp.GameID}); rw.Code != 200 {
+		t.Errorf("POST /new-game with a generated ID = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords, "other": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithVersion("v1.2.3"))
+	now = now.Add(90 * time.Second)
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/version", nil))
+	if rw.Code != 200 {
+		t.Fatalf("GET /version = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Version       string   `json:"version"`
+		GoVersion     string   `json:"go_version"`
+		UptimeSeconds int64    `json:"uptime_seconds"`
+		WordLists     []string `json:"word_lists"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "v1.2.3" || resp.GoVersion != runtime.Version() || resp.UptimeSeconds != 90 {
+		t.Errorf("GET /version = %s, want version v1.2.3 up for 90 seconds", rw.Body)
+	}
+	if want := []string{"example", "other"}; !reflect.DeepEqual(resp.WordLists, want) {
+		t.Errorf("word lists = %v, want %v", resp.WordLists, want)
+	}
+}
+
+func TestUnassignedPlayers(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMaxPlayersPerTeam(1))
+	seed := newTestGame(t, h, "foo")
+	ping := func(player string, team int) *httptest.ResponseRecorder {
+		return post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team})
+	}
+	ping("carol", NoTeam)
+	ping("dave", NoTeam)
+	if rw := ping("alice", TeamOne); rw.Code != 200 {
+		t.Fatalf("POST /ping for team one = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := ping("bob", TeamTwo); rw.Code != 200 {
+		t.Errorf("POST /ping for team two alongside unassigned players = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/stats", nil))
+	var stats struct {
+		TeamPlayers map[string]int `json:"team_players"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"one": 1, "two": 1, "unassigned": 2}; !reflect.DeepEqual(stats.TeamPlayers, want) {
+		t.Errorf("GET /stats team players = %v, want %v", stats.TeamPlayers, want)
+	}
+
+	b, err := json.Marshal(mustGet(t, h, "foo").Players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var players map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &players); err != nil {
+		t.Fatal(err)
+	}
+	if players["carol"]["unassigned"] != true || players["alice"]["unassigned"] != nil {
+		t.Errorf("players = %s, want only carol and dave unassigned", b)
+	}
+}
+
+func TestNewPlayerID(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/new-player-id", nil))
+	var resp struct {
+		PlayerID string `json:"player_id"`
+		Name     string `json:"name"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.PlayerID) != gameIDLength || strings.Trim(resp.PlayerID, gameIDAlphabet) != "" || resp.Name == "" {
+		t.Errorf("GET /new-player-id = %s, want an ID of %d characters from %q and a name", rw.Body, gameIDLength, gameIDAlphabet)
+	}
+	seed := newTestGame(t, h, "foo")
+	if rw := post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": resp.PlayerID, "name": resp.Name}); rw.Code != 200 {
+		t.Errorf("POST /ping with a generated player ID = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// Generated IDs that players couldn't use are refused.
+	for _, tc := range []struct {
+		id   string
+		want int
+	}{{"alice", 200}, {"", 500}} {
+		h := Handler(map[string][]string{"example": exampleWords},
+			WithPlayerIDGenerator(func() (string, error) { return tc.id, nil }))
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/new-player-id", nil))
+		if rw.Code != tc.want {
+			t.Errorf("GET /new-player-id generating %q = %d %s, want %d", tc.id, rw.Code, rw.Body, tc.want)
+		}
+	}
+}
+
+func TestPruneGames(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithPruneTicks(make(chan time.Time)))
+	seed := newTestGame(t, h, "foo")
+	post(h, "/ping", map[string]interface{}{
+		"game_id":   "foo",
+		"seed":      seed,
+		"player_id": "alice",
+	})
+
+	// The player times out, but the game is kept until it's
+	// 24 hours old.
+	now = now.Add(time.Hour)
+	h.(*handler).prune(now)
+	g := mustGet(t, h, "foo")
+	if len(g.Players) != 0 {
+		t.Errorf("players after timeout = %v, want none", g.Players)
+	}
+
+	now = now.Add(gameTTL)
+	h.(*handler).prune(now)
+	if _, ok := h.(*handler).games.Get("foo"); ok {
+		t.Error("game wasn't pruned after its TTL")
+	}
+}
+
+func TestPruneObserver(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var sweeps []PruneStats
+	var logged bytes.Buffer
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithPruneTicks(make(chan time.Time)),
+		WithPruneObserver(func(s PruneStats) { sweeps = append(sweeps, s) }),
+		WithVerboseLog(log.New(&logged, "", 0)))
+	for _, id := range []string{"foo", "bar"} {
+		seed := newTestGame(t, h, id)
+		post(h, "/ping", map[string]interface{}{"game_id": id, "seed": seed, "player_id": "alice"})
+	}
+
+	h.(*handler).prune(now)
+	now = now.Add(time.Hour)
+	h.(*handler).prune(now)
+	now = now.Add(gameTTL)
+	h.(*handler).prune(now)
+
+	if len(sweeps) != 3 {
+		t.Fatalf("observed %d sweeps, want 3", len(sweeps))
+	}
+	for i, want := range []PruneStats{{}, {PlayersPruned: 2}, {GamesDeleted: 2}} {
+		got := sweeps[i]
+		if got.PlayersPruned != want.PlayersPruned || got.GamesDeleted != want.GamesDeleted {
+			t.Errorf("sweep %d pruned %d players and %d games, want %d and %d",
+				i, got.PlayersPruned, got.GamesDeleted, want.PlayersPruned, want.GamesDeleted)
+		}
+		if got.LockHeld > got.Duration {
+			t.Errorf("sweep %d held the lock for %s of %s", i, got.LockHeld, got.Duration)
+		}
+	}
+	if n := strings.Count(logged.String(), "\n"); n != 3 || !strings.Contains(logged.String(), "pruned 2 players and 0 games") {
+		t.Errorf("logged %q, want a line for each sweep", logged.String())
+	}
+}
+
+func TestPruneFinishedGames(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithPruneTicks(make(chan time.Time)),
+		WithGameTTLs(48*time.Hour, 2*time.Hour))
+	newTestGame(t, h, "finished")
+	newTestGame(t, h, "playing")
+
+	g := mustGet(t, h, "finished")
+	g.mu.Lock()
+	g.addEvent(Event{Type: "guess", Team: TeamOne, Index: indexOf(g.TwoLayout, Black, func(int) bool { return false })})
+	g.checkFinished("alice", TeamOne, now)
+	g.mu.Unlock()
+
+	now = now.Add(time.Hour)
+	h.(*handler).prune(now)
+	if _, ok := h.(*handler).games.Get("finished"); !ok {
+		t.Error("finished game was pruned before its TTL")
+	}
+
+	now = now.Add(2 * time.Hour)
+	h.(*handler).prune(now)
+	if _, ok := h.(*handler).games.Get("finished"); ok {
+		t.Error("finished game wasn't pruned after its TTL")
+	}
+	if _, ok := h.(*handler).games.Get("playing"); !ok {
+		t.Error("game in progress was pruned with the finished game")
+	}
+
+	now = now.Add(48 * time.Hour)
+	h.(*handler).prune(now)
+	if _, ok := h.(*handler).games.Get("playing"); ok {
+		t.Error("game in progress wasn't pruned after its TTL")
+	}
+}
+
+func TestPruneEmptiedGames(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithClock(func() time.Time { return now }),
+		WithPruneTicks(make(chan time.Time)),
+		WithEmptyGameTTL(10*time.Minute))
+	seed := newTestGame(t, h, "foo")
+
+	// A player stays in the game for longer than gameTTL.
+	for end := now.Add(gameTTL + time.Hour); now.Before(end); now = now.Add(30 * time.Second) {
+		post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice"})
+	}
+	h.(*handler).prune(now)
+	if len(mustGet(t, h, "foo").Players) != 1 {
+		t.Fatal("active player was pruned")
+	}
+
+	// Once the player leaves, the game is kept briefly in case
+	// they come back, but not for another gameTTL.
+	now = now.Add(time.Minute)
+	h.(*handler).prune(now)
+	g, ok := h.(*handler).games.Get("foo")
+	if !ok {
+		t.Fatal("game was pruned as soon as its last player left")
+	}
+	if !g.LastEmptyAt.Equal(now) {
+		t.Errorf("LastEmptyAt = %s, want %s", g.LastEmptyAt, now)
+	}
+
+	now = now.Add(10 * time.Minute)
+	h.(*handler).prune(now)
+	if _, ok := h.(*handler).games.Get("foo"); ok {
+		t.Error("game wasn't pruned after its last player left")
+	}
+}
+
+func TestNewGameStartingTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	newGame := func(body map[string]interface{}) (activeTeam int) {
+		rw := post(h, "/new-game", body)
+		var resp struct {
+			ActiveTeam int `json:"active_team"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.ActiveTeam
+	}
+
+	if team := newGame(map[string]interface{}{"game_id": "foo"}); team != TeamOne {
+		t.Errorf("default starting team = %d, want %d", team, TeamOne)
+	}
+	if team := newGame(map[string]interface{}{"game_id": "bar", "starting_team": TeamTwo}); team != TeamTwo {
+		t.Errorf("explicit starting team = %d, want %d", team, TeamTwo)
+	}
+	if g := mustGet(t, h, "bar"); mustReconstruct(t, g.GameState).ActiveTeam != TeamTwo {
+		t.Errorf("reconstructed starting team = %d, want %d", mustReconstruct(t, g.GameState).ActiveTeam, TeamTwo)
+	}
+
+	// A rematch alternates the starting team.
+	seed := mustGet(t, h, "bar").Seed
+	if team := newGame(map[string]interface{}{"game_id": "bar", "prev_seed": seed}); team != TeamOne {
+		t.Errorf("rematch starting team = %d, want %d", team, TeamOne)
+	}
+
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "baz", "starting_team": 3})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_team" {
+		t.Errorf("POST /new-game with starting team 3 = %d %s, want 422 bad_team", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameBadPrevSeed(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	newTestGame(t, h, "foo")
+
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": "not-a-number"})
+	if rw.Code != 400 || errorCode(t, rw) != "bad_prev_seed" {
+		t.Errorf("POST /new-game with non-numeric prev_seed = %d %s, want 400 bad_prev_seed", rw.Code, rw.Body)
+	}
+
+	// A numeric seed that doesn't match returns the existing game.
+	seed := mustGet(t, h, "foo").Seed
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": "1"})
+	if rw.Code != 200 || mustGet(t, h, "foo").Seed != seed {
+		t.Errorf("POST /new-game with mismatched prev_seed = %d, replaced the game", rw.Code)
+	}
+}
+
+func TestNewGameDuplicateWords(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	words := make([]string, 30)
+	for i := range words {
+		words[i] = exampleWords[i%10]
+	}
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words})
+	if rw.Code != 422 || errorCode(t, rw) != "too_few_words" || !strings.Contains(rw.Body.String(), "only 10 are available") {
+		t.Errorf("POST /new-game with duplicate words = %d %s, want 422 too_few_words with 10 available", rw.Code, rw.Body)
+	}
+}
+
+func TestBoardFromShareCode(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+	var created struct {
+		ShareCode string `json:"share_code"`
+		State     struct {
+			WordSetHash string `json:"word_set_hash"`
+		} `json:"state"`
+		Words []string `json:"words"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ShareCode == "" || created.State.WordSetHash == "" {
+		t.Fatalf("POST /new-game = %s, want a share code and word set hash", rw.Body)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/board?code="+created.ShareCode+"&word_set_hash="+created.State.WordSetHash, nil))
+	var preview struct {
+		Words []string `json:"words"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &preview); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(preview.Words, created.Words) {
+		t.Errorf("GET /board words = %v, want %v", preview.Words, created.Words)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/board?code=abc&word_set_hash="+created.State.WordSetHash, nil))
+	if rw.Code != 400 {
+		t.Errorf("GET /board with bad code = %d, want 400", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/board?code="+created.ShareCode, nil))
+	if rw.Code != 400 {
+		t.Errorf("GET /board without a word set hash = %d, want 400", rw.Code)
+	}
+
+	// The same share code previews a different board once the
+	// word list has changed.
+	h = Handler(map[string][]string{"example": exampleWords[1:]})
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/board?code="+created.ShareCode+"&word_set_hash="+created.State.WordSetHash, nil))
+	if rw.Code != 409 || errorCode(t, rw) != "word_set_mismatch" {
+		t.Errorf("GET /board after the word list changed = %d %s, want 409 word_set_mismatch", rw.Code, rw.Body)
+	}
+}
+
+func TestMaxPlayersPerTeam(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMaxPlayersPerTeam(1))
+	seed := newTestGame(t, h, "foo")
+	request := func(path, player string, team int) *httptest.ResponseRecorder {
+		return post(h, path, map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": player,
+			"team":      team,
+			"index":     0,
+		})
+	}
+
+	if rw := request("/ping", "alice", TeamOne); rw.Code != 200 {
+		t.Fatalf("alice joining team one = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	for _, path := range []string{"/ping", "/guess"} {
+		rw := request(path, "bob", TeamOne)
+		if rw.Code != 409 || errorCode(t, rw) != "team_full" {
+			t.Errorf("POST %s as bob on a full team = %d %s, want 409 team_full", path, rw.Code, rw.Body)
+		}
+	}
+	if _, ok := mustGet(t, h, "foo").Players["bob"]; ok {
+		t.Errorf("bob was added to the game despite the full team")
+	}
+
+	// Players on the team may reconnect, and the other team
+	// still has room.
+	if rw := request("/guess", "alice", TeamOne); rw.Code != 200 {
+		t.Errorf("alice reconnecting = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := request("/ping", "bob", TeamTwo); rw.Code != 200 {
+		t.Errorf("bob joining team two = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestGuessCooldown(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords},
+		WithGuessCooldown(time.Second),
+		WithClock(func() time.Time { return now }))
+	seed := newTestGame(t, h, "foo")
+	guess := func(index int) *httptest.ResponseRecorder {
+		return post(h, "/guess", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      TeamOne,
+			"index":     index,
+		})
+	}
+	guesses := func() (n int) {
+		for _, e := range mustGet(t, h, "foo").Events {
+			if e.Type == "guess" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Guess greens, so that team one keeps guessing.
+	g := mustGet(t, h, "foo")
+	first := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+	second := indexOf(g.TwoLayout, Green, func(i int) bool { return i == first })
+	if rw := guess(first); rw.Code != 200 {
+		t.Fatalf("first guess = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := guess(second); rw.Code != 429 || errorCode(t, rw) != "too_fast" {
+		t.Errorf("immediate second guess = %d %s, want 429 too_fast", rw.Code, rw.Body)
+	} else if retry := rw.Header().Get("Retry-After"); retry != "1" {
+		t.Errorf("immediate second guess Retry-After = %q, want 1", retry)
+	}
+	if n := guesses(); n != 1 {
+		t.Errorf("after rapid guesses, %d guess events, want 1", n)
+	}
+
+	now = now.Add(time.Second)
+	if rw := guess(second); rw.Code != 200 {
+		t.Errorf("guess after cooldown = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameIntents(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	newGame := func(body map[string]interface{}) (seed string) {
+		t.Helper()
+		body["game_id"] = "foo"
+		rw := post(h, "/new-game", body)
+		if rw.Code != 200 {
+			t.Fatalf("POST /new-game %v = %d, want 200: %s", body, rw.Code, rw.Body)
+		}
+		var resp struct {
+			State struct {
+				Seed string `json:"seed"`
+			} `json:"state"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.State.Seed
+	}
+
+	// Create.
+	seed := newGame(map[string]interface{}{"reset": false})
+	if seed == "" {
+		t.Fatalf("created game has no seed")
+	}
+
+	// Join, even with the current seed.
+	if got := newGame(map[string]interface{}{}); got != seed {
+		t.Errorf("joining without prev_seed returned seed %q, want %q", got, seed)
+	}
+	if got := newGame(map[string]interface{}{"reset": false, "prev_seed": seed}); got != seed {
+		t.Errorf("joining with reset false returned seed %q, want %q", got, seed)
+	}
+
+	// Reset.
+	if got := newGame(map[string]interface{}{"reset": true, "prev_seed": seed}); got == seed {
+		t.Errorf("resetting returned the existing seed %q", got)
+	}
+}
+
+func TestErrorFields(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+
+	testCases := []struct {
+		path  string
+		body  map[string]interface{}
+		field string
+	}{
+		{"/guess", map[string]interface{}{"player_id": "alice", "team": TeamOne}, "game_id"},
+		{"/guess", map[string]interface{}{"game_id": "foo", "team": TeamOne}, "player_id"},
+		{"/guess", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": 3}, "team"},
+		{"/guess", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamOne, "index": 25}, "index"},
+		{"/guess", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamOne, "seed": "1"}, "seed"},
+		{"/chat", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne}, "message"},
+		{"/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": "x"}, "prev_seed"},
+	}
+	for _, tc := range testCases {
+		rw := post(h, tc.path, tc.body)
+		var resp errorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code == "" || resp.Fields[tc.field] == "" {
+			t.Errorf("POST %s %v = %s, want an error for field %q", tc.path, tc.body, rw.Body, tc.field)
+		}
+	}
+
+	// Errors that aren't about a field omit fields.
+	rw := post(h, "/no-such-endpoint", map[string]interface{}{})
+	if strings.Contains(rw.Body.String(), "fields") {
+		t.Errorf("POST /no-such-endpoint = %s, want no fields", rw.Body)
+	}
+}
+
+func TestRewindEndpoint(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	post(h, "/chat", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "message": "hi"})
+	n := len(mustGet(t, h, "foo").Events)
+
+	rw := post(h, "/admin/rewind", map[string]interface{}{"game_id": "foo", "event": 0})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/rewind = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := len(mustGet(t, h, "foo").Events); got != n {
+		t.Errorf("after rewind without apply, live game has %d events, want %d", got, n)
+	}
+
+	rw = post(h, "/admin/rewind", map[string]interface{}{"game_id": "foo", "event": n + 1})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_event" {
+		t.Errorf("POST /admin/rewind past the end = %d %s, want 422 bad_event", rw.Code, rw.Body)
+	}
+
+	rw = post(h, "/admin/rewind", map[string]interface{}{"game_id": "foo", "event": 0, "apply": true})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/rewind with apply = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := len(mustGet(t, h, "foo").Events); got != 0 {
+		t.Errorf("after rewind with apply, live game has %d events, want 0", got)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	readyz := func() int {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/readyz", nil))
+		return rw.Code
+	}
+	if code := readyz(); code != 200 {
+		t.Errorf("GET /readyz = %d, want 200", code)
+	}
+
+	if rw := post(h, "/admin/maintenance", map[string]interface{}{"enabled": true}); rw.Code != 200 {
+		t.Fatalf("POST /admin/maintenance = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if code := readyz(); code != 503 {
+		t.Errorf("GET /readyz in maintenance = %d, want 503", code)
+	}
+	for _, body := range []map[string]interface{}{
+		{"game_id": "bar"},
+		{"game_id": "foo", "prev_seed": seed},
+	} {
+		if rw := post(h, "/new-game", body); rw.Code != 503 || errorCode(t, rw) != "maintenance" {
+			t.Errorf("POST /new-game %v in maintenance = %d %s, want 503 maintenance", body, rw.Code, rw.Body)
+		}
+	}
+
+	// The existing game carries on.
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"}); rw.Code != 200 {
+		t.Errorf("POST /new-game joining in maintenance = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+	rw := post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+	if rw.Code != 200 {
+		t.Errorf("POST /guess in maintenance = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	post(h, "/admin/maintenance", map[string]interface{}{"enabled": false})
+	if code := readyz(); code != 200 {
+		t.Errorf("GET /readyz after maintenance = %d, want 200", code)
+	}
+	newTestGame(t, h, "bar")
+}
+
+func TestVerifyBoard(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	// Guess a green so that the game isn't over.
+	never := func(int) bool { return false }
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, never)
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+	g := mustGet(t, h, "foo")
+	submission := func() map[string]interface{} {
+		return map[string]interface{}{
+			"game_id": "foo", "seed": seed, "word_set_hash": g.WordSetHash,
+			"words":      append([]string{}, g.Words...),
+			"one_layout": append([]Color{}, g.OneLayout...),
+			"two_layout": append([]Color{}, g.TwoLayout...),
+		}
+	}
+	verify := func(body map[string]interface{}) (resp struct {
+		Match    bool           `json:"match"`
+		Mismatch *BoardMismatch `json:"mismatch"`
+	}) {
+		t.Helper()
+		rw := post(h, "/verify-board", body)
+		if rw.Code != 200 {
+			t.Fatalf("POST /verify-board = %d, want 200: %s", rw.Code, rw.Body)
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := verify(submission()); !resp.Match || resp.Mismatch != nil {
+		t.Errorf("POST /verify-board with the game's board = %+v, want a match", resp)
+	}
+
+	// While the game is in progress, layout mismatches don't
+	// reveal where the layouts differ, or what they hold.
+	corrupted := submission()
+	two := corrupted["two_layout"].([]Color)
+	two[7] = (two[7] + 1) % 3
+	if resp := verify(corrupted); resp.Match || resp.Mismatch == nil || *resp.Mismatch != (BoardMismatch{Field: "two_layout", Index: -1}) {
+		t.Errorf("POST /verify-board with a corrupted layout = %+v, want an unlocated mismatch in two_layout", resp.Mismatch)
+	}
+	probe := submission()
+	probe["one_layout"] = []Color{}
+	if resp := verify(probe); resp.Mismatch == nil || *resp.Mismatch != (BoardMismatch{Field: "one_layout", Index: -1}) {
+		t.Errorf("POST /verify-board with an empty layout = %+v, want an unlocated mismatch in one_layout", resp.Mismatch)
+	}
+
+	corrupted = submission()
+	corrupted["words"] = corrupted["words"].([]string)[:24]
+	if resp := verify(corrupted); resp.Mismatch == nil || resp.Mismatch.Field != "words" || resp.Mismatch.Index != 24 || resp.Mismatch.Client != "" {
+		t.Errorf("POST /verify-board missing a word = %+v, want a mismatch at words[24]", resp.Mismatch)
+	}
+
+	corrupted = submission()
+	corrupted["seed"] = "1"
+	if resp := verify(corrupted); resp.Mismatch == nil || resp.Mismatch.Field != "seed" || resp.Mismatch.Server != seed {
+		t.Errorf("POST /verify-board with the wrong seed = %+v, want a seed mismatch", resp.Mismatch)
+	}
+
+	// Once the game is over, mismatches are reported in full.
+	team, layout := TeamOne, g.TwoLayout
+	if g.ActiveTeam == TeamTwo {
+		team, layout = TeamTwo, g.OneLayout
+	}
+	player := map[int]string{TeamOne: "alice", TeamTwo: "bob"}[team]
+	if g.OutcomeReason == "" {
+		rw := post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team, "index": indexOf(layout, Black, never)})
+		if rw.Code != 200 {
+			t.Fatalf("guessing a black = %d, want 200: %s", rw.Code, rw.Body)
+		}
+	}
+	corrupted = submission()
+	two = corrupted["two_layout"].([]Color)
+	two[7] = (two[7] + 1) % 3
+	want := BoardMismatch{Field: "two_layout", Index: 7, Server: g.TwoLayout[7].String(), Client: two[7].String()}
+	if resp := verify(corrupted); resp.Mismatch == nil || *resp.Mismatch != want {
+		t.Errorf("POST /verify-board with a corrupted layout after the game = %+v, want %+v", resp.Mismatch, want)
+	}
+}
+
+func TestClue(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	clue := func(player string, team int, word string, count int) *httptest.ResponseRecorder {
+		return post(h, "/clue", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": player, "team": team, "word": word, "count": count})
+	}
+
+	if rw := clue("bob", TeamTwo, "", 2); rw.Code != 422 || errorCode(t, rw) != "bad_clue" {
+		t.Errorf("POST /clue without a word = %d %s, want 422 bad_clue", rw.Code, rw.Body)
+	}
+	if rw := clue("bob", TeamTwo, "fruit", maxClueCount+1); rw.Code != 422 || errorCode(t, rw) != "bad_clue" {
+		t.Errorf("POST /clue with too high a count = %d %s, want 422 bad_clue", rw.Code, rw.Body)
+	}
+	// Team one guesses first, so team two gives the first clue.
+	if rw := clue("alice", TeamOne, "fruit", 2); rw.Code != 409 || errorCode(t, rw) != "not_your_turn" {
+		t.Errorf("POST /clue from the guessing team = %d %s, want 409 not_your_turn", rw.Code, rw.Body)
+	}
+	if rw := clue("bob", TeamTwo, " fruit ", 2); rw.Code != 200 {
+		t.Fatalf("POST /clue = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := clue("bob", TeamTwo, "animal", 1); rw.Code != 409 || errorCode(t, rw) != "clue_given" {
+		t.Errorf("POST /clue twice in a turn = %d %s, want 409 clue_given", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	want := Clue{Word: "fruit", Count: 2, Team: TeamTwo, Turn: 1, PlayerID: "bob"}
+	if g.Clue == nil || *g.Clue != want || len(g.Clues) != 1 {
+		t.Fatalf("clue = %+v, clues = %+v, want %+v", g.Clue, g.Clues, want)
+	}
+
+	// The clue only lasts for the turn, but stays in the history.
+	post(h, "/end-turn", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	if rw := clue("alice", TeamOne, "animal", 0); rw.Code != 200 {
+		t.Fatalf("POST /clue in the next turn = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g = mustGet(t, h, "foo")
+	if g.Clue == nil || g.Clue.Word != "animal" || g.Clue.Turn != 2 || len(g.Clues) != 2 {
+		t.Errorf("clue = %+v, clues = %+v, want animal in turn 2 after fruit", g.Clue, g.Clues)
+	}
+	if reconstructed := mustReconstruct(t, g.GameState); !reflect.DeepEqual(reconstructed.Clues, g.Clues) {
+		t.Errorf("reconstructed clues = %+v, want %+v", reconstructed.Clues, g.Clues)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+	guess := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green}
+
+	post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	n := len(mustGet(t, h, "foo").Events)
+
+	rw := post(h, "/admin/freeze", map[string]interface{}{"game_id": "foo"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/freeze = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	rw = post(h, "/guess", guess)
+	if rw.Code != 423 || errorCode(t, rw) != "game_frozen" {
+		t.Errorf("POST /guess on a frozen game = %d %s, want 423 game_frozen", rw.Code, rw.Body)
+	}
+	if got := len(mustGet(t, h, "foo").Events); got != n {
+		t.Errorf("frozen game has %d events, want %d", got, n)
+	}
+
+	// The game can still be viewed, but following it doesn't
+	// join it.
+	rw = post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	if rw.Code != 200 {
+		t.Errorf("POST /events on a frozen game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	rw = post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "bob", "team": TeamTwo})
+	if rw.Code != 200 {
+		t.Errorf("POST /ping on a frozen game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if g := mustGet(t, h, "foo"); len(g.Events) != n || len(g.Players) != 1 {
+		t.Errorf("frozen game has %d events and %d players after /events and /ping, want %d and 1", len(g.Events), len(g.Players), n)
+	}
+	req := httptest.NewRequest("GET", "/summary?game_id=foo", nil)
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != 200 {
+		t.Errorf("GET /summary on a frozen game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	rw = post(h, "/admin/unfreeze", map[string]interface{}{"game_id": "foo"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /admin/unfreeze = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	rw = post(h, "/guess", guess)
+	if rw.Code != 200 {
+		t.Errorf("POST /guess after unfreezing = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	rw = post(h, "/admin/freeze", map[string]interface{}{"game_id": "bar"})
+	if rw.Code != 404 {
+		t.Errorf("POST /admin/freeze for a missing game = %d, want 404", rw.Code)
+	}
+}
+
+func TestGuessDuringReset(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	players := map[int]string{TeamOne: "alice", TeamTwo: "bob"}
+	for i := 0; i < 50; i++ {
+		var guess, reset *httptest.ResponseRecorder
+		var wg sync.WaitGroup
+		// Resets alternate the starting team, so guess for
+		// whichever team's turn it is.
+		g := mustGet(t, h, "foo")
+		team := g.ActiveTeam
+		layout := g.TwoLayout
+		if team == TeamTwo {
+			layout = g.OneLayout
+		}
+		green := indexOf(layout, Green, func(int) bool { return false })
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			guess = post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": players[team], "team": team, "index": green})
+		}()
+		go func() {
+			defer wg.Done()
+			reset = post(h, "/new-game", map[string]interface{}{"game_id": "foo", "prev_seed": seed})
+		}()
+		wg.Wait()
+		if reset.Code != 200 {
+			t.Fatalf("POST /new-game = %d, want 200: %s", reset.Code, reset.Body)
+		}
+
+		// Either the guess was applied to the old board, and
+		// says so, or it was refused.
+		switch guess.Code {
+		case 200:
+			var resp guessResponse
+			if err := json.Unmarshal(guess.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(int64(resp.Seed)) != seed || resp.Event == 0 {
+				t.Errorf("POST /guess = %s, want seed %s and its event", guess.Body, seed)
+			}
+		case 400, 409:
+			if code := errorCode(t, guess); code != "bad_seed" && code != "game_replaced" {
+				t.Errorf("POST /guess = %d %s, want bad_seed or game_replaced", guess.Code, guess.Body)
+			}
+		default:
+			t.Errorf("POST /guess = %d %s", guess.Code, guess.Body)
+		}
+		seed = fmt.Sprint(int64(mustGet(t, h, "foo").Seed))
+	}
+}
+
+func TestGameLog(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	seed := newTestGame(t, h, "foo")
+	rw := post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": "1", "player_id": "alice", "team": TeamOne, "index": 0})
+	if rw.Code != 400 {
+		t.Fatalf("POST /guess with the wrong seed = %d, want 400: %s", rw.Code, rw.Body)
+	}
+	rejectedID := rw.Header().Get("X-Request-ID")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, adminRequest("GET", "/admin/game-log?game_id=foo"))
+	if rw.Code != 200 {
+		t.Fatalf("GET /admin/game-log = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Entries []DebugLogEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Entries) != 2 {
+		t.Fatalf("GET /admin/game-log = %s, want 2 entries", rw.Body)
+	}
+	if e := resp.Entries[0]; e.Kind != debugGuessRejected || e.PlayerID != "alice" || !strings.Contains(e.Detail, "bad_seed") {
+		t.Errorf("first entry = %+v, want a guess rejected for bad_seed", e)
+	}
+	if e := resp.Entries[0]; e.RequestID == "" || e.RequestID != rejectedID {
+		t.Errorf("first entry request ID = %q, want %q", e.RequestID, rejectedID)
+	}
+	if e := resp.Entries[1]; e.Kind != debugGuess {
+		t.Errorf("second entry = %+v, want a guess", e)
+	}
+
+	// The log isn't part of the game sent to players.
+	b, err := json.Marshal(mustGet(t, h, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte(debugGuessRejected)) {
+		t.Errorf("game JSON includes the debug log: %s", b)
+	}
+}
+
+func TestNewGameAvoidWords(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	prev := mustGet(t, h, "foo")
+	avoid := exampleWords[:100]
+
+	rw = post(h, "/new-game", map[string]interface{}{
+		"game_id":        "foo",
+		"prev_seed":      prev.Seed,
+		"avoid_words":    avoid,
+		"avoid_previous": true,
+	})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game avoiding words = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	// The board still names its word lists, but can't be shared by
+	// code, since the code would recreate it from every word.
+	var created struct {
+		ShareCode string `json:"share_code"`
+		State     struct {
+			SourceLists []string `json:"source_lists"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ShareCode != "" || !reflect.DeepEqual(created.State.SourceLists, []string{"example"}) {
+		t.Errorf("POST /new-game avoiding words share code, source lists = %q, %v, want none, [example]",
+			created.ShareCode, created.State.SourceLists)
+	}
+	avoided := map[string]bool{}
+	for _, w := range append(append([]string{}, avoid...), prev.Words...) {
+		avoided[w] = true
+	}
+	for _, w := range mustGet(t, h, "foo").Words {
+		if avoided[w] {
+			t.Errorf("new board contains avoided word %q", w)
+		}
+	}
+
+	// If avoiding the words would leave too few, they're used anyway.
+	words := exampleWords[:30]
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "words": words, "avoid_words": words[:10]})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game avoiding too many words = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := mustGet(t, h, "bar").WordSet; len(got) != len(words) {
+		t.Errorf("word set after avoiding too many words has %d words, want %d", len(got), len(words))
+	}
+}
+
+func TestPlayerNames(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords}, WithClock(func() time.Time { return now }))
+	seed := newTestGame(t, h, "foo")
+	ping := func(name string, team int) {
+		t.Helper()
+		rw := post(h, "/ping", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "p1",
+			"name":      name,
+			"team":      team,
+		})
+		if rw.Code != 200 {
+			t.Fatalf("POST /ping = %d, want 200: %s", rw.Code, rw.Body)
+		}
+	}
+
+	ping("alice", TeamOne)
+	now = now.Add(time.Second)
+	ping(" Alice\x07\n", NoTeam)
+	p := mustGet(t, h, "foo").Players["p1"]
+	if p.Name != "Alice" || p.Team != TeamOne || !p.LastSeen.Equal(now) {
+		t.Errorf("after renaming player = %+v, want Alice on team %d last seen %s", p, TeamOne, now)
+	}
+
+	// Omitting the name keeps the existing one, and long names
+	// are truncated.
+	ping("", NoTeam)
+	if got := mustGet(t, h, "foo").Players["p1"].Name; got != "Alice" {
+		t.Errorf("after ping without a name, name = %q, want %q", got, "Alice")
+	}
+	ping(strings.Repeat("x", 100), NoTeam)
+	if got := mustGet(t, h, "foo").Players["p1"].Name; len(got) != maxNameLength {
+		t.Errorf("after long name, name has length %d, want %d", len(got), maxNameLength)
+	}
+}
+
+func TestChatMessages(t *testing.T) {
+	now := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	h := Handler(map[string][]string{"example": exampleWords}, WithClock(func() time.Time { return now }))
+	seed := newTestGame(t, h, "foo")
+	chat := func(message string) *httptest.ResponseRecorder {
+		return post(h, "/chat", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": "alice",
+			"team":      TeamOne,
+			"message":   message,
+		})
+	}
+
+	if rw := chat("hello"); rw.Code != 200 {
+		t.Fatalf("POST /chat = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := chat("again"); rw.Code != 429 || errorCode(t, rw) != "too_fast" {
+		t.Errorf("immediate second message = %d %s, want 429 too_fast", rw.Code, rw.Body)
+	}
+	now = now.Add(chatCooldown)
+	if rw := chat(strings.Repeat("x", maxChatLength+1)); rw.Code != 422 || errorCode(t, rw) != "message_too_long" {
+		t.Errorf("long message = %d %s, want 422 message_too_long", rw.Code, rw.Body)
+	}
+	if rw := chat("world"); rw.Code != 200 {
+		t.Fatalf("POST /chat after cooldown = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/chat?game_id=foo&since=1", nil))
+	var resp struct {
+		Messages []ChatMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Messages) != 1 || resp.Messages[0].Message != "world" || resp.Messages[0].Number != 2 {
+		t.Errorf("GET /chat since 1 = %s, want only the second message", rw.Body)
+	}
+}
+
+func TestNewGameWordLengths(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+
+	// Each short word has three runes but more than three bytes.
+	var words []string
+	for i := 0; i < 30; i++ {
+		words = append(words, fmt.Sprintf("é%02d", i), fmt.Sprintf("LONGER%02d", i))
+	}
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words, "min_len": 3, "max_len": 3})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game with lengths 3 to 3 = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	for _, w := range mustGet(t, h, "foo").Words {
+		if !strings.HasPrefix(w, "é") {
+			t.Errorf("board with lengths 3 to 3 contains %q", w)
+		}
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "words": words, "min_len": 4, "max_len": 7})
+	if rw.Code != 422 || errorCode(t, rw) != "too_few_words" {
+		t.Errorf("POST /new-game with lengths 4 to 7 = %d %s, want 422 too_few_words", rw.Code, rw.Body)
+	}
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "words": words, "min_len": 8})
+	if rw.Code != 200 {
+		t.Errorf("POST /new-game with min length 8 = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// The board keeps its word lists, but can't be shared by code.
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "qux", "min_len": 4})
+	var created struct {
+		ShareCode string `json:"share_code"`
+		State     struct {
+			SourceLists []string `json:"source_lists"`
+		} `json:"state"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ShareCode != "" || !reflect.DeepEqual(created.State.SourceLists, []string{"example"}) {
+		t.Errorf("POST /new-game with min length 4 share code, source lists = %q, %v, want none, [example]",
+			created.ShareCode, created.State.SourceLists)
+	}
+
+	for _, tc := range []struct {
+		min, max int
+		field    string
+	}{
+		{5, 4, "min_len"},
+		{-1, 0, "min_len"},
+		{0, -1, "max_len"},
+	} {
+		rw = post(h, "/new-game", map[string]interface{}{"game_id": "baz", "min_len": tc.min, "max_len": tc.max})
+		var resp errorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if rw.Code != 422 || resp.Code != "bad_length" || resp.Fields[tc.field] == "" || len(resp.Fields) != 1 {
+			t.Errorf("POST /new-game with lengths %d to %d = %d %s, want 422 bad_length for %s",
+				tc.min, tc.max, rw.Code, rw.Body, tc.field)
+		}
+	}
+}
+
+func TestUnicodeWords(t *testing.T) {
+	var words []string
+	for _, base := range []string{"東京", "😀", "ÉTÉ", "ниндзя", "🐙🦑"} {
+		for i := 0; i < 7; i++ {
+			words = append(words, fmt.Sprintf("%s%d", base, i))
+		}
+	}
+	h := Handler(map[string][]string{"unicode": words})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "max_len": 4})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Words []string `json:"words"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Words) != len(colorDistribution) {
+		t.Fatalf("board has %d words, want %d", len(resp.Words), len(colorDistribution))
+	}
+	for _, w := range resp.Words {
+		if !strings.Contains(rw.Body.String(), w) {
+			t.Errorf("response doesn't contain %q unescaped", w)
+		}
+		if strings.HasPrefix(w, "ниндзя") {
+			t.Errorf("board contains %q, which is longer than max_len", w)
+		}
+	}
+}
+
+func TestCryptoSeeds(t *testing.T) {
+	// Handlers with the same rand source pick the same seeds,
+	// unless seeds come from crypto/rand.
+	newHandler := func(opts ...Option) http.Handler {
+		opts = append(opts, WithRandSource(rand.NewSource(1)))
+		return Handler(map[string][]string{"example": exampleWords}, opts...)
+	}
+	if a, b := newTestGame(t, newHandler(), "foo"), newTestGame(t, newHandler(), "foo"); a != b {
+		t.Fatalf("handlers with the same rand source picked seeds %s and %s", a, b)
+	}
+
+	h := newHandler(WithCryptoSeeds())
+	a, b := newTestGame(t, h, "foo"), newTestGame(t, h, "bar")
+	if a == b || a == newTestGame(t, newHandler(WithCryptoSeeds()), "foo") {
+		t.Errorf("crypto seeds collided: %s, %s", a, b)
+	}
+
+	// The board is still determined by the stored seed.
+	g := mustGet(t, h, "foo")
+	if got := mustReconstruct(t, g.GameState).Words; !reflect.DeepEqual(got, g.Words) {
+		t.Errorf("reconstructed words = %v, want %v", got, g.Words)
+	}
+}
+
+func TestGameStates(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	fooSeed := newTestGame(t, h, "foo")
+	barSeed := newTestGame(t, h, "bar")
+
+	rw := post(h, "/game-states", map[string]interface{}{"game_ids": []string{"foo", "missing", "bar"}})
+	if rw.Code != 200 {
+		t.Fatalf("POST /game-states = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Games map[string]struct {
+			State struct {
+				Seed string `json:"seed"`
+			} `json:"state"`
+		} `json:"games"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Games) != 2 || resp.Games["foo"].State.Seed != fooSeed || resp.Games["bar"].State.Seed != barSeed {
+		t.Errorf("POST /game-states = %s, want foo and bar", rw.Body)
+	}
+
+	// Only the selected fields are included.
+	for _, fields := range [][]string{{"greens_needed", "active_team"}, {"turn", "no_such_field"}} {
+		rw = post(h, "/game-states", map[string]interface{}{"game_ids": []string{"foo"}, "fields": fields})
+		var resp struct {
+			Games map[string]map[string]json.RawMessage `json:"games"`
+		}
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		foo := resp.Games["foo"]
+		if _, ok := foo["words"]; ok || len(foo) == 0 || len(foo) > len(fields) {
+			t.Errorf("POST /game-states with fields %v = %s, want only those fields", fields, rw.Body)
+		}
+		for f := range foo {
+			if f != fields[0] && f != fields[1] {
+				t.Errorf("POST /game-states with fields %v includes %q", fields, f)
+			}
+		}
+	}
+
+	ids := make([]string, maxBatchGames+1)
+	rw = post(h, "/game-states", map[string]interface{}{"game_ids": ids})
+	if rw.Code != 422 || errorCode(t, rw) != "too_many_games" {
+		t.Errorf("POST /game-states with %d IDs = %d %s, want 422 too_many_games", len(ids), rw.Code, rw.Body)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	fooSeed := newTestGame(t, h, "foo")
+	barSeed := newTestGame(t, h, "bar")
+	type watchResponse struct {
+		Updates []WatchUpdate `json:"updates"`
+	}
+	watch := func(games ...map[string]interface{}) <-chan watchResponse {
+		ch := make(chan watchResponse, 1)
+		go func() {
+			rw := post(h, "/watch", map[string]interface{}{"games": games})
+			var resp watchResponse
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Errorf("POST /watch = %d %s: %s", rw.Code, rw.Body, err)
+			}
+			ch <- resp
+		}()
+		return ch
+	}
+	// Guess greens, so that team one keeps guessing.
+	guess := func(id, seed string) {
+		g := mustGet(t, h, id)
+		index := indexOf(g.TwoLayout, Green, func(i int) bool { return g.ExposedTwo[i] })
+		post(h, "/guess", map[string]interface{}{"game_id": id, "seed": seed, "player_id": "alice", "team": TeamOne, "index": index})
+	}
+	foo := map[string]interface{}{"game_id": "foo", "seed": fooSeed, "last_event": len(mustGet(t, h, "foo").Events)}
+
+	// Missing games are reported without waiting.
+	resp := <-watch(foo, map[string]interface{}{"game_id": "missing"})
+	if len(resp.Updates) != 1 || resp.Updates[0].GameID != "missing" || resp.Updates[0].Error != "not_found" {
+		t.Errorf("watching a missing game = %+v, want a not_found update", resp.Updates)
+	}
+
+	// Only the watched game's events wake the watcher.
+	ch := watch(foo)
+	guess("bar", barSeed)
+	select {
+	case resp := <-ch:
+		t.Fatalf("watching foo returned %+v after a guess in bar", resp.Updates)
+	case <-time.After(50 * time.Millisecond):
+	}
+	guess("foo", fooSeed)
+	select {
+	case resp := <-ch:
+		if len(resp.Updates) != 1 || resp.Updates[0].GameID != "foo" || len(resp.Updates[0].Events) == 0 {
+			t.Errorf("watching foo = %+v, want foo's new events", resp.Updates)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watching foo didn't return after a guess in foo")
+	}
+
+	// Dropping a game from the request unsubscribes from it.
+	bar := map[string]interface{}{"game_id": "bar", "seed": barSeed, "last_event": len(mustGet(t, h, "bar").Events)}
+	ch = watch(bar)
+	guess("foo", fooSeed)
+	select {
+	case resp := <-ch:
+		t.Fatalf("watching bar returned %+v after a guess in foo", resp.Updates)
+	case <-time.After(50 * time.Millisecond):
+	}
+	guess("bar", barSeed)
+	<-ch
+}
+
+func TestPollAfterHint(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithPollIntervals(100*time.Millisecond, 10*time.Second))
+	seed := newTestGame(t, h, "foo")
+	pollAfter := func(player string, team int) int64 {
+		t.Helper()
+		rw := post(h, "/events", map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      seed,
+			"player_id": player,
+			"team":      team,
+		})
+		var resp GameUpdate
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.PollAfterMS
+	}
+
+	// A game without players on both teams is idle.
+	if ms := pollAfter("alice", TeamOne); ms < 8000 || ms > 12000 {
+		t.Errorf("idle game poll_after_ms = %d, want 10000±20%%", ms)
+	}
+	if ms := pollAfter("bob", TeamTwo); ms < 80 || ms > 120 {
+		t.Errorf("active game poll_after_ms = %d, want 100±20%%", ms)
+	}
+
+	g := mustGet(t, h, "foo")
+	g.mu.Lock()
+	g.addEvent(Event{Type: "guess", Team: TeamOne, Index: indexOf(g.TwoLayout, Black, func(int) bool { return false })})
+	g.checkFinished("alice", TeamOne, time.Now())
+	g.mu.Unlock()
+	if ms := pollAfter("bob", TeamTwo); ms < 8000 || ms > 12000 {
+		t.Errorf("finished game poll_after_ms = %d, want 10000±20%%", ms)
+	}
+}
+
+func TestPollAfterRandSource(t *testing.T) {
+	// Handlers with the same rand source jitter poll intervals
+	// the same way.
+	pollAfters := func() []int64 {
+		h := Handler(map[string][]string{"example": exampleWords}, WithRandSource(rand.NewSource(1)))
+		seed := newTestGame(t, h, "foo")
+		var ms []int64
+		for i := 0; i < 5; i++ {
+			rw := post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice"})
+			var resp GameUpdate
+			if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			ms = append(ms, resp.PollAfterMS)
+		}
+		return ms
+	}
+	if a, b := pollAfters(), pollAfters(); !reflect.DeepEqual(a, b) {
+		t.Errorf("handlers with the same rand source returned poll_after_ms %v and %v", a, b)
+	}
+}
+
+func TestNewGameBadWords(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMaxWordLength(10))
+	for _, bad := range []string{strings.Repeat("é", 11), "TAB\tWORD"} {
+		words := append([]string{}, exampleWords[:30]...)
+		words[7] = bad
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words})
+		if rw.Code != 422 || errorCode(t, rw) != "bad_word" || !strings.Contains(rw.Body.String(), "words[7]") {
+			t.Errorf("POST /new-game with word %q = %d %s, want 422 bad_word naming index 7", bad, rw.Code, rw.Body)
+		}
+	}
+
+	words := append([]string{}, exampleWords[:30]...)
+	words[7] = strings.Repeat("é", 10)
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words}); rw.Code != 200 {
+		t.Errorf("POST /new-game with a word of the maximum length = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestValidateWords(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithMaxWordLength(10))
+	words := append([]string{}, exampleWords[:24]...)
+	words = append(words, exampleWords[0], exampleWords[1], strings.Repeat("é", 11))
+
+	rw := post(h, "/validate-words", map[string]interface{}{"words": words})
+	if rw.Code != 200 {
+		t.Fatalf("POST /validate-words = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var check wordCheck
+	if err := json.Unmarshal(rw.Body.Bytes(), &check); err != nil {
+		t.Fatal(err)
+	}
+	if check.Count != 24 || check.Duplicates != 2 || check.Enough {
+		t.Errorf("POST /validate-words = %s, want 24 words, 2 duplicates, not enough", rw.Body)
+	}
+	if len(check.Rejected) != 1 || check.Rejected[0].Index != 26 {
+		t.Errorf("rejected = %+v, want index 26", check.Rejected)
+	}
+
+	// /new-game rejects the same word.
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_word" || !strings.Contains(rw.Body.String(), "words[26]") {
+		t.Errorf("POST /new-game = %d %s, want 422 bad_word naming index 26", rw.Code, rw.Body)
+	}
+
+	rw = post(h, "/validate-words", map[string]interface{}{"words": exampleWords[:25]})
+	if err := json.Unmarshal(rw.Body.Bytes(), &check); err != nil {
+		t.Fatal(err)
+	}
+	if check.Count != 25 || !check.Enough || len(check.Rejected) != 0 {
+		t.Errorf("POST /validate-words with 25 words = %s, want enough", rw.Body)
+	}
+}
+
+func TestNewGamePrereveal(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	// Pin a green and the assassin to known cells of team one's
+	// layout.
+	fixed := []map[string]interface{}{
+		{"word": "green", "index": 0, "color_one": "g", "color_two": "t"},
+		{"word": "black", "index": 1, "color_one": "b", "color_two": "t"},
+	}
+	newGame := func(prereveal map[string][]int, any bool) *httptest.ResponseRecorder {
+		return post(h, "/new-game", map[string]interface{}{
+			"game_id": "foo", "dry_run": true, "fixed": fixed,
+			"prereveal": prereveal, "prereveal_any": any,
+		})
+	}
+
+	for _, tc := range []struct {
+		prereveal map[string][]int
+		field     string
+	}{
+		{map[string][]int{"two": {25}}, "prereveal.two[0]"},
+		{map[string][]int{"one": {0, 1}}, "prereveal.one[1]"},
+	} {
+		rw := newGame(tc.prereveal, false)
+		if rw.Code != 422 || errorCode(t, rw) != "bad_prereveal" || !strings.Contains(rw.Body.String(), tc.field) {
+			t.Errorf("POST /new-game with prereveal %v = %d %s, want 422 bad_prereveal naming %s", tc.prereveal, rw.Code, rw.Body, tc.field)
+		}
+	}
+
+	rw := newGame(map[string][]int{"one": {0}}, false)
+	if rw.Code != 200 || !strings.Contains(rw.Body.String(), `"exposed_one_indices":[0]`) {
+		t.Errorf("POST /new-game prerevealing a green = %d %s, want cell 0 exposed", rw.Code, rw.Body)
+	}
+
+	// prereveal_any allows the assassin, which ends the game at
+	// once.
+	rw = newGame(map[string][]int{"one": {1}}, true)
+	if rw.Code != 200 || !strings.Contains(rw.Body.String(), `"outcome_reason":"assassin"`) {
+		t.Errorf("POST /new-game prerevealing the assassin = %d %s, want a game lost to the assassin", rw.Code, rw.Body)
+	}
+}
+
+func TestTooFewDistinctWords(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	// 25 entries, but only 22 distinct words once whitespace is
+	// trimmed.
+	words := append([]string{}, exampleWords[:22]...)
+	words = append(words, exampleWords[0], " "+exampleWords[1], exampleWords[2]+" ")
+
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "words": words})
+	if rw.Code != 422 || errorCode(t, rw) != "too_few_words" || !strings.Contains(rw.Body.String(), "only 22 are available") {
+		t.Errorf("POST /new-game with duplicates = %d %s, want 422 too_few_words with 22 available", rw.Code, rw.Body)
+	}
+	if _, ok := h.(*handler).games.Get("foo"); ok {
+		t.Error("game was created from too few distinct words")
+	}
+
+	rw = post(h, "/validate-words", map[string]interface{}{"words": words})
+	var check wordCheck
+	if err := json.Unmarshal(rw.Body.Bytes(), &check); err != nil {
+		t.Fatal(err)
+	}
+	if check.Count != 22 || check.Duplicates != 3 || check.Enough {
+		t.Errorf("POST /validate-words = %s, want 22 words, 3 duplicates, not enough", rw.Body)
+	}
+}
+
+func TestReshuffleLayout(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+	old := mustGet(t, h, "foo")
+
+	rw := post(h, "/reshuffle-layout", map[string]interface{}{"game_id": "foo", "seed": "1"})
+	if rw.Code != 400 || errorCode(t, rw) != "bad_seed" {
+		t.Errorf("POST /reshuffle-layout with the wrong seed = %d %s, want 400 bad_seed", rw.Code, rw.Body)
+	}
+	rw = post(h, "/reshuffle-layout", map[string]interface{}{"game_id": "foo", "seed": seed})
+	if rw.Code != 200 {
+		t.Fatalf("POST /reshuffle-layout = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	g := mustGet(t, h, "foo")
+	if g.Seed == old.Seed || !reflect.DeepEqual(g.Words, old.Words) {
+		t.Errorf("reshuffled game has seed %d and words %v, want a new seed and words %v", g.Seed, g.Words, old.Words)
+	}
+	if len(g.ExposedOneIndices)+len(g.ExposedTwoIndices) != 0 || g.Turn != 1 {
+		t.Errorf("reshuffled game has exposed cells %v, %v on turn %d, want none on turn 1",
+			g.ExposedOneIndices, g.ExposedTwoIndices, g.Turn)
+	}
+	if _, ok := g.Players["alice"]; !ok {
+		t.Errorf("reshuffled game dropped its players")
+	}
+	if got := mustReconstruct(t, g.GameState); !reflect.DeepEqual(got.Words, g.Words) || !reflect.DeepEqual(got.OneLayout, g.OneLayout) {
+		t.Errorf("reshuffled game doesn't survive reconstruction")
+	}
+}
+
+func TestFindWord(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	g := mustGet(t, h, "foo")
+	post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+
+	// A cell that's black for team two but not for team one.
+	i := indexOf(g.TwoLayout, Black, func(i int) bool { return g.OneLayout[i] == Black })
+	word := " " + strings.ToLower(g.Words[i]) + " "
+	rw := post(h, "/find-word", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamOne, "word": word})
+	if rw.Code != 200 {
+		t.Fatalf("POST /find-word = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"index": float64(i), "color": g.OneLayout[i].String(), "exposed": false}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("POST /find-word = %v, want %v", resp, want)
+	}
+
+	// Alice can't look up team two's layout.
+	rw = post(h, "/find-word", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamTwo, "word": word})
+	if rw.Code != 403 || errorCode(t, rw) != "wrong_team" || strings.Contains(rw.Body.String(), `"b"`) {
+		t.Errorf("POST /find-word for the opposing team = %d %s, want 403 wrong_team", rw.Code, rw.Body)
+	}
+
+	// Neither can a player who hasn't joined the game.
+	for _, team := range []int{TeamOne, TeamTwo} {
+		rw = post(h, "/find-word", map[string]interface{}{"game_id": "foo", "player_id": "carol", "team": team, "word": word})
+		if rw.Code != 403 || errorCode(t, rw) != "not_on_team" {
+			t.Errorf("POST /find-word for an unknown player on team %d = %d %s, want 403 not_on_team", team, rw.Code, rw.Body)
+		}
+	}
+
+	rw = post(h, "/find-word", map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamOne, "word": "not on the board"})
+	if rw.Code != 404 || errorCode(t, rw) != "word_not_found" {
+		t.Errorf("POST /find-word for a missing word = %d %s, want 404 word_not_found", rw.Code, rw.Body)
+	}
+}
+
+func TestCell(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	g := mustGet(t, h, "foo")
+
+	// Guess a green so that the game isn't over.
+	green := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+
+	post(h, "/ping", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "bob", "team": TeamTwo})
+	rw := post(h, "/cell", map[string]interface{}{"game_id": "foo", "player_id": "bob", "team": TeamTwo, "index": green})
+	if rw.Code != 200 {
+		t.Fatalf("POST /cell = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Index   int    `json:"index"`
+		Color   string `json:"color"`
+		Exposed bool   `json:"exposed"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Index != green || resp.Color != "g" || !resp.Exposed {
+		t.Errorf("POST /cell = %+v, want index %d, color g, exposed", resp, green)
+	}
+
+	testCases := map[string]struct {
+		body map[string]interface{}
+		code int
+		err  string
+	}{
+		"bad team":       {map[string]interface{}{"game_id": "foo", "player_id": "bob", "team": 3, "index": 0}, 422, "bad_team"},
+		"bad index":      {map[string]interface{}{"game_id": "foo", "player_id": "bob", "team": TeamOne, "index": 25}, 422, "bad_index"},
+		"not found":      {map[string]interface{}{"game_id": "bar", "player_id": "bob", "team": TeamOne, "index": 0}, 404, "not_found"},
+		"opposing team":  {map[string]interface{}{"game_id": "foo", "player_id": "alice", "team": TeamTwo, "index": 0}, 403, "wrong_team"},
+		"unknown player": {map[string]interface{}{"game_id": "foo", "player_id": "carol", "team": TeamTwo, "index": 0}, 403, "not_on_team"},
+	}
+	for name, tc := range testCases {
+		rw := post(h, "/cell", tc.body)
+		if rw.Code != tc.code || errorCode(t, rw) != tc.err {
+			t.Errorf("%s: POST /cell = %d %s, want %d %s", name, rw.Code, rw.Body, tc.code, tc.err)
+		}
+	}
+}
+
+func TestConfirmGuesses(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "confirm_guesses": true})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	request := func(path, player string, index int) *httptest.ResponseRecorder {
+		return post(h, path, map[string]interface{}{
+			"game_id":   "foo",
+			"seed":      g.Seed,
+			"player_id": player,
+			"team":      TeamOne,
+			"index":     index,
+		})
+	}
+	green := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+
+	if rw := request("/guess", "alice", green); rw.Code != 409 || errorCode(t, rw) != "confirmation_required" {
+		t.Errorf("POST /guess = %d %s, want 409 confirmation_required", rw.Code, rw.Body)
+	}
+	if rw := request("/confirm-guess", "bob", 0); rw.Code != 409 || errorCode(t, rw) != "no_proposal" {
+		t.Errorf("POST /confirm-guess without a proposal = %d %s, want 409 no_proposal", rw.Code, rw.Body)
+	}
+
+	// Propose then cancel.
+	request("/propose-guess", "alice", green)
+	if p, ok := g.Proposals[TeamOne]; !ok || p.Index != green || p.PlayerID != "alice" {
+		t.Errorf("proposals after POST /propose-guess = %v, want alice's guess of %d", g.Proposals, green)
+	}
+	request("/cancel-guess", "bob", 0)
+	if len(g.Proposals) != 0 || g.ExposedTwo[green] {
+		t.Errorf("after POST /cancel-guess, proposals = %v and exposed = %t, want none", g.Proposals, g.ExposedTwo[green])
+	}
+
+	// A new proposal replaces the old one, and confirming it
+	// makes the guess.
+	request("/propose-guess", "alice", (green+1)%25)
+	request("/propose-guess", "alice", green)
+	if rw := request("/confirm-guess", "bob", 0); rw.Code != 200 {
+		t.Fatalf("POST /confirm-guess = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if !g.ExposedTwo[green] || len(g.Proposals) != 0 {
+		t.Errorf("after POST /confirm-guess, exposed = %t and proposals = %v, want the guess made", g.ExposedTwo[green], g.Proposals)
+	}
+	if last := g.Events[len(g.Events)-1]; last.Type != "guess" || last.PlayerID != "alice" || last.Index != green {
+		t.Errorf("last event = %+v, want alice's guess of %d", last, green)
+	}
+	if reconstructed := mustReconstruct(t, g.GameState); !reflect.DeepEqual(reconstructed.ExposedTwo, g.ExposedTwo) {
+		t.Errorf("reconstructed game doesn't match")
+	}
+
+	h = Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	rw = post(h, "/propose-guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": 0})
+	if rw.Code != 409 || errorCode(t, rw) != "confirmation_off" {
+		t.Errorf("POST /propose-guess without confirmation = %d %s, want 409 confirmation_off", rw.Code, rw.Body)
+	}
+}
+
+func TestNewGameFixed(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+
+	// Pin every cell of the board.
+	var fixed []map[string]interface{}
+	for i, c := range colorDistribution {
+		fixed = append(fixed, map[string]interface{}{
+			"word": exampleWords[i], "index": i, "color_one": c[0], "color_two": c[1],
+		})
+	}
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "fixed": fixed})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	for i, c := range colorDistribution {
+		if g.Words[i] != exampleWords[i] || g.OneLayout[i] != c[0] || g.TwoLayout[i] != c[1] {
+			t.Errorf("cell %d = %s %s/%s, want %s %s/%s", i,
+				g.Words[i], g.OneLayout[i], g.TwoLayout[i], exampleWords[i], c[0], c[1])
+		}
+	}
+	if g.ShareCode != "" {
+		t.Errorf("pinned board has share code %q, want none", g.ShareCode)
+	}
+
+	// The board survives an export and import.
+	b, err := json.Marshal(g.persisted())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ps persistedState
+	if err := json.Unmarshal(b, &ps); err != nil {
+		t.Fatal(err)
+	}
+	imported := mustReconstruct(t, *ps.state())
+	if !reflect.DeepEqual(imported.Words, g.Words) || !reflect.DeepEqual(imported.OneLayout, g.OneLayout) ||
+		!reflect.DeepEqual(imported.TwoLayout, g.TwoLayout) {
+		t.Errorf("imported pinned board doesn't match")
+	}
+
+	// Pin a few cells and draw the rest.
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "fixed": fixed[:3]})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g = mustGet(t, h, "bar")
+	for i, c := range colorDistribution[:3] {
+		if g.Words[i] != exampleWords[i] || g.OneLayout[i] != c[0] || g.TwoLayout[i] != c[1] {
+			t.Errorf("pinned cell %d = %s %s/%s, want %s %s/%s", i,
+				g.Words[i], g.OneLayout[i], g.TwoLayout[i], exampleWords[i], c[0], c[1])
+		}
+	}
+	if err := checkBoard(g.Board, colorDistribution); err != nil {
+		t.Errorf("partly pinned board is invalid: %s", err)
+	}
+
+	testCases := map[string][]map[string]interface{}{
+		"out of range": {{"word": "A", "index": 25, "color_one": "t", "color_two": "t"}},
+		"same index": {
+			{"word": "A", "index": 0, "color_one": "t", "color_two": "t"},
+			{"word": "B", "index": 0, "color_one": "t", "color_two": "t"},
+		},
+		"same word": {
+			{"word": "A", "index": 0, "color_one": "t", "color_two": "t"},
+			{"word": "A", "index": 1, "color_one": "t", "color_two": "t"},
+		},
+		"too many colors": {
+			{"word": "A", "index": 0, "color_one": "b", "color_two": "b"},
+			{"word": "B", "index": 1, "color_one": "b", "color_two": "b"},
+		},
+	}
+	for name, fixed := range testCases {
+		rw := post(h, "/new-game", map[string]interface{}{"game_id": "baz", "fixed": fixed})
+		if rw.Code != 422 || errorCode(t, rw) != "bad_fixed" {
+			t.Errorf("%s: POST /new-game = %d %s, want 422 bad_fixed", name, rw.Code, rw.Body)
+		}
+	}
+}
+
+func TestNewGameBlacks(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "blacks": 6})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	for _, layout := range [][]Color{g.OneLayout, g.TwoLayout} {
+		n := 0
+		for _, c := range layout {
+			if c == Black {
+				n++
+			}
+		}
+		if n != 6 {
+			t.Errorf("layout %v has %d blacks, want 6", layout, n)
+		}
+	}
+	if reconstructed := mustReconstruct(t, g.GameState); !reflect.DeepEqual(reconstructed.OneLayout, g.OneLayout) {
+		t.Errorf("reconstructed layout = %v, want %v", reconstructed.OneLayout, g.OneLayout)
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "bar", "blacks": maxBlacks + 1})
+	if rw.Code != 422 || errorCode(t, rw) != "bad_blacks" {
+		t.Errorf("POST /new-game with too many blacks = %d %s, want 422 bad_blacks", rw.Code, rw.Body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	request := func(method, path, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+	bodyID := func(rw *httptest.ResponseRecorder) string {
+		var resp errorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.RequestID
+	}
+
+	// The client's ID is echoed by every route, including errors.
+	for _, path := range []string{"/stats", "/new-game", "/guess", "/no-such-endpoint"} {
+		rw := request("POST", path, "abc-123")
+		if got := rw.Header().Get("X-Request-ID"); got != "abc-123" {
+			t.Errorf("POST %s X-Request-ID = %q, want abc-123", path, got)
+		}
+		if rw.Code >= 400 && bodyID(rw) != "abc-123" {
+			t.Errorf("POST %s = %d %s, want request_id abc-123", path, rw.Code, rw.Body)
+		}
+	}
+	if got := request("OPTIONS", "/guess", "abc-123").Header().Get("X-Request-ID"); got != "abc-123" {
+		t.Errorf("OPTIONS /guess X-Request-ID = %q, want abc-123", got)
+	}
+
+	// Missing or unreasonable IDs are replaced.
+	for _, id := range []string{"", "bad id", strings.Repeat("a", maxRequestIDLength+1)} {
+		rw := request("POST", "/guess", id)
+		got := rw.Header().Get("X-Request-ID")
+		if got == "" || got == id {
+			t.Errorf("X-Request-ID for %q = %q, want a new ID", id, got)
+		}
+		if bodyID(rw) != got {
+			t.Errorf("request_id = %q, want %q", bodyID(rw), got)
+		}
+	}
+}
+
+func TestWriteJSONMatchesMarshal(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	newTestGame(t, h, "foo")
+	for _, v := range []interface{}{
+		mustGet(t, h, "foo"),
+		GameUpdate{Events: []Event{{Number: 1, Type: "chat", Message: "<b>&</b>"}}},
+		map[string]int{"b": 2, "a": 1},
+		nil,
+	} {
+		want, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		writeJSON(rw, v)
+		if !bytes.Equal(rw.Body.Bytes(), want) {
+			t.Errorf("writeJSON wrote %s, want %s", rw.Body, want)
+		}
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that drops what's
+// written to it, so that benchmarks measure only the encoding.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
+
+func BenchmarkWriteJSON(b *testing.B) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+	if rw.Code != 200 {
+		b.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g, _ := h.(*handler).games.Get("foo")
+	w := discardResponseWriter{header: http.Header{}}
+
+	// marshal writes the response the way writeJSON did before
+	// its buffers were pooled, for comparison.
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			j, _ := json.Marshal(g)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(200)
+			w.Write(j)
+		}
+	})
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeJSON(w, g)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package gameapi
+
+import "time"
+
+const (
+	// idempotencyWindow is how long the response to a request
+	// with an Idempotency-Key header is remembered.
+	idempotencyWindow = 5 * time.Minute
+	// maxIdempotencyKeys bounds the number of keys remembered
+	// for a single game.
+	maxIdempotencyKeys = 256
+)
+
+// idempotencyCache remembers the responses to recent requests made
+// with an Idempotency-Key header, so that a client retrying a request
+// receives the original response instead of applying it twice.
+type idempotencyCache map[string]idempotentResponse
+
+type idempotentResponse struct {
+	resp    interface{}
+	expires time.Time
+}
+
+// get returns the response remembered for key, if any.
+func (c idempotencyCache) get(key string, now time.Time) (interface{}, bool) {
+	r, ok := c[key]
+	if !ok || now.After(r.expires) {
+		return nil, false
+	}
+	return r.resp, true
+}
+
+// put remembers resp as the response for key, evicting expired
+// entries, or the oldest entry, if the cache is full.
+func (c idempotencyCache) put(key string, resp interface{}, now time.Time) {
+	if len(c) >= maxIdempotencyKeys {
+		var oldest string
+		for k, r := range c {
+			if now.After(r.expires) {
+				delete(c, k)
+			} else if oldest == "" || r.expires.Before(c[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(c) >= maxIdempotencyKeys {
+			delete(c, oldest)
+		}
+	}
+	c[key] = idempotentResponse{resp: resp, expires: now.Add(idempotencyWindow)}
+}
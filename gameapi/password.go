@@ -0,0 +1,70 @@
+package gameapi
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// passwordHeader is the request header that carries the password of
+// a game that has one. Requests to create a game pass its password
+// in the body instead.
+const passwordHeader = "X-Game-Password"
+
+// passwordSaltSize is the number of random bytes that each
+// password is salted with.
+const passwordSaltSize = 16
+
+// hashPassword returns a salted hash of password, as the hex-encoded
+// salt and the hex-encoded SHA-256 hash of the salt followed by the
+// password, separated by a colon.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := crand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + ":" + saltedHash(salt, password), nil
+}
+
+func saltedHash(salt []byte, password string) string {
+	sum := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkPassword reports whether password matches hash, as returned
+// by hashPassword, in constant time.
+func checkPassword(hash, password string) bool {
+	i := strings.IndexByte(hash, ':')
+	if i < 0 {
+		return false
+	}
+	salt, err := hex.DecodeString(hash[:i])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedHash(salt, password)), []byte(hash[i+1:])) == 1
+}
+
+// allowed reports whether a request carrying password may view g
+// or, if change is set, change it. A game without a password allows
+// every request. g's password and spectator setting never change,
+// so g.mu needn't be held.
+func (g *Game) allowed(password string, change bool) bool {
+	if g.PasswordHash == "" || (g.Spectators && !change) {
+		return true
+	}
+	return checkPassword(g.PasswordHash, password)
+}
+
+// authorize reports whether req may view g or, if change is set,
+// change it, writing a bad_password error if not.
+func authorize(rw http.ResponseWriter, req *http.Request, g *Game, change bool) bool {
+	if g.allowed(req.Header.Get(passwordHeader), change) {
+		return true
+	}
+	writeError(rw, "bad_password", "This game requires a password.", 401)
+	return false
+}
@@ -0,0 +1,173 @@
+package gameapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == again {
+		t.Error("hashes of the same password are equal, want them salted")
+	}
+	for _, tc := range []struct {
+		hash, password string
+		want           bool
+	}{
+		{hash, "hunter2", true},
+		{again, "hunter2", true},
+		{hash, "hunter3", false},
+		{hash, "", false},
+		{"", "", false},
+		{"zz:" + strings.SplitN(hash, ":", 2)[1], "hunter2", false},
+	} {
+		if got := checkPassword(tc.hash, tc.password); got != tc.want {
+			t.Errorf("checkPassword(%q, %q) = %t, want %t", tc.hash, tc.password, got, tc.want)
+		}
+	}
+}
+
+// postWithPassword is like post, but carries password in the
+// X-Game-Password header.
+func postWithPassword(h http.Handler, path, password string, body interface{}) *httptest.ResponseRecorder {
+	b, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.Header.Set(passwordHeader, password)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	return rw
+}
+
+func TestGamePassword(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "password": "hunter2"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if strings.Contains(rw.Body.String(), "hunter2") || strings.Contains(rw.Body.String(), "password_hash") {
+		t.Errorf("POST /new-game response leaks the password: %s", rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	if !g.HasPassword || g.PasswordHash == "" || strings.Contains(g.PasswordHash, "hunter2") {
+		t.Fatalf("game has password %t with hash %q, want a hash of the password", g.HasPassword, g.PasswordHash)
+	}
+	seed := fmt.Sprint(int64(g.Seed))
+	green := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+	guess := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green}
+
+	for _, password := range []string{"", "hunter3"} {
+		if rw := postWithPassword(h, "/guess", password, guess); rw.Code != 401 || errorCode(t, rw) != "bad_password" {
+			t.Errorf("POST /guess with password %q = %d %s, want 401 bad_password", password, rw.Code, rw.Body)
+		}
+		if rw := postWithPassword(h, "/new-game", password, map[string]interface{}{"game_id": "foo"}); rw.Code != 401 {
+			t.Errorf("POST /new-game joining with password %q = %d, want 401", password, rw.Code)
+		}
+	}
+	if rw := postWithPassword(h, "/new-game", "hunter2", map[string]interface{}{"game_id": "foo"}); rw.Code != 200 {
+		t.Errorf("POST /new-game joining with the password = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if rw := postWithPassword(h, "/guess", "hunter2", guess); rw.Code != 200 {
+		t.Errorf("POST /guess with the password = %d, want 200: %s", rw.Code, rw.Body)
+	}
+
+	// The hash survives persistence, though not the responses.
+	if state := g.persisted().state(); state.PasswordHash != g.PasswordHash {
+		t.Errorf("persisted password hash = %q, want %q", state.PasswordHash, g.PasswordHash)
+	}
+
+	// Games without a password don't need one.
+	seed = newTestGame(t, h, "bar")
+	green = indexOf(mustGet(t, h, "bar").TwoLayout, Green, func(int) bool { return false })
+	guess = map[string]interface{}{"game_id": "bar", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green}
+	if rw := post(h, "/guess", guess); rw.Code != 200 {
+		t.Errorf("POST /guess without a password = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
+
+func TestGamePasswordSpectators(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "password": "hunter2", "spectators": true})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	seed := fmt.Sprint(int64(mustGet(t, h, "foo").Seed))
+
+	// Spectators may follow the game, but not on a team.
+	rw = post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "carol", "team": TeamOne})
+	if rw.Code != 200 {
+		t.Fatalf("POST /events as a spectator = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if p := mustGet(t, h, "foo").Players["carol"]; p.Team != NoTeam {
+		t.Errorf("spectator joined team %d, want no team", p.Team)
+	}
+	guess := map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "carol", "team": TeamOne, "index": 0}
+	if rw := post(h, "/guess", guess); rw.Code != 401 {
+		t.Errorf("POST /guess as a spectator = %d, want 401", rw.Code)
+	}
+}
+
+func TestGamePasswordAdmin(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords}, WithAdminToken(testAdminToken))
+	if rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo", "password": "hunter2", "spectators": true}); rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	export := func(password string) *httptest.ResponseRecorder {
+		req := adminRequest("GET", "/admin/export?game_id=foo")
+		req.Header.Set(passwordHeader, password)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	// Even spectators need the password to export the game, since
+	// the export includes its hash.
+	if rw := export(""); rw.Code != 401 || strings.Contains(rw.Body.String(), "password_hash") {
+		t.Errorf("GET /admin/export without the password = %d %s, want 401", rw.Code, rw.Body)
+	}
+	rw := export("hunter2")
+	if rw.Code != 200 {
+		t.Fatalf("GET /admin/export with the password = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var state map[string]interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+	if hash, _ := state["password_hash"].(string); hash == "" {
+		t.Errorf("GET /admin/export = %s, want the password hash", rw.Body)
+	}
+
+	// A game with a password can't be taken over by importing
+	// another without it.
+	delete(state, "password_hash")
+	body := map[string]interface{}{"game_id": "foo", "state": state, "force": true}
+	if rw := post(h, "/admin/import", body); rw.Code != 401 || errorCode(t, rw) != "bad_password" {
+		t.Errorf("POST /admin/import over a game with a password = %d %s, want 401 bad_password", rw.Code, rw.Body)
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/admin/import", bytes.NewReader(b))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set(passwordHeader, "hunter2")
+	rw = httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != 200 {
+		t.Errorf("POST /admin/import with the password = %d, want 200: %s", rw.Code, rw.Body)
+	}
+}
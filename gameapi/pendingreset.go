@@ -0,0 +1,105 @@
+package gameapi
+
+import "time"
+
+// pendingReset is a reset that's waiting out the handler's grace
+// period before it replaces a game. See WithResetGracePeriod. Like
+// the chat buffer, it's ephemeral, and isn't part of the GameState,
+// so a reset that's pending when the process restarts is lost.
+type pendingReset struct {
+	// game is the game that will replace the current one, and
+	// roster assigns players to its teams once it does.
+	game   *Game
+	roster map[string]int
+	// requestID identifies the request that scheduled the reset,
+	// so that the replacement is logged for it.
+	requestID string
+	// at is when the reset takes effect, and timer applies it
+	// then.
+	at    time.Time
+	timer *time.Timer
+}
+
+// resetDue reports whether g has a pending reset whose grace
+// period has passed at now. A frozen game's reset is never due,
+// though freezing a game cancels its pending reset anyway. g.mu
+// must be held.
+func (g *Game) resetDue(now time.Time) bool {
+	return g.pendingReset != nil && !g.Frozen && !now.Before(g.pendingReset.at)
+}
+
+// applyDueReset replaces the game identified by id if it has a
+// pending reset that's due, returning true if it did. h.mu must not
+// be held.
+func (h *handler) applyDueReset(id string) bool {
+	// Most games never have a reset pending, so check before
+	// taking h.mu.
+	g, ok := h.games.Get(id)
+	if !ok {
+		return false
+	}
+	g.mu.Lock()
+	due := g.resetDue(h.now())
+	g.mu.Unlock()
+	if !due {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cur, ok := h.games.Get(id); !ok || cur != g {
+		return false // the game was replaced while we weren't holding the lock
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.resetDue(h.now()) {
+		return false // the reset was cancelled
+	}
+	p := g.pendingReset
+	g.pendingReset = nil
+	p.timer.Stop()
+	h.replaceGame(id, g, p.game, p.roster, p.requestID)
+	return true
+}
+
+// applyDueResets applies every pending reset that's due.
+func (h *handler) applyDueResets() {
+	var due []string
+	now := h.now()
+	h.games.Range(func(id string, g *Game) bool {
+		g.mu.Lock()
+		if g.resetDue(now) {
+			due = append(due, id)
+		}
+		g.mu.Unlock()
+		return true
+	})
+	for _, id := range due {
+		h.applyDueReset(id)
+	}
+}
+
+// rearmReset restarts the timer of g's pending reset, if it has one,
+// so that it applies the reset to the game stored under id, such as
+// after the game is renamed. g.mu must be held.
+func (h *handler) rearmReset(id string, g *Game) {
+	p := g.pendingReset
+	if p == nil {
+		return
+	}
+	p.timer.Stop()
+	p.timer = time.AfterFunc(p.at.Sub(h.now()), func() { h.applyDueReset(id) })
+}
+
+// cancelReset cancels g's pending reset, returning false if it has
+// none. The caller records the reset_cancelled event. g.mu must be
+// held.
+func (g *Game) cancelReset() bool {
+	if g.pendingReset == nil {
+		return false
+	}
+	g.pendingReset.timer.Stop()
+	g.pendingReset = nil
+	g.ResetAt = nil
+	return true
+}
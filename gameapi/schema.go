@@ -0,0 +1,118 @@
+package gameapi
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// schemaEndpoints lists the endpoints described by /schema, with
+// the types of their request and response bodies.
+var schemaEndpoints = map[string]struct {
+	request, response reflect.Type
+}{
+	"/new-game": {reflect.TypeOf(newGameRequest{}), reflect.TypeOf(Game{})},
+	"/events":   {reflect.TypeOf(eventsRequest{}), reflect.TypeOf(GameUpdate{})},
+	"/guess":    {reflect.TypeOf(guessRequest{}), reflect.TypeOf(guessResponse{})},
+	"/end-turn": {reflect.TypeOf(endTurnRequest{}), reflect.TypeOf(endTurnResponse{})},
+}
+
+// apiSchema is the body of /schema. It's generated from the
+// types that the handlers decode and encode, so that it can't
+// fall out of date.
+var apiSchema = buildSchema()
+
+type endpointSchema struct {
+	Request  map[string]interface{} `json:"request"`
+	Response map[string]interface{} `json:"response"`
+}
+
+func buildSchema() interface{} {
+	endpoints := map[string]endpointSchema{}
+	for path, e := range schemaEndpoints {
+		endpoints[path] = endpointSchema{
+			Request:  rootSchema(e.request),
+			Response: rootSchema(e.response),
+		}
+	}
+	return struct {
+		Endpoints map[string]endpointSchema `json:"endpoints"`
+		Error     map[string]interface{}    `json:"error"`
+	}{endpoints, rootSchema(reflect.TypeOf(errorResponse{}))}
+}
+
+// rootSchema returns the JSON Schema of t, as a standalone
+// document.
+func rootSchema(t reflect.Type) map[string]interface{} {
+	s := jsonSchema(t)
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	return s
+}
+
+// jsonSchema returns the JSON Schema of the encoding of t by
+// encoding/json, taking into account the custom encodings of
+// this package's types.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Seed(0)):
+		return map[string]interface{}{"type": "string", "pattern": "^-?[0-9]+$"}
+	case reflect.TypeOf(Color(0)):
+		return map[string]interface{}{"type": "string", "enum": []string{Green.String(), Tan.String(), Black.String()}}
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		addProperties(props, t)
+		return map[string]interface{}{"type": "object", "properties": props}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// addProperties adds the schemas of the encoded fields of the
+// struct type t to props. Like encoding/json, the fields of an
+// untagged embedded struct are promoted.
+func addProperties(props map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch {
+		case name == "-":
+			continue
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			addProperties(props, f.Type)
+			continue
+		case f.PkgPath != "":
+			continue // unexported
+		case name == "":
+			name = f.Name
+		}
+		props[name] = jsonSchema(f.Type)
+	}
+}
+
+// GET /schema
+// This endpoint describes the request and response bodies of the
+// main endpoints, and of error responses, as JSON Schema.
+func handleSchema(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, apiSchema)
+}
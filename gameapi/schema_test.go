@@ -0,0 +1,112 @@
+package gameapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// sampleValue returns a value that matches schema.
+func sampleValue(schema map[string]interface{}) interface{} {
+	if enum, ok := schema["enum"].([]string); ok {
+		return enum[0]
+	}
+	switch schema["type"] {
+	case "string":
+		switch {
+		case schema["pattern"] != nil:
+			return "0"
+		case schema["format"] == "date-time":
+			return "2020-01-01T00:00:00Z"
+		}
+		return ""
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	case "array":
+		return []interface{}{sampleValue(schema["items"].(map[string]interface{}))}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func TestSchemaRequests(t *testing.T) {
+	// Every property in a request schema must be decoded into
+	// the handler's request type.
+	for path, e := range schemaEndpoints {
+		props := jsonSchema(e.request)["properties"].(map[string]interface{})
+		body := map[string]interface{}{}
+		for name, schema := range props {
+			body[name] = sampleValue(schema.(map[string]interface{}))
+		}
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(reflect.New(e.request).Interface()); err != nil {
+			t.Errorf("%s: decoding %s: %s", path, b, err)
+		}
+	}
+}
+
+func TestSchemaResponses(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	bazSeed := newTestGame(t, h, "baz")
+	responses := map[string]*httptest.ResponseRecorder{
+		"/new-game": post(h, "/new-game", map[string]interface{}{"game_id": "bar"}),
+		"/events":   post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice"}),
+		"/guess":    post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne}),
+		"/end-turn": post(h, "/end-turn", map[string]interface{}{"game_id": "baz", "seed": bazSeed, "player_id": "alice", "team": TeamOne}),
+	}
+
+	// Every key in an actual response must be described by the
+	// endpoint's response schema.
+	for path, rw := range responses {
+		if rw.Code != 200 {
+			t.Fatalf("POST %s = %d, want 200: %s", path, rw.Code, rw.Body)
+		}
+		var resp map[string]json.RawMessage
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		schema := jsonSchema(schemaEndpoints[path].response)
+		props, _ := schema["properties"].(map[string]interface{})
+		for key := range resp {
+			if props == nil {
+				if _, ok := schema["additionalProperties"]; !ok {
+					t.Errorf("%s: response key %q isn't in the schema", path, key)
+				}
+			} else if _, ok := props[key]; !ok {
+				t.Errorf("%s: response key %q isn't in the schema", path, key)
+			}
+		}
+	}
+}
+
+func TestSchemaEndpoint(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/schema", nil))
+	if rw.Code != 200 {
+		t.Fatalf("GET /schema = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	var resp struct {
+		Endpoints map[string]struct {
+			Request struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"request"`
+		} `json:"endpoints"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Endpoints["/guess"].Request.Properties["index"]; !ok {
+		t.Errorf("GET /schema = %s, want /guess to take an index", rw.Body)
+	}
+}
@@ -0,0 +1,65 @@
+package gameapi
+
+import (
+	"errors"
+	"math"
+	"strings"
+)
+
+const base62Digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// encodeShareCode returns a compact code identifying the board
+// created from seed and the named word lists, of the form
+// <base62 seed>.<list>.<list>... It returns the empty string if
+// the board can't be shared this way: if it wasn't drawn from
+// named word lists, or if a list's name contains a period.
+func encodeShareCode(seed Seed, lists []string) string {
+	if len(lists) == 0 {
+		return ""
+	}
+	for _, l := range lists {
+		if l == "" || strings.Contains(l, ".") {
+			return ""
+		}
+	}
+
+	n := uint64(seed)
+	var digits []byte
+	for {
+		digits = append(digits, base62Digits[n%62])
+		n /= 62
+		if n == 0 {
+			break
+		}
+	}
+	for i, j := 0, len(digits)-1; i < j; i, j = i+1, j-1 {
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return string(digits) + "." + strings.Join(lists, ".")
+}
+
+// decodeShareCode decodes a code returned by encodeShareCode.
+func decodeShareCode(code string) (seed Seed, lists []string, err error) {
+	parts := strings.Split(code, ".")
+	if len(parts) < 2 || parts[0] == "" {
+		return 0, nil, errors.New("malformed share code")
+	}
+
+	var n uint64
+	for _, c := range []byte(parts[0]) {
+		d := strings.IndexByte(base62Digits, c)
+		if d < 0 {
+			return 0, nil, errors.New("malformed share code")
+		}
+		if n > (math.MaxUint64-uint64(d))/62 {
+			return 0, nil, errors.New("share code seed out of range")
+		}
+		n = n*62 + uint64(d)
+	}
+	for _, l := range parts[1:] {
+		if l == "" {
+			return 0, nil, errors.New("malformed share code")
+		}
+	}
+	return Seed(n), parts[1:], nil
+}
@@ -0,0 +1,40 @@
+package gameapi
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestShareCodeRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		seed  Seed
+		lists []string
+	}{
+		{0, []string{"green"}},
+		{1234567890123456789, []string{"green", "original"}},
+		{math.MaxInt64, []string{"a"}},
+		{math.MinInt64, []string{"a"}},
+		{-1, []string{"a", "b", "c"}},
+	} {
+		code := encodeShareCode(tc.seed, tc.lists)
+		seed, lists, err := decodeShareCode(code)
+		if err != nil || seed != tc.seed || !reflect.DeepEqual(lists, tc.lists) {
+			t.Errorf("decodeShareCode(%q) = %d, %v, %v, want %d, %v", code, seed, lists, err, tc.seed, tc.lists)
+		}
+	}
+}
+
+func TestShareCodeInvalid(t *testing.T) {
+	if code := encodeShareCode(1, nil); code != "" {
+		t.Errorf("encodeShareCode without lists = %q, want empty", code)
+	}
+	if code := encodeShareCode(1, []string{"a.b"}); code != "" {
+		t.Errorf("encodeShareCode with a period = %q, want empty", code)
+	}
+	for _, code := range []string{"", "abc", ".green", "a!.green", "abc..green", "zzzzzzzzzzzzzzzz.green"} {
+		if _, _, err := decodeShareCode(code); err == nil {
+			t.Errorf("decodeShareCode(%q) = nil error, want error", code)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build sqlite
+
+package gameapi
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TestSQLiteStore runs the SQL store's test against a real SQLite
+// database, when built with the sqlite tag.
+func TestSQLiteStore(t *testing.T) {
+	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	testSQLStore(t, db)
+}
@@ -0,0 +1,198 @@
+package gameapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// sqlSaveDebounce is the minimum interval between writes of a
+	// game whose only changes are to its players' last seen times.
+	sqlSaveDebounce = 30 * time.Second
+	// sqlGameTTL is how long a game may go without being written
+	// before it's deleted from the database. A game with active
+	// players is written at least every sqlSaveDebounce.
+	sqlGameTTL = 24 * time.Hour
+)
+
+const sqlSchema = `CREATE TABLE IF NOT EXISTS games (
+	id         TEXT PRIMARY KEY,
+	state      TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+)`
+
+// NewSQLiteStore returns a Store that persists games to db, which
+// must have been opened with a SQLite driver. Games are cached in
+// memory and written through to the database as they change, so
+// that they survive a process restart.
+//
+// Several instances may share a database, but each game must only
+// be played through one instance at a time, because the in-memory
+// cache isn't invalidated by writes from other instances.
+func NewSQLiteStore(db *sql.DB) (Store, error) {
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, err
+	}
+	return &sqlStore{
+		db:     db,
+		cache:  NewMemoryStore(),
+		writes: make(map[string]sqlWrite),
+	}, nil
+}
+
+type sqlStore struct {
+	db    *sql.DB
+	cache Store
+
+	mu     sync.Mutex
+	writes map[string]sqlWrite
+}
+
+// sqlWrite records the last write of a game, for debouncing.
+type sqlWrite struct {
+	game   *Game
+	events int
+	at     time.Time
+}
+
+func (s *sqlStore) Get(id string) (*Game, bool) {
+	if g, ok := s.cache.Get(id); ok {
+		return g, true
+	}
+
+	var state string
+	var createdAt int64
+	err := s.db.QueryRow(`SELECT state, created_at FROM games WHERE id = ?`, id).Scan(&state, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, false
+	} else if err != nil {
+		log.Printf("loading game %q: %s", id, err)
+		return nil, false
+	}
+
+	var ps persistedState
+	if err := json.Unmarshal([]byte(state), &ps); err != nil {
+		log.Printf("decoding game %q: %s", id, err)
+		return nil, false
+	}
+	game, err := ReconstructGame(*ps.state())
+	if err != nil {
+		log.Printf("validating game %q: %s", id, err)
+		return nil, false
+	}
+	g := game
+	g.CreatedAt = time.Unix(createdAt, 0)
+
+	// Another request may have loaded the same game
+	// concurrently. Keep whichever was cached first.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.cache.Get(id); ok {
+		return cached, true
+	}
+	s.cache.Put(id, g)
+	s.writes[id] = sqlWrite{game: g, events: len(g.Events), at: time.Now()}
+	return g, true
+}
+
+func (s *sqlStore) Put(id string, g *Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Put(id, g)
+	s.write(id, g)
+}
+
+func (s *sqlStore) Save(id string, g *Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cached, ok := s.cache.Get(id); !ok || cached != g {
+		return // the game has been replaced
+	}
+
+	// Changes to players' last seen times don't add
+	// events, and are written at most once per
+	// sqlSaveDebounce.
+	last := s.writes[id]
+	if last.game == g && last.events == len(g.Events) && time.Since(last.at) < sqlSaveDebounce {
+		return
+	}
+	s.write(id, g)
+}
+
+// write upserts g into the database. s.mu must be held.
+func (s *sqlStore) write(id string, g *Game) {
+	b, err := json.Marshal(g.persisted())
+	if err != nil {
+		log.Printf("encoding game %q: %s", id, err)
+		return
+	}
+	now := time.Now()
+	_, err = s.db.Exec(`INSERT INTO games (id, state, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET state = excluded.state, created_at = excluded.created_at, updated_at = excluded.updated_at`,
+		id, string(b), g.CreatedAt.Unix(), now.Unix())
+	if err != nil {
+		log.Printf("saving game %q: %s", id, err)
+		return
+	}
+	s.writes[id] = sqlWrite{game: g, events: len(g.Events), at: now}
+}
+
+func (s *sqlStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache.Delete(id)
+	delete(s.writes, id)
+	if _, err := s.db.Exec(`DELETE FROM games WHERE id = ?`, id); err != nil {
+		log.Printf("deleting game %q: %s", id, err)
+	}
+}
+
+func (s *sqlStore) Range(fn func(id string, g *Game) bool) {
+	s.cache.Range(fn)
+}
+
+func (s *sqlStore) Prune(expired func(id string, g *Game) bool) int {
+	var pruned []string
+	kept := map[string]*Game{}
+	deleted := s.cache.Prune(func(id string, g *Game) bool {
+		if expired(id, g) {
+			pruned = append(pruned, id)
+			return true
+		}
+		kept[id] = g
+		return false
+	})
+
+	// Pruning may have removed players from the games
+	// that were kept.
+	for id, g := range kept {
+		g.mu.Lock()
+		s.Save(id, g)
+		g.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range pruned {
+		if _, ok := s.cache.Get(id); ok {
+			continue // the game was replaced while pruning
+		}
+		delete(s.writes, id)
+		if _, err := s.db.Exec(`DELETE FROM games WHERE id = ?`, id); err != nil {
+			log.Printf("deleting game %q: %s", id, err)
+		}
+	}
+
+	// Games that aren't cached, because they were written
+	// by another instance or before a restart, are expired
+	// once they haven't been written to in sqlGameTTL.
+	_, err := s.db.Exec(`DELETE FROM games WHERE updated_at < ?`, time.Now().Add(-sqlGameTTL).Unix())
+	if err != nil {
+		log.Printf("deleting expired games: %s", err)
+	}
+	return deleted
+}
@@ -0,0 +1,183 @@
+package gameapi
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func init() {
+	sql.Register("fakesql", &fakeSQLDriver{dbs: map[string]map[string]fakeGameRow{}})
+}
+
+// fakeSQLDriver is a database/sql driver that understands only the
+// statements made by the SQL store, keeping each database's games
+// table in memory, so that the store can be tested without SQLite.
+type fakeSQLDriver struct {
+	mu  sync.Mutex
+	dbs map[string]map[string]fakeGameRow
+}
+
+type fakeGameRow struct {
+	state                string
+	createdAt, updatedAt int64
+}
+
+func (d *fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dbs[name] == nil {
+		d.dbs[name] = map[string]fakeGameRow{}
+	}
+	return &fakeSQLConn{d: d, games: d.dbs[name]}, nil
+}
+
+type fakeSQLConn struct {
+	d     *fakeSQLDriver
+	games map[string]fakeGameRow
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{c: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, fmt.Errorf("transactions aren't supported")
+}
+
+type fakeSQLStmt struct {
+	c     *fakeSQLConn
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.d.mu.Lock()
+	defer s.c.d.mu.Unlock()
+	games := s.c.games
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS games "):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT INTO games (id, state, created_at, updated_at) VALUES (?, ?, ?, ?) ON CONFLICT (id) DO UPDATE"):
+		games[args[0].(string)] = fakeGameRow{args[1].(string), args[2].(int64), args[3].(int64)}
+		return driver.RowsAffected(1), nil
+	case s.query == "DELETE FROM games WHERE id = ?":
+		_, ok := games[args[0].(string)]
+		delete(games, args[0].(string))
+		if ok {
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case s.query == "DELETE FROM games WHERE updated_at < ?":
+		n := 0
+		for id, row := range games {
+			if row.updatedAt < args[0].(int64) {
+				delete(games, id)
+				n++
+			}
+		}
+		return driver.RowsAffected(n), nil
+	}
+	return nil, fmt.Errorf("unsupported statement %q", s.query)
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.d.mu.Lock()
+	defer s.c.d.mu.Unlock()
+	if s.query != "SELECT state, created_at FROM games WHERE id = ?" {
+		return nil, fmt.Errorf("unsupported query %q", s.query)
+	}
+	rows := &fakeSQLRows{}
+	if row, ok := s.c.games[args[0].(string)]; ok {
+		rows.rows = [][]driver.Value{{row.state, row.createdAt}}
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return []string{"state", "created_at"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	db, err := sql.Open("fakesql", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	testSQLStore(t, db)
+}
+
+// testSQLStore creates a game in a SQL store backed by db, and checks
+// that a new store backed by the same database reloads it.
+func testSQLStore(t *testing.T, db *sql.DB) {
+	newStore := func() Store {
+		s, err := NewSQLiteStore(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	h := Handler(map[string][]string{"example": exampleWords}, WithStore(newStore()))
+	seed := newTestGame(t, h, "foo")
+	green := indexOf(mustGet(t, h, "foo").TwoLayout, Green, func(int) bool { return false })
+
+	rw := post(h, "/guess", map[string]interface{}{
+		"game_id":   "foo",
+		"seed":      seed,
+		"player_id": "alice",
+		"team":      TeamOne,
+		"index":     green,
+	})
+	if rw.Code != 200 {
+		t.Fatalf("POST /guess = %d, want 200", rw.Code)
+	}
+	want := mustGet(t, h, "foo")
+
+	// A new store backed by the same database, as if after a
+	// restart, reconstructs the game from the database.
+	reloaded := newStore()
+	g, ok := reloaded.Get("foo")
+	if !ok {
+		t.Fatal("reloaded store is missing game foo")
+	}
+	if g.Seed != want.Seed || len(g.Events) != len(want.Events) || g.Words[green] != want.Words[green] {
+		t.Errorf("reloaded game = %+v, want %+v", g.GameState, want.GameState)
+	}
+	if !g.ExposedTwo[green] {
+		t.Errorf("reloaded game doesn't reflect the guess")
+	}
+	if g.CreatedAt.Unix() != want.CreatedAt.Unix() {
+		t.Errorf("reloaded CreatedAt = %s, want %s", g.CreatedAt, want.CreatedAt)
+	}
+
+	// Pruning removes the game from the database.
+	reloaded.Prune(func(id string, g *Game) bool {
+		remaining, _ := g.pruneOldPlayers(time.Now().Add(time.Hour))
+		return remaining == 0
+	})
+	if _, ok := newStore().Get("foo"); ok {
+		t.Error("pruned game is still in the database")
+	}
+}
@@ -0,0 +1,157 @@
+package gameapi
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// stateFrameType is the media type of the compact binary encoding
+// of a GameUpdate. Clients opt into it by including it in the
+// Accept header of their requests to /events; other clients keep
+// receiving JSON.
+//
+// A state frame carries only the game's mutable state. Clients are
+// expected to fetch the words and layouts once, as JSON, from
+// /new-game. Events other than guesses, such as chat, aren't
+// carried at all, so clients that show them should stick to JSON.
+//
+// A frame is laid out as follows, with integers big-endian:
+//
+//	offset  size  field
+//	0       1     format version, currently 1
+//	1       8     seed, as a signed integer
+//	9       4     number of the game's latest event, which clients
+//	              pass as last_event in their next request
+//	13      4     ExposedOne, with bit i (counting from the least
+//	              significant) set if cell i is exposed
+//	17      4     ExposedTwo, likewise
+//	21      4     poll_after_ms
+//	25      2     number of reveals, n
+//	27      2n    reveals, one for each guess since the request's
+//	              last_event, in order: the team that guessed, then
+//	              the index of the cell it guessed, a byte each
+//
+// The top 7 bits of each exposed bitset are always zero.
+const stateFrameType = "application/vnd.codenamesgreen.state"
+
+const (
+	stateFrameVersion    = 1
+	stateFrameHeaderSize = 27
+	// stateFrameCells is the number of cells that an exposed
+	// bitset covers.
+	stateFrameCells = 25
+)
+
+// stateFrame is the mutable state of a game, as encoded in a state
+// frame. See stateFrameType.
+type stateFrame struct {
+	Seed        Seed
+	LastEvent   uint32
+	ExposedOne  uint32
+	ExposedTwo  uint32
+	PollAfterMS uint32
+	Reveals     []reveal
+}
+
+// reveal is a guess carried by a state frame.
+type reveal struct {
+	Team  uint8
+	Index uint8
+}
+
+// stateFrame returns the state frame of u. It's only built for
+// clients that ask for state frames.
+func (u GameUpdate) stateFrame() stateFrame {
+	f := stateFrame{
+		Seed:        u.Seed,
+		LastEvent:   u.lastEvent,
+		ExposedOne:  u.exposedOne,
+		ExposedTwo:  u.exposedTwo,
+		PollAfterMS: uint32(u.PollAfterMS),
+		Reveals:     []reveal{},
+	}
+	for _, e := range u.Events {
+		if e.Type == "guess" {
+			f.Reveals = append(f.Reveals, reveal{Team: uint8(e.Team), Index: uint8(e.Index)})
+		}
+	}
+	return f
+}
+
+// exposedBits returns exposed as a bitset.
+func exposedBits(exposed []bool) uint32 {
+	var bits uint32
+	for i, e := range exposed {
+		if e && i < stateFrameCells {
+			bits |= 1 << i
+		}
+	}
+	return bits
+}
+
+// encodeStateFrame returns the binary encoding of f.
+func encodeStateFrame(f stateFrame) []byte {
+	b := make([]byte, stateFrameHeaderSize, stateFrameHeaderSize+2*len(f.Reveals))
+	b[0] = stateFrameVersion
+	binary.BigEndian.PutUint64(b[1:], uint64(f.Seed))
+	binary.BigEndian.PutUint32(b[9:], f.LastEvent)
+	binary.BigEndian.PutUint32(b[13:], f.ExposedOne)
+	binary.BigEndian.PutUint32(b[17:], f.ExposedTwo)
+	binary.BigEndian.PutUint32(b[21:], f.PollAfterMS)
+	binary.BigEndian.PutUint16(b[25:], uint16(len(f.Reveals)))
+	for _, r := range f.Reveals {
+		b = append(b, r.Team, r.Index)
+	}
+	return b
+}
+
+// decodeStateFrame decodes a frame returned by encodeStateFrame.
+func decodeStateFrame(b []byte) (stateFrame, error) {
+	if len(b) < stateFrameHeaderSize {
+		return stateFrame{}, errors.New("state frame too short")
+	}
+	if b[0] != stateFrameVersion {
+		return stateFrame{}, fmt.Errorf("unsupported state frame version %d", b[0])
+	}
+	f := stateFrame{
+		Seed:        Seed(binary.BigEndian.Uint64(b[1:])),
+		LastEvent:   binary.BigEndian.Uint32(b[9:]),
+		ExposedOne:  binary.BigEndian.Uint32(b[13:]),
+		ExposedTwo:  binary.BigEndian.Uint32(b[17:]),
+		PollAfterMS: binary.BigEndian.Uint32(b[21:]),
+	}
+	if (f.ExposedOne|f.ExposedTwo)>>stateFrameCells != 0 {
+		return stateFrame{}, errors.New("state frame exposes cells beyond the board")
+	}
+	n := int(binary.BigEndian.Uint16(b[25:]))
+	if len(b) != stateFrameHeaderSize+2*n {
+		return stateFrame{}, fmt.Errorf("state frame has %d bytes of reveals, want %d", len(b)-stateFrameHeaderSize, 2*n)
+	}
+	f.Reveals = make([]reveal, n)
+	for i := range f.Reveals {
+		r := b[stateFrameHeaderSize+2*i:]
+		f.Reveals[i] = reveal{Team: r[0], Index: r[1]}
+	}
+	return f, nil
+}
+
+// acceptsStateFrames reports whether req asks for state frames
+// rather than JSON.
+func acceptsStateFrames(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), stateFrameType)
+}
+
+// writeUpdate writes update to rw in the encoding that req asks
+// for: a state frame, or JSON.
+func writeUpdate(rw http.ResponseWriter, req *http.Request, update GameUpdate) {
+	rw.Header().Set("Vary", "Accept")
+	if !acceptsStateFrames(req) {
+		writeJSON(rw, update)
+		return
+	}
+	rw.Header().Set("Content-Type", stateFrameType)
+	rw.Write(encodeStateFrame(update.stateFrame()))
+}
@@ -0,0 +1,83 @@
+package gameapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestStateFrameRoundTrip(t *testing.T) {
+	for _, f := range []stateFrame{
+		{Reveals: []reveal{}},
+		{Seed: math.MinInt64, LastEvent: math.MaxUint32, ExposedOne: 1<<25 - 1, ExposedTwo: 1, PollAfterMS: 5000, Reveals: []reveal{}},
+		{Seed: 1234567890, LastEvent: 7, ExposedOne: 1 << 24, Reveals: []reveal{{TeamOne, 3}, {TeamTwo, 24}}},
+	} {
+		b := encodeStateFrame(f)
+		if len(b) != stateFrameHeaderSize+2*len(f.Reveals) {
+			t.Errorf("encodeStateFrame(%+v) is %d bytes, want %d", f, len(b), stateFrameHeaderSize+2*len(f.Reveals))
+		}
+		got, err := decodeStateFrame(b)
+		if err != nil || !reflect.DeepEqual(got, f) {
+			t.Errorf("decodeStateFrame(encodeStateFrame(%+v)) = %+v, %v", f, got, err)
+		}
+	}
+}
+
+func TestStateFrameInvalid(t *testing.T) {
+	valid := encodeStateFrame(stateFrame{Seed: 1, Reveals: []reveal{{TeamOne, 2}}})
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[0] = 2
+	beyondBoard := encodeStateFrame(stateFrame{ExposedTwo: 1 << 25})
+
+	for name, b := range map[string][]byte{
+		"empty":         {},
+		"short header":  valid[:stateFrameHeaderSize-1],
+		"wrong version": wrongVersion,
+		"beyond board":  beyondBoard,
+		"short reveals": valid[:len(valid)-1],
+		"extra bytes":   append(append([]byte{}, valid...), 0),
+	} {
+		if _, err := decodeStateFrame(b); err == nil {
+			t.Errorf("decodeStateFrame(%s) = nil error, want error", name)
+		}
+	}
+}
+
+func TestEventsStateFrame(t *testing.T) {
+	h := Handler(map[string][]string{"example": exampleWords})
+	seed := newTestGame(t, h, "foo")
+	g := mustGet(t, h, "foo")
+	green := indexOf(g.TwoLayout, Green, func(int) bool { return false })
+	post(h, "/guess", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne, "index": green})
+
+	b, _ := json.Marshal(map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	req := httptest.NewRequest("POST", "/events", bytes.NewReader(b))
+	req.Header.Set("Accept", stateFrameType)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != 200 || rw.Header().Get("Content-Type") != stateFrameType {
+		t.Fatalf("POST /events = %d %s, want 200 %s: %s", rw.Code, rw.Header().Get("Content-Type"), stateFrameType, rw.Body)
+	}
+	f, err := decodeStateFrame(rw.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Seed != g.Seed || f.ExposedTwo != 1<<green || f.ExposedOne != 0 {
+		t.Errorf("frame = %+v, want seed %d with cell %d exposed for team two", f, g.Seed, green)
+	}
+	if want := []reveal{{TeamOne, uint8(green)}}; !reflect.DeepEqual(f.Reveals, want) {
+		t.Errorf("reveals = %+v, want %+v", f.Reveals, want)
+	}
+	if int(f.LastEvent) != len(g.Events) {
+		t.Errorf("last event = %d, want %d", f.LastEvent, len(g.Events))
+	}
+
+	// Clients that don't ask for frames still get JSON.
+	rw = post(h, "/events", map[string]interface{}{"game_id": "foo", "seed": seed, "player_id": "alice", "team": TeamOne})
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("POST /events without Accept has Content-Type %q, want application/json", ct)
+	}
+}
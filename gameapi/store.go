@@ -0,0 +1,107 @@
+package gameapi
+
+import "sync"
+
+// A Store holds the games known to the handler, keyed by game ID.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the game with the provided ID, if it exists.
+	Get(id string) (*Game, bool)
+	// Put stores g under the provided ID, replacing any
+	// existing game with that ID.
+	Put(id string, g *Game)
+	// Save records changes made to g, which was retrieved
+	// from the store under the provided ID. It's called with
+	// g's lock held. If the game stored under the ID has since
+	// been replaced, Save must do nothing.
+	Save(id string, g *Game)
+	// Delete removes the game with the provided ID.
+	Delete(id string)
+	// Range calls fn for each game until fn returns false.
+	// The store must not hold any locks while calling fn.
+	Range(fn func(id string, g *Game) bool)
+	// Prune deletes every game for which expired returns
+	// true, returning the number of games deleted. Like
+	// Range, the store must not hold any locks while
+	// calling expired.
+	Prune(expired func(id string, g *Game) bool) int
+}
+
+// WithStore configures the handler to keep its games in s.
+// By default, games are kept in memory.
+func WithStore(s Store) Option {
+	return func(h *handler) {
+		h.games = s
+	}
+}
+
+// NewMemoryStore returns a Store that keeps games in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{games: make(map[string]*Game)}
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	games map[string]*Game
+}
+
+func (s *memoryStore) Get(id string) (*Game, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.games[id]
+	return g, ok
+}
+
+func (s *memoryStore) Put(id string, g *Game) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.games[id] = g
+}
+
+// Save does nothing, because changes to an in-memory
+// game are already reflected in the store.
+func (s *memoryStore) Save(id string, g *Game) {}
+
+func (s *memoryStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.games, id)
+}
+
+func (s *memoryStore) Range(fn func(id string, g *Game) bool) {
+	for id, g := range s.snapshot() {
+		if !fn(id, g) {
+			return
+		}
+	}
+}
+
+func (s *memoryStore) Prune(expired func(id string, g *Game) bool) (deleted int) {
+	for id, g := range s.snapshot() {
+		if !expired(id, g) {
+			continue
+		}
+
+		// Only delete the game if it wasn't replaced
+		// while we weren't holding the lock.
+		s.mu.Lock()
+		if s.games[id] == g {
+			delete(s.games, id)
+			deleted++
+		}
+		s.mu.Unlock()
+	}
+	return deleted
+}
+
+// snapshot returns a copy of the games map, so that callers
+// may iterate over it without holding the lock.
+func (s *memoryStore) snapshot() map[string]*Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	games := make(map[string]*Game, len(s.games))
+	for id, g := range s.games {
+		games[id] = g
+	}
+	return games
+}
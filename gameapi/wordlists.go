@@ -0,0 +1,170 @@
+package gameapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// wordlistFetchTimeout bounds how long we'll wait to fetch
+	// a single remote word list.
+	wordlistFetchTimeout = 30 * time.Second
+	// maxWordlistSize bounds the size of a single remote word list.
+	maxWordlistSize = 8 << 20 // 8 MiB
+)
+
+// WordlistsFromURLs fetches each of the named word lists over HTTP(S).
+// Each list is parsed as one word per line, and the returned lists are
+// sorted and deduplicated like those returned by DefaultWordlists.
+func WordlistsFromURLs(urls map[string]string) (map[string][]string, error) {
+	lists := map[string][]string{}
+	for name, url := range urls {
+		words, err := fetchWordlist(url)
+		if err != nil {
+			return nil, fmt.Errorf("loading word list %q from %s: %w", name, url, err)
+		}
+		lists[name] = words
+	}
+	return lists, nil
+}
+
+func fetchWordlist(url string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), wordlistFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// Read one byte past the limit so that we can tell
+	// an oversized list apart from one that's exactly
+	// at the limit.
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxWordlistSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxWordlistSize {
+		return nil, fmt.Errorf("word list exceeds %d bytes", maxWordlistSize)
+	}
+	return parseWordlist(bytes.NewReader(b))
+}
+
+// parseWordlist parses a list of UTF-8 words, one per line,
+// returning the sorted, deduplicated words. A leading byte order
+// mark and lines that aren't valid UTF-8 are ignored.
+func parseWordlist(r io.Reader) ([]string, error) {
+	seen := map[string]bool{}
+	words := []string{}
+	s := bufio.NewScanner(r)
+	for first := true; s.Scan(); first = false {
+		line := s.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		w := normalizeWord(line)
+		if w == "" || seen[w] || !utf8.ValidString(w) {
+			continue
+		}
+		seen[w] = true
+		words = append(words, w)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(words)
+	return words, nil
+}
+
+// normalizeWord returns w as it appears in a loaded word list.
+func normalizeWord(w string) string {
+	return strings.TrimSpace(w)
+}
+
+// splitDifficulties separates the difficulty ratings from the
+// words in lists. A word may be followed by a tab and an integer
+// difficulty rating, such as "apple\t2"; words without one are
+// left unrated. It returns the lists of plain words, sorted and
+// deduplicated, along with the rating of each rated word. A word
+// that's rated differently by two lists gets the higher rating.
+func splitDifficulties(lists map[string][]string) (map[string][]string, map[string]int) {
+	plain := make(map[string][]string, len(lists))
+	ratings := map[string]int{}
+	for name, list := range lists {
+		seen := map[string]bool{}
+		words := make([]string, 0, len(list))
+		for _, entry := range list {
+			w := entry
+			if i := strings.IndexByte(entry, '\t'); i >= 0 {
+				w = normalizeWord(entry[:i])
+				d, err := strconv.Atoi(strings.TrimSpace(entry[i+1:]))
+				if err == nil {
+					if prev, ok := ratings[w]; !ok || d > prev {
+						ratings[w] = d
+					}
+				}
+			}
+			if w == "" || seen[w] {
+				continue
+			}
+			seen[w] = true
+			words = append(words, w)
+		}
+		sort.Strings(words)
+		plain[name] = words
+	}
+	return plain, ratings
+}
+
+// WordlistMetadata describes a word list for display to players.
+type WordlistMetadata struct {
+	// Label is the list's display name. If empty, the
+	// list's name is used instead.
+	Label string `json:"label"`
+	// Category groups related lists together, for example
+	// "kids" or "themed".
+	Category string `json:"category"`
+}
+
+// DefaultWordlistMetadata loads the optional metadata for the
+// default word lists from wordlists/meta.json, which maps each
+// list's name to its metadata. If the file doesn't exist, it
+// returns an empty map.
+func DefaultWordlistMetadata() (map[string]WordlistMetadata, error) {
+	return loadWordlistMetadata("wordlists/meta.json")
+}
+
+func loadWordlistMetadata(path string) (map[string]WordlistMetadata, error) {
+	meta := map[string]WordlistMetadata{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return meta, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,162 @@
+package gameapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWordlistsFromURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/animals.txt":
+			fmt.Fprint(rw, "ZEBRA\nAARDVARK\n\n  LION \nZEBRA\n")
+		case "/huge.txt":
+			fmt.Fprint(rw, strings.Repeat("A", maxWordlistSize+1))
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer srv.Close()
+
+	lists, err := WordlistsFromURLs(map[string]string{"animals": srv.URL + "/animals.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"animals": {"AARDVARK", "LION", "ZEBRA"}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("WordlistsFromURLs = %v, want %v", lists, want)
+	}
+
+	for _, path := range []string{"/missing.txt", "/huge.txt"} {
+		url := srv.URL + path
+		_, err := WordlistsFromURLs(map[string]string{"animals": url})
+		if err == nil || !strings.Contains(err.Error(), url) {
+			t.Errorf("WordlistsFromURLs(%s) error = %v, want error naming the URL", url, err)
+		}
+	}
+}
+
+func TestLoadWordlistMetadata(t *testing.T) {
+	dir := t.TempDir()
+	meta, err := loadWordlistMetadata(filepath.Join(dir, "meta.json"))
+	if err != nil || len(meta) != 0 {
+		t.Errorf("loadWordlistMetadata(missing) = %v, %v, want empty map", meta, err)
+	}
+
+	path := filepath.Join(dir, "meta.json")
+	err = os.WriteFile(path, []byte(`{"animals": {"label": "Animals", "category": "kids"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err = loadWordlistMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]WordlistMetadata{"animals": {Label: "Animals", Category: "kids"}}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("loadWordlistMetadata = %v, want %v", meta, want)
+	}
+}
+
+func TestParseWordlistUnicode(t *testing.T) {
+	words, err := parseWordlist(strings.NewReader("\ufeff東京\n😀\n ÉTÉ\n\xff\xfe\n東京\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"ÉTÉ", "東京", "😀"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("parseWordlist = %q, want %q", words, want)
+	}
+}
+
+func TestLoadWordlists(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"green.txt":   "ZEBRA\nAARDVARK\n",
+		"animals.txt": "\ufeffLION\n\xff\xfe\n",
+		"empty.txt":   "\n",
+		"notes.otxt":  "NOTE\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory can't be read as a word list.
+	if err := os.Mkdir(filepath.Join(dir, "broken.txt"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	lists, skipped, err := LoadWordlists(dir, "green")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"green": {"AARDVARK", "ZEBRA"}, "animals": {"LION"}}
+	if !reflect.DeepEqual(lists, want) {
+		t.Errorf("LoadWordlists = %v, want %v", lists, want)
+	}
+	if len(skipped) != 2 || skipped["broken"] == nil || skipped["empty"] == nil {
+		t.Errorf("LoadWordlists skipped %v, want broken and empty", skipped)
+	}
+
+	if _, _, err := LoadWordlists(dir, "missing"); err == nil {
+		t.Errorf("LoadWordlists with a missing required list succeeded, want error")
+	}
+	if _, _, err := LoadWordlists(t.TempDir()); err == nil {
+		t.Errorf("LoadWordlists with no lists succeeded, want error")
+	}
+}
+
+func TestWordDifficulty(t *testing.T) {
+	// Rate every other word, leaving the rest plain.
+	var b strings.Builder
+	want := map[string]int{}
+	for i := 0; i < 30; i++ {
+		w := fmt.Sprintf("WORD%02d", i)
+		if i%2 == 0 {
+			want[w] = i%5 + 1
+			fmt.Fprintf(&b, "%s\t%d\n", w, want[w])
+		} else {
+			fmt.Fprintf(&b, "%s\n", w)
+		}
+	}
+	words, err := parseWordlist(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handler(map[string][]string{"rated": words})
+	rw := post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	g := mustGet(t, h, "foo")
+	if len(g.WordSet) != 30 {
+		t.Errorf("len(WordSet) = %d, want 30", len(g.WordSet))
+	}
+	for _, w := range g.WordSet {
+		if strings.Contains(w, "\t") {
+			t.Errorf("WordSet contains annotated word %q", w)
+		}
+	}
+	if len(g.Difficulties) != len(g.Words) {
+		t.Fatalf("len(Difficulties) = %d, want %d", len(g.Difficulties), len(g.Words))
+	}
+	for i, w := range g.Words {
+		if g.Difficulties[i] != want[w] {
+			t.Errorf("difficulty of %q = %d, want %d", w, g.Difficulties[i], want[w])
+		}
+	}
+
+	// Unrated word lists don't report difficulties.
+	h = Handler(map[string][]string{"example": exampleWords})
+	post(h, "/new-game", map[string]interface{}{"game_id": "foo"})
+	if d := mustGet(t, h, "foo").Difficulties; d != nil {
+		t.Errorf("Difficulties = %v, want nil", d)
+	}
+}
@@ -0,0 +1,246 @@
+package gameapi
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// wordlistStore provides the handler's word lists by name.
+type wordlistStore interface {
+	// names returns the names of the lists, sorted.
+	names() []string
+	// count returns the number of words in the named list,
+	// without necessarily loading it.
+	count(name string) (int, bool)
+	// list returns the named list's words, sorted, along with
+	// the difficulty ratings of its rated words.
+	list(name string) (words []string, ratings map[string]int, ok bool)
+}
+
+// staticWordlists is a wordlistStore of lists that are loaded up
+// front, as passed to Handler.
+type staticWordlists struct {
+	lists   map[string][]string
+	ratings map[string]int
+}
+
+func (s staticWordlists) names() []string {
+	names := make([]string, 0, len(s.lists))
+	for name := range s.lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s staticWordlists) count(name string) (int, bool) {
+	words, ok := s.lists[name]
+	return len(words), ok
+}
+
+func (s staticWordlists) list(name string) ([]string, map[string]int, bool) {
+	words, ok := s.lists[name]
+	return words, s.ratings, ok
+}
+
+// A WordlistLoader loads word lists on demand. See WithLazyWordlists.
+type WordlistLoader interface {
+	// Names returns the names of the lists that may be loaded.
+	Names() ([]string, error)
+	// Count returns the number of words in the named list. It
+	// should be cheaper than loading the list, and may count
+	// words that loading would skip, such as duplicates.
+	Count(name string) (int, error)
+	// Load returns the words of the named list. Like the lists
+	// passed to Handler, each word may be followed by a tab and
+	// a difficulty rating.
+	Load(name string) ([]string, error)
+}
+
+// NewFSWordlistLoader returns a WordlistLoader that loads each of
+// the .txt files in the root of fsys as a word list named after the
+// file, with one word per line.
+func NewFSWordlistLoader(fsys fs.FS) WordlistLoader {
+	return fsWordlistLoader{fsys}
+}
+
+type fsWordlistLoader struct {
+	fsys fs.FS
+}
+
+func (l fsWordlistLoader) Names() ([]string, error) {
+	matches, err := fs.Glob(l.fsys, "*.txt")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(m, ".txt"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// open opens the named list's file. Only files in the root of
+// l.fsys are lists.
+func (l fsWordlistLoader) open(name string) (fs.File, error) {
+	if strings.Contains(name, "/") {
+		return nil, fs.ErrNotExist
+	}
+	return l.fsys.Open(name + ".txt")
+}
+
+func (l fsWordlistLoader) Count(name string) (int, error) {
+	f, err := l.open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	n := 0
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) != "" {
+			n++
+		}
+	}
+	return n, s.Err()
+}
+
+func (l fsWordlistLoader) Load(name string) ([]string, error) {
+	f, err := l.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseWordlist(f)
+}
+
+// lazyWordlists is a wordlistStore that loads lists on first use,
+// keeping at most maxLoaded of them in memory. The least recently
+// used list is evicted to make room for another. Lists are read
+// without holding mu, and concurrent requests for a list that's
+// being read wait for that read rather than starting another.
+type lazyWordlists struct {
+	loader    WordlistLoader
+	maxLoaded int
+
+	mu      sync.Mutex
+	counts  map[string]int
+	loaded  map[string]*list.Element // of *loadedWordlist
+	lru     *list.List               // most recently used first
+	loading map[string]*pendingWordlist
+}
+
+type loadedWordlist struct {
+	name    string
+	words   []string
+	ratings map[string]int
+}
+
+// pendingWordlist is a list that's being read. l is set, or left
+// nil if the read failed, before done is closed.
+type pendingWordlist struct {
+	done chan struct{}
+	l    *loadedWordlist
+}
+
+func newLazyWordlists(loader WordlistLoader, maxLoaded int) *lazyWordlists {
+	if maxLoaded < 1 {
+		maxLoaded = 1
+	}
+	return &lazyWordlists{
+		loader:    loader,
+		maxLoaded: maxLoaded,
+		counts:    map[string]int{},
+		loaded:    map[string]*list.Element{},
+		lru:       list.New(),
+		loading:   map[string]*pendingWordlist{},
+	}
+}
+
+func (s *lazyWordlists) names() []string {
+	names, err := s.loader.Names()
+	if err != nil {
+		log.Printf("listing word lists: %s", err)
+		return []string{}
+	}
+	return names
+}
+
+func (s *lazyWordlists) count(name string) (int, bool) {
+	s.mu.Lock()
+	n, ok := s.counts[name]
+	s.mu.Unlock()
+	if ok {
+		return n, true
+	}
+
+	n, err := s.loader.Count(name)
+	if err != nil {
+		return 0, false
+	}
+	s.mu.Lock()
+	s.counts[name] = n
+	s.mu.Unlock()
+	return n, true
+}
+
+func (s *lazyWordlists) list(name string) ([]string, map[string]int, bool) {
+	s.mu.Lock()
+	if e, ok := s.loaded[name]; ok {
+		s.lru.MoveToFront(e)
+		l := e.Value.(*loadedWordlist)
+		s.mu.Unlock()
+		return l.words, l.ratings, true
+	}
+	if p, ok := s.loading[name]; ok {
+		s.mu.Unlock()
+		<-p.done
+		if p.l == nil {
+			return nil, nil, false
+		}
+		return p.l.words, p.l.ratings, true
+	}
+	p := &pendingWordlist{done: make(chan struct{})}
+	s.loading[name] = p
+	s.mu.Unlock()
+
+	p.l = s.load(name)
+
+	s.mu.Lock()
+	delete(s.loading, name)
+	if p.l != nil {
+		s.loaded[name] = s.lru.PushFront(p.l)
+		for s.lru.Len() > s.maxLoaded {
+			oldest := s.lru.Remove(s.lru.Back()).(*loadedWordlist)
+			delete(s.loaded, oldest.name)
+		}
+	}
+	s.mu.Unlock()
+	close(p.done)
+
+	if p.l == nil {
+		return nil, nil, false
+	}
+	return p.l.words, p.l.ratings, true
+}
+
+// load reads the named list, returning nil if it can't be read.
+func (s *lazyWordlists) load(name string) *loadedWordlist {
+	entries, err := s.loader.Load(name)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("loading word list %q: %s", name, err)
+		}
+		return nil
+	}
+	lists, ratings := splitDifficulties(map[string][]string{name: entries})
+	return &loadedWordlist{name: name, words: lists[name], ratings: ratings}
+}
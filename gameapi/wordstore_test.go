@@ -0,0 +1,181 @@
+package gameapi
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeLoader is a WordlistLoader that counts the lists it loads.
+type fakeLoader struct {
+	lists map[string][]string
+	loads map[string]int
+}
+
+func (l *fakeLoader) Names() ([]string, error) {
+	return staticWordlists{lists: l.lists}.names(), nil
+}
+
+func (l *fakeLoader) Count(name string) (int, error) {
+	words, ok := l.lists[name]
+	if !ok {
+		return 0, fs.ErrNotExist
+	}
+	return len(words), nil
+}
+
+func (l *fakeLoader) Load(name string) ([]string, error) {
+	words, ok := l.lists[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	l.loads[name]++
+	return words, nil
+}
+
+func TestLazyWordlistsEviction(t *testing.T) {
+	loader := &fakeLoader{
+		lists: map[string][]string{"a": {"A"}, "b": {"B"}, "c": {"C"}},
+		loads: map[string]int{},
+	}
+	s := newLazyWordlists(loader, 2)
+	for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+		if _, _, ok := s.list(name); !ok {
+			t.Fatalf("list(%q) failed", name)
+		}
+	}
+	// Loading c evicted b, the least recently used list.
+	want := map[string]int{"a": 1, "b": 2, "c": 1}
+	if !reflect.DeepEqual(loader.loads, want) {
+		t.Errorf("loads = %v, want %v", loader.loads, want)
+	}
+	if _, _, ok := s.list("d"); ok {
+		t.Error("list of an unknown name succeeded")
+	}
+}
+
+// blockingLoader is a WordlistLoader whose loads of each list wait
+// for that list's release channel to be closed.
+type blockingLoader struct {
+	fakeLoader
+	started chan string
+	release map[string]chan struct{}
+
+	mu sync.Mutex
+}
+
+func (l *blockingLoader) Load(name string) ([]string, error) {
+	l.started <- name
+	<-l.release[name]
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fakeLoader.Load(name)
+}
+
+func TestLazyWordlistsConcurrentLoads(t *testing.T) {
+	loader := &blockingLoader{
+		fakeLoader: fakeLoader{
+			lists: map[string][]string{"a": {"A"}, "b": {"B"}},
+			loads: map[string]int{},
+		},
+		started: make(chan string, 10),
+		release: map[string]chan struct{}{"a": make(chan struct{}), "b": make(chan struct{})},
+	}
+	s := newLazyWordlists(loader, 2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if words, _, ok := s.list("a"); !ok || !reflect.DeepEqual(words, []string{"A"}) {
+				t.Errorf("list(a) = %q, %v", words, ok)
+			}
+		}()
+	}
+	if name := <-loader.started; name != "a" {
+		t.Fatalf("started loading %q, want a", name)
+	}
+	// Reading a doesn't hold up counting or reading other lists.
+	if n, ok := s.count("b"); !ok || n != 1 {
+		t.Errorf("count(b) = %d, %v, want 1", n, ok)
+	}
+	close(loader.release["b"])
+	if words, _, ok := s.list("b"); !ok || !reflect.DeepEqual(words, []string{"B"}) {
+		t.Errorf("list(b) = %q, %v", words, ok)
+	}
+	close(loader.release["a"])
+	wg.Wait()
+
+	want := map[string]int{"a": 1, "b": 1}
+	if !reflect.DeepEqual(loader.loads, want) {
+		t.Errorf("loads = %v, want %v", loader.loads, want)
+	}
+}
+
+func TestLazyWordlistsHandler(t *testing.T) {
+	loader := &fakeLoader{
+		lists: map[string][]string{"animals": exampleWords[:30], "example": exampleWords},
+		loads: map[string]int{},
+	}
+	h := Handler(nil, WithLazyWordlists(loader, 1))
+
+	// The summary doesn't load the lists.
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/word-lists", nil))
+	var resp struct {
+		WordLists []struct {
+			Name  string `json:"name"`
+			Count int    `json:"count"`
+		} `json:"word_lists"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.WordLists) != 2 || resp.WordLists[0].Count != 30 || resp.WordLists[1].Count != len(exampleWords) {
+		t.Errorf("GET /word-lists = %s", rw.Body)
+	}
+	if len(loader.loads) != 0 {
+		t.Errorf("GET /word-lists loaded %v", loader.loads)
+	}
+
+	rw = post(h, "/new-game", map[string]interface{}{"game_id": "foo", "word_lists": []string{"animals"}})
+	if rw.Code != 200 {
+		t.Fatalf("POST /new-game = %d, want 200: %s", rw.Code, rw.Body)
+	}
+	if got := mustGet(t, h, "foo").WordSet; !reflect.DeepEqual(got, exampleWords[:30]) {
+		t.Errorf("WordSet = %v, want the animals list", got)
+	}
+}
+
+func TestFSWordlistLoader(t *testing.T) {
+	loader := NewFSWordlistLoader(fstest.MapFS{
+		"animals.txt":   {Data: []byte("ZEBRA\n\nLION\nZEBRA\n")},
+		"sub/plant.txt": {Data: []byte("FERN\n")},
+		"README":        {Data: []byte("not a list\n")},
+	})
+	names, err := loader.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"animals"}) {
+		t.Errorf("Names = %q, want [animals]", names)
+	}
+	if n, err := loader.Count("animals"); err != nil || n != 3 {
+		t.Errorf("Count(animals) = %d, %v, want 3", n, err)
+	}
+	words, err := loader.Load("animals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(words, []string{"LION", "ZEBRA"}) {
+		t.Errorf("Load(animals) = %q, want [LION ZEBRA]", words)
+	}
+	if _, err := loader.Load("sub/plant"); err == nil {
+		t.Error("Load(sub/plant) succeeded, want an error")
+	}
+}